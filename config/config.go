@@ -0,0 +1,374 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config параметры приложения. Значения собираются по порядку из значений по умолчанию,
+// файла конфигурации, переменных окружения и флагов командной строки (см. Load).
+type Config struct {
+	Server   ServerConfig
+	TLS      TLSConfig
+	Database DatabaseConfig
+	Storage  StorageConfig
+	Uploads  UploadsConfig
+	Sessions SessionsConfig
+	Login    LoginConfig
+	Password PasswordConfig
+	LDAP     LDAPConfig
+	OIDC     OIDCConfig
+	Metrics  MetricsConfig
+}
+
+// ServerConfig параметры HTTP-сервера
+type ServerConfig struct {
+	Listen    string // адрес вида ":8080" или "127.0.0.1:8080"
+	StaticDir string
+	// Таймауты соединения, 0 - без ограничения. ReadTimeout и WriteTimeout ограничивают
+	// весь запрос вместе с телом и по умолчанию выключены: иначе они обрывают загрузку
+	// и скачивание больших файлов по медленному каналу. От медленной отправки заголовков
+	// защищает ReadHeaderTimeout, от брошенных соединений - IdleTimeout.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout сколько ждать завершения активных запросов после SIGTERM
+	ShutdownTimeout time.Duration
+}
+
+// TLSConfig HTTPS-сервер. Сертификат перечитывается по SIGHUP без перезапуска.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// RedirectListen адрес, на котором HTTP-запросы перенаправляются на HTTPS (например ":80");
+	// пустое значение - не слушать HTTP
+	RedirectListen string
+}
+
+// Enabled сообщает, что сервер работает по HTTPS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// DatabaseConfig параметры базы данных SQLite
+type DatabaseConfig struct {
+	Path string
+}
+
+// StorageConfig хранилище содержимого файлов
+type StorageConfig struct {
+	Backend string // "local" или "s3"
+	// LocalDir папка файлов для backend=local; в ней же лежат незавершенные tus-загрузки
+	// при любом backend
+	LocalDir string
+	S3       S3Config
+}
+
+// S3Config параметры S3-совместимого хранилища
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	Prefix    string
+}
+
+// UploadsConfig ограничения загрузки файлов
+type UploadsConfig struct {
+	// MaxSize предельный размер одного файла; форма с файлом больше отклоняется с кодом 413.
+	// Форма с несколькими файлами целиком тоже не больше MaxSize (tus загружает каждый файл отдельно).
+	// Если задан server.read_timeout, файл размером MaxSize должен успеть загрузиться за это время.
+	// В S3 форма загружается частями по 16 МБ, а частей не больше 10000, поэтому там файл
+	// из формы не может быть больше 156 ГБ.
+	MaxSize Size
+	// MaxFiles сколько файлов можно отправить одной формой
+	MaxFiles int
+	// MaxVersions сколько версий файла хранить, 0 - без ограничения
+	MaxVersions int
+}
+
+// SessionsConfig время жизни сессий входа
+type SessionsConfig struct {
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+}
+
+// LoginConfig защита от подбора паролей
+type LoginConfig struct {
+	FreeAttempts     int
+	IPFreeAttempts   int
+	MaxDelay         time.Duration
+	LockoutThreshold int // 0 отключает блокировку учетной записи
+	LockoutDuration  time.Duration
+	FailureWindow    time.Duration
+}
+
+// PasswordConfig правила сложности паролей
+type PasswordConfig struct {
+	MinLength        int
+	RequireMixedCase bool
+	RequireDigit     bool
+	RequireSpecial   bool
+}
+
+// LDAPConfig вход через LDAP/Active Directory, включается параметром URL
+type LDAPConfig struct {
+	URL                string // ldap://host:389 или ldaps://host:636
+	StartTLS           bool
+	CAFile             string // сертификаты CA в PEM для проверки сервера каталога
+	InsecureSkipVerify bool
+	// BindDN и BindPassword служебной учетной записи для поиска; пустой BindDN - анонимный поиск
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter фильтр поиска пользователя, {username} заменяется введенным именем
+	UserFilter        string
+	UsernameAttribute string
+	GroupAttribute    string
+	// GroupFilter, если задан, ищет группы отдельно; {dn} заменяется DN пользователя, {username} - именем
+	GroupFilter string
+	GroupBaseDN string
+	// RoleMapping проверяется по порядку, побеждает первое совпадение
+	RoleMapping []LDAPRoleMapping
+	// DefaultRole роль пользователя без подходящей группы; пустая строка запрещает ему вход
+	DefaultRole string
+	Timeout     time.Duration
+}
+
+// Enabled сообщает, что вход через каталог включен
+func (c LDAPConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+// LDAPRoleMapping сопоставляет группу каталога (DN или только cn) роли приложения
+type LDAPRoleMapping struct {
+	Group string
+	Role  string
+}
+
+// ParseRoleMapping разбирает список вида "группа:роль;группа:роль". Группа отделяется
+// от роли последним двоеточием, поэтому в DN группы двоеточия допустимы.
+func ParseRoleMapping(value string) ([]LDAPRoleMapping, error) {
+	var mapping []LDAPRoleMapping
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		sep := strings.LastIndex(item, ":")
+		if sep <= 0 || sep == len(item)-1 {
+			return nil, fmt.Errorf("invalid role mapping entry %q, expected group:role", item)
+		}
+		mapping = append(mapping, LDAPRoleMapping{
+			Group: strings.TrimSpace(item[:sep]),
+			Role:  strings.TrimSpace(item[sep+1:]),
+		})
+	}
+	return mapping, nil
+}
+
+// formatRoleMapping записывает сопоставление в том виде, который понимает ParseRoleMapping
+func formatRoleMapping(mapping []LDAPRoleMapping) string {
+	items := make([]string, len(mapping))
+	for i, m := range mapping {
+		items[i] = m.Group + ":" + m.Role
+	}
+	return strings.Join(items, ";")
+}
+
+// OIDCConfig единый вход через OpenID Connect, включается параметром Issuer
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // пустой для публичного клиента
+	// RedirectURL адрес /login/oidc/callback этого приложения
+	RedirectURL string
+	// Scopes через пробел; openid добавляется, если его нет
+	Scopes      string
+	DisplayName string // название на кнопке входа
+	// UsernameClaim утверждение с именем пользователя, GroupsClaim - со списком групп или ролей
+	// (можно указать путь через точку)
+	UsernameClaim string
+	GroupsClaim   string
+	// Значения GroupsClaim, дающие права. Пустой DownloadValues дает право скачивания всем.
+	AdminValues    []string
+	UploadValues   []string
+	DownloadValues []string
+}
+
+// Enabled сообщает, что единый вход включен
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// MetricsConfig периодическое обновление метрик и очистка устаревших данных
+type MetricsConfig struct {
+	Interval time.Duration
+}
+
+// Default возвращает конфигурацию по умолчанию
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Listen:            ":8080",
+			StaticDir:         "./static",
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   time.Minute,
+		},
+		Database: DatabaseConfig{Path: "./data.db"},
+		Storage:  StorageConfig{Backend: "local", LocalDir: "./uploads"},
+		Uploads: UploadsConfig{
+			MaxSize:     10 * GB,
+			MaxFiles:    100,
+			MaxVersions: 10,
+		},
+		Sessions: SessionsConfig{IdleTimeout: 2 * time.Hour, AbsoluteTimeout: 24 * time.Hour},
+		Login: LoginConfig{
+			FreeAttempts:     3,
+			IPFreeAttempts:   20,
+			MaxDelay:         5 * time.Minute,
+			LockoutThreshold: 10,
+			LockoutDuration:  15 * time.Minute,
+			FailureWindow:    15 * time.Minute,
+		},
+		Password: PasswordConfig{MinLength: 8},
+		LDAP: LDAPConfig{
+			UserFilter:        "(uid={username})",
+			UsernameAttribute: "uid",
+			GroupAttribute:    "memberOf",
+			DefaultRole:       "downloader",
+			Timeout:           10 * time.Second,
+		},
+		OIDC: OIDCConfig{
+			Scopes:        "openid profile email",
+			DisplayName:   "Single Sign-On",
+			UsernameClaim: "preferred_username",
+			GroupsClaim:   "groups",
+		},
+		Metrics: MetricsConfig{Interval: 30 * time.Second},
+	}
+}
+
+// Validate проверяет конфигурацию и возвращает все найденные ошибки сразу
+func (c *Config) Validate() error {
+	var problems []error
+	check := func(ok bool, key, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(c.Server.Listen)
+	check(err == nil, "server.listen", "invalid address %q, expected host:port", c.Server.Listen)
+	check(c.Server.StaticDir != "", "server.static_dir", "must not be empty")
+	check(c.Server.ReadHeaderTimeout >= 0, "server.read_header_timeout", "must not be negative")
+	check(c.Server.ReadTimeout >= 0, "server.read_timeout", "must not be negative")
+	check(c.Server.WriteTimeout >= 0, "server.write_timeout", "must not be negative")
+	check(c.Server.IdleTimeout >= 0, "server.idle_timeout", "must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls", "cert_file and key_file must be set together")
+	if c.TLS.RedirectListen != "" {
+		_, _, err := net.SplitHostPort(c.TLS.RedirectListen)
+		check(err == nil, "tls.redirect_listen", "invalid address %q, expected host:port", c.TLS.RedirectListen)
+		check(c.TLS.Enabled(), "tls.redirect_listen", "requires tls.cert_file and tls.key_file")
+	}
+
+	check(c.Database.Path != "", "database.path", "must not be empty")
+
+	check(c.Storage.LocalDir != "", "storage.local_dir", "must not be empty")
+	switch c.Storage.Backend {
+	case "local":
+	case "s3":
+		check(c.Storage.S3.Endpoint != "", "storage.s3.endpoint", "required for the s3 backend")
+		check(c.Storage.S3.Bucket != "", "storage.s3.bucket", "required for the s3 backend")
+	default:
+		check(false, "storage.backend", "unknown backend %q, expected local or s3", c.Storage.Backend)
+	}
+
+	check(c.Uploads.MaxSize > 0, "uploads.max_size", "must be positive")
+	check(c.Uploads.MaxFiles >= 1, "uploads.max_files", "must be at least 1")
+	check(c.Uploads.MaxVersions >= 0, "uploads.max_versions", "must not be negative")
+
+	check(c.Sessions.IdleTimeout > 0, "sessions.idle_timeout", "must be positive")
+	check(c.Sessions.AbsoluteTimeout >= c.Sessions.IdleTimeout, "sessions.absolute_timeout",
+		"must not be shorter than sessions.idle_timeout")
+
+	check(c.Login.FreeAttempts >= 0, "login.free_attempts", "must not be negative")
+	check(c.Login.IPFreeAttempts >= 0, "login.ip_free_attempts", "must not be negative")
+	check(c.Login.MaxDelay >= time.Second, "login.max_delay", "must be at least 1s")
+	check(c.Login.LockoutThreshold >= 0, "login.lockout_threshold", "must not be negative")
+	check(c.Login.LockoutDuration > 0, "login.lockout_duration", "must be positive")
+	check(c.Login.FailureWindow > 0, "login.failure_window", "must be positive")
+
+	check(c.Password.MinLength >= 1, "password.min_length", "must be at least 1")
+
+	if c.LDAP.Enabled() {
+		check(c.LDAP.BaseDN != "", "ldap.base_dn", "required when ldap.url is set")
+		check(c.LDAP.UserFilter != "", "ldap.user_filter", "must not be empty")
+		check(c.LDAP.UsernameAttribute != "", "ldap.username_attribute", "must not be empty")
+		check(c.LDAP.Timeout > 0, "ldap.timeout", "must be positive")
+	}
+	if c.OIDC.Enabled() {
+		check(c.OIDC.ClientID != "", "oidc.client_id", "required when oidc.issuer is set")
+		check(c.OIDC.RedirectURL != "", "oidc.redirect_url", "required when oidc.issuer is set")
+		check(c.OIDC.UsernameClaim != "", "oidc.username_claim", "must not be empty")
+	}
+	check(c.Metrics.Interval >= time.Second, "metrics.interval", "must be at least 1s")
+
+	return errors.Join(problems...)
+}
+
+// Size размер в байтах. В файле, окружении и флагах записывается числом с необязательной
+// единицей: 1048576, 512KB, 100MB, 10GB (степени 1024).
+type Size int64
+
+// Единицы размера
+const (
+	B  Size = 1
+	KB      = 1024 * B
+	MB      = 1024 * KB
+	GB      = 1024 * MB
+	TB      = 1024 * GB
+)
+
+var sizeUnits = []struct {
+	suffix string
+	size   Size
+}{{"TB", TB}, {"GB", GB}, {"MB", MB}, {"KB", KB}, {"B", B}}
+
+// ParseSize разбирает размер вида "100MB"
+func ParseSize(value string) (Size, error) {
+	text := strings.ToUpper(strings.TrimSpace(value))
+	unit := B
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(text, u.suffix) {
+			text = strings.TrimSpace(strings.TrimSuffix(text, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+	number, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || number < 0 || number > int64(TB*1024*1024)/int64(unit) {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return Size(number) * unit, nil
+}
+
+// String записывает размер в самых крупных единицах, в которых он целый
+func (s Size) String() string {
+	for _, u := range sizeUnits {
+		if s != 0 && s%u.size == 0 {
+			return fmt.Sprintf("%d%s", s/u.size, u.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Size
+	}{
+		{"0", 0},
+		{"1048576", MB},
+		{"512B", 512},
+		{"512KB", 512 * KB},
+		{"100mb", 100 * MB},
+		{" 10 GB ", 10 * GB},
+		{"2TB", 2 * TB},
+		{"1048576TB", 1024 * 1024 * TB},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "MB", "-1", "1.5GB", "10PB", "ten", "1048577TB", "9223372036854775807KB"} {
+		if got, err := ParseSize(value); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", value, got)
+		}
+	}
+}
+
+func TestSizeString(t *testing.T) {
+	tests := []struct {
+		size Size
+		want string
+	}{
+		{0, "0"},
+		{1, "1B"},
+		{1536, "1536B"},
+		{512 * KB, "512KB"},
+		{10 * GB, "10GB"},
+		{3 * TB, "3TB"},
+	}
+	for _, tt := range tests {
+		if got := tt.size.String(); got != tt.want {
+			t.Errorf("Size(%d).String() = %q, want %q", int64(tt.size), got, tt.want)
+		}
+		if parsed, err := ParseSize(tt.size.String()); err != nil || parsed != tt.size {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.size.String(), parsed, err, tt.size)
+		}
+	}
+}
+
+func TestParseRoleMapping(t *testing.T) {
+	mapping, err := ParseRoleMapping(" cn=admins,dc=example:admin ; ldap://x:y:uploader;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LDAPRoleMapping{{"cn=admins,dc=example", "admin"}, {"ldap://x:y", "uploader"}}
+	if len(mapping) != len(want) || mapping[0] != want[0] || mapping[1] != want[1] {
+		t.Errorf("ParseRoleMapping() = %+v, want %+v", mapping, want)
+	}
+
+	for _, value := range []string{"admins", ":admin", "admins:"} {
+		if _, err := ParseRoleMapping(value); err == nil {
+			t.Errorf("ParseRoleMapping(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestValidateDefault(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("default configuration is invalid: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{"listen", func(c *Config) { c.Server.Listen = "8080" }, []string{"server.listen"}},
+		{"timeouts", func(c *Config) {
+			c.Server.ReadTimeout = -time.Second
+			c.Server.ShutdownTimeout = 0
+		}, []string{"server.read_timeout", "server.shutdown_timeout"}},
+		{"tls pair", func(c *Config) { c.TLS.CertFile = "cert.pem" }, []string{"tls: cert_file and key_file"}},
+		{"tls redirect", func(c *Config) { c.TLS.RedirectListen = ":80" }, []string{"tls.redirect_listen: requires"}},
+		{"backend", func(c *Config) { c.Storage.Backend = "ftp" }, []string{"storage.backend"}},
+		{"s3", func(c *Config) { c.Storage.Backend = "s3" }, []string{"storage.s3.endpoint", "storage.s3.bucket"}},
+		{"uploads", func(c *Config) {
+			c.Uploads.MaxSize = 0
+			c.Uploads.MaxFiles = 0
+			c.Uploads.MaxVersions = -1
+		}, []string{"uploads.max_size", "uploads.max_files", "uploads.max_versions"}},
+		{"sessions", func(c *Config) { c.Sessions.AbsoluteTimeout = time.Hour }, []string{"sessions.absolute_timeout"}},
+		{"login", func(c *Config) { c.Login.MaxDelay = 0 }, []string{"login.max_delay"}},
+		{"password", func(c *Config) { c.Password.MinLength = 0 }, []string{"password.min_length"}},
+		{"ldap", func(c *Config) { c.LDAP.URL = "ldap://dir" }, []string{"ldap.base_dn"}},
+		{"oidc", func(c *Config) { c.OIDC.Issuer = "https://sso" }, []string{"oidc.client_id", "oidc.redirect_url"}},
+		{"metrics", func(c *Config) { c.Metrics.Interval = time.Millisecond }, []string{"metrics.interval"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.change(cfg)
+			err := cfg.Validate()
+			if err == nil {
+				t.Fatal("Validate() succeeded, want an error")
+			}
+			// Ошибки перечисляются по одной на строку, и других ошибок быть не должно
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.want) {
+				t.Errorf("Validate() = %q, want %d problems", err, len(tt.want))
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() = %q, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// setting описывает один параметр: ключ в файле (он же имя флага), переменную окружения
+// и поле Config, в которое попадает значение
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	secret bool // не задается флагом, в --print-config выводится звездочками
+	field  func(c *Config) interface{}
+}
+
+// settings все параметры в порядке вывода. Имена переменных окружения совпадают с теми,
+// что приложение читало раньше.
+var settings = []setting{
+	{"server.listen", "LISTEN_ADDR", "address to listen on", false, func(c *Config) interface{} { return &c.Server.Listen }},
+	{"server.static_dir", "STATIC_DIR", "directory with static assets", false, func(c *Config) interface{} { return &c.Server.StaticDir }},
+	{"server.read_header_timeout", "SERVER_READ_HEADER_TIMEOUT", "time to read request headers, 0 - unlimited", false, func(c *Config) interface{} { return &c.Server.ReadHeaderTimeout }},
+	{"server.read_timeout", "SERVER_READ_TIMEOUT", "time to read a whole request including uploads, 0 - unlimited", false, func(c *Config) interface{} { return &c.Server.ReadTimeout }},
+	{"server.write_timeout", "SERVER_WRITE_TIMEOUT", "time to handle a request and write the response including downloads, 0 - unlimited", false, func(c *Config) interface{} { return &c.Server.WriteTimeout }},
+	{"server.idle_timeout", "SERVER_IDLE_TIMEOUT", "keep-alive connection idle time, 0 - unlimited", false, func(c *Config) interface{} { return &c.Server.IdleTimeout }},
+	{"server.shutdown_timeout", "SERVER_SHUTDOWN_TIMEOUT", "time to finish active requests on SIGTERM", false, func(c *Config) interface{} { return &c.Server.ShutdownTimeout }},
+	{"tls.cert_file", "TLS_CERT_FILE", "certificate chain in PEM, enables HTTPS", false, func(c *Config) interface{} { return &c.TLS.CertFile }},
+	{"tls.key_file", "TLS_KEY_FILE", "private key in PEM", false, func(c *Config) interface{} { return &c.TLS.KeyFile }},
+	{"tls.redirect_listen", "TLS_REDIRECT_LISTEN", "address that redirects HTTP to HTTPS, e.g. :80", false, func(c *Config) interface{} { return &c.TLS.RedirectListen }},
+	{"database.path", "DATABASE_PATH", "SQLite database file", false, func(c *Config) interface{} { return &c.Database.Path }},
+	{"storage.backend", "STORAGE_BACKEND", "file storage: local or s3", false, func(c *Config) interface{} { return &c.Storage.Backend }},
+	{"storage.local_dir", "UPLOADS_DIR", "directory for local files and unfinished uploads", false, func(c *Config) interface{} { return &c.Storage.LocalDir }},
+	{"storage.s3.endpoint", "S3_ENDPOINT", "S3 endpoint host:port", false, func(c *Config) interface{} { return &c.Storage.S3.Endpoint }},
+	{"storage.s3.bucket", "S3_BUCKET", "S3 bucket", false, func(c *Config) interface{} { return &c.Storage.S3.Bucket }},
+	{"storage.s3.region", "S3_REGION", "S3 region", false, func(c *Config) interface{} { return &c.Storage.S3.Region }},
+	{"storage.s3.access_key", "S3_ACCESS_KEY", "S3 access key", true, func(c *Config) interface{} { return &c.Storage.S3.AccessKey }},
+	{"storage.s3.secret_key", "S3_SECRET_KEY", "S3 secret key", true, func(c *Config) interface{} { return &c.Storage.S3.SecretKey }},
+	{"storage.s3.use_ssl", "S3_USE_SSL", "connect to S3 over TLS", false, func(c *Config) interface{} { return &c.Storage.S3.UseSSL }},
+	{"storage.s3.prefix", "S3_PREFIX", "key prefix inside the bucket", false, func(c *Config) interface{} { return &c.Storage.S3.Prefix }},
+	{"uploads.max_size", "MAX_UPLOAD_SIZE", "largest file that can be uploaded, must fit in server.read_timeout if set", false, func(c *Config) interface{} { return &c.Uploads.MaxSize }},
+	{"uploads.max_files", "MAX_UPLOAD_FILES", "files accepted in one upload form", false, func(c *Config) interface{} { return &c.Uploads.MaxFiles }},
+	{"uploads.max_versions", "MAX_FILE_VERSIONS", "versions kept per file, 0 - unlimited", false, func(c *Config) interface{} { return &c.Uploads.MaxVersions }},
+	{"sessions.idle_timeout", "SESSION_IDLE_TIMEOUT", "sign out after this much inactivity", false, func(c *Config) interface{} { return &c.Sessions.IdleTimeout }},
+	{"sessions.absolute_timeout", "SESSION_ABSOLUTE_TIMEOUT", "sign out this long after login", false, func(c *Config) interface{} { return &c.Sessions.AbsoluteTimeout }},
+	{"login.free_attempts", "LOGIN_FREE_ATTEMPTS", "failed logins per account before delays", false, func(c *Config) interface{} { return &c.Login.FreeAttempts }},
+	{"login.ip_free_attempts", "LOGIN_IP_FREE_ATTEMPTS", "failed logins per address before delays", false, func(c *Config) interface{} { return &c.Login.IPFreeAttempts }},
+	{"login.max_delay", "LOGIN_MAX_DELAY", "longest delay between failed logins", false, func(c *Config) interface{} { return &c.Login.MaxDelay }},
+	{"login.lockout_threshold", "LOGIN_LOCKOUT_THRESHOLD", "failed logins that lock an account, 0 - never", false, func(c *Config) interface{} { return &c.Login.LockoutThreshold }},
+	{"login.lockout_duration", "LOGIN_LOCKOUT_DURATION", "how long an account stays locked", false, func(c *Config) interface{} { return &c.Login.LockoutDuration }},
+	{"login.failure_window", "LOGIN_FAILURE_WINDOW", "failed logins older than this are forgotten", false, func(c *Config) interface{} { return &c.Login.FailureWindow }},
+	{"password.min_length", "PASSWORD_MIN_LENGTH", "minimum password length", false, func(c *Config) interface{} { return &c.Password.MinLength }},
+	{"password.require_mixed_case", "PASSWORD_REQUIRE_MIXED_CASE", "require lowercase and uppercase letters", false, func(c *Config) interface{} { return &c.Password.RequireMixedCase }},
+	{"password.require_digit", "PASSWORD_REQUIRE_DIGIT", "require a digit", false, func(c *Config) interface{} { return &c.Password.RequireDigit }},
+	{"password.require_special", "PASSWORD_REQUIRE_SPECIAL", "require a special character", false, func(c *Config) interface{} { return &c.Password.RequireSpecial }},
+	{"ldap.url", "LDAP_URL", "directory server ldap:// or ldaps://, enables LDAP sign-in", false, func(c *Config) interface{} { return &c.LDAP.URL }},
+	{"ldap.start_tls", "LDAP_START_TLS", "upgrade ldap:// connections with StartTLS", false, func(c *Config) interface{} { return &c.LDAP.StartTLS }},
+	{"ldap.ca_file", "LDAP_TLS_CA_FILE", "CA certificates in PEM for the directory server", false, func(c *Config) interface{} { return &c.LDAP.CAFile }},
+	{"ldap.insecure_skip_verify", "LDAP_TLS_INSECURE_SKIP_VERIFY", "do not verify the directory server certificate", false, func(c *Config) interface{} { return &c.LDAP.InsecureSkipVerify }},
+	{"ldap.bind_dn", "LDAP_BIND_DN", "service account for searches, empty - anonymous", false, func(c *Config) interface{} { return &c.LDAP.BindDN }},
+	{"ldap.bind_password", "LDAP_BIND_PASSWORD", "service account password", true, func(c *Config) interface{} { return &c.LDAP.BindPassword }},
+	{"ldap.base_dn", "LDAP_BASE_DN", "where to search for users", false, func(c *Config) interface{} { return &c.LDAP.BaseDN }},
+	{"ldap.user_filter", "LDAP_USER_FILTER", "user search filter, {username} is the entered name", false, func(c *Config) interface{} { return &c.LDAP.UserFilter }},
+	{"ldap.username_attribute", "LDAP_USERNAME_ATTRIBUTE", "attribute that becomes the user name", false, func(c *Config) interface{} { return &c.LDAP.UsernameAttribute }},
+	{"ldap.group_attribute", "LDAP_GROUP_ATTRIBUTE", "user attribute listing groups", false, func(c *Config) interface{} { return &c.LDAP.GroupAttribute }},
+	{"ldap.group_filter", "LDAP_GROUP_FILTER", "separate group search, e.g. (member={dn})", false, func(c *Config) interface{} { return &c.LDAP.GroupFilter }},
+	{"ldap.group_base_dn", "LDAP_GROUP_BASE_DN", "where to search for groups", false, func(c *Config) interface{} { return &c.LDAP.GroupBaseDN }},
+	{"ldap.role_mapping", "LDAP_ROLE_MAPPING", "group:role pairs separated by ;, first match wins", false, func(c *Config) interface{} { return &c.LDAP.RoleMapping }},
+	{"ldap.default_role", "LDAP_DEFAULT_ROLE", "role without a matching group, empty - deny sign-in", false, func(c *Config) interface{} { return &c.LDAP.DefaultRole }},
+	{"ldap.timeout", "LDAP_TIMEOUT", "directory connection and search timeout", false, func(c *Config) interface{} { return &c.LDAP.Timeout }},
+	{"oidc.issuer", "OIDC_ISSUER", "OpenID Connect issuer URL, enables single sign-on", false, func(c *Config) interface{} { return &c.OIDC.Issuer }},
+	{"oidc.client_id", "OIDC_CLIENT_ID", "client ID", false, func(c *Config) interface{} { return &c.OIDC.ClientID }},
+	{"oidc.client_secret", "OIDC_CLIENT_SECRET", "client secret, empty for a public client", true, func(c *Config) interface{} { return &c.OIDC.ClientSecret }},
+	{"oidc.redirect_url", "OIDC_REDIRECT_URL", "this app's /login/oidc/callback URL", false, func(c *Config) interface{} { return &c.OIDC.RedirectURL }},
+	{"oidc.scopes", "OIDC_SCOPES", "scopes separated by spaces", false, func(c *Config) interface{} { return &c.OIDC.Scopes }},
+	{"oidc.name", "OIDC_NAME", "sign-in button label", false, func(c *Config) interface{} { return &c.OIDC.DisplayName }},
+	{"oidc.username_claim", "OIDC_USERNAME_CLAIM", "claim with the user name", false, func(c *Config) interface{} { return &c.OIDC.UsernameClaim }},
+	{"oidc.groups_claim", "OIDC_GROUPS_CLAIM", "claim with groups or roles, dotted path allowed", false, func(c *Config) interface{} { return &c.OIDC.GroupsClaim }},
+	{"oidc.admin_values", "OIDC_ADMIN_VALUES", "comma-separated groups claim values for admins", false, func(c *Config) interface{} { return &c.OIDC.AdminValues }},
+	{"oidc.upload_values", "OIDC_UPLOAD_VALUES", "comma-separated values that allow uploads", false, func(c *Config) interface{} { return &c.OIDC.UploadValues }},
+	{"oidc.download_values", "OIDC_DOWNLOAD_VALUES", "comma-separated values that allow downloads, empty - everyone", false, func(c *Config) interface{} { return &c.OIDC.DownloadValues }},
+	{"metrics.interval", "METRICS_INTERVAL", "how often to refresh storage metrics and clean up", false, func(c *Config) interface{} { return &c.Metrics.Interval }},
+}
+
+// keepEmptyEnv переменные, пустое значение которых что-то означает. Остальные пустые
+// переменные не меняют значение. LDAP_DEFAULT_ROLE="" запрещает вход без подходящей группы.
+var keepEmptyEnv = map[string]bool{"LDAP_DEFAULT_ROLE": true}
+
+// set разбирает значение параметра
+func (s setting) set(c *Config, value string) error {
+	var err error
+	switch field := s.field(c).(type) {
+	case *string:
+		*field = value
+	case *bool:
+		*field, err = strconv.ParseBool(value)
+	case *int:
+		*field, err = strconv.Atoi(value)
+	case *time.Duration:
+		*field, err = time.ParseDuration(value)
+	case *Size:
+		*field, err = ParseSize(value)
+	case *[]string:
+		*field = splitList(value)
+	case *[]LDAPRoleMapping:
+		*field, err = ParseRoleMapping(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %q", value)
+	}
+	return nil
+}
+
+// splitList разбирает список значений через запятую
+func splitList(value string) []string {
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// format записывает значение параметра так, как его можно указать в файле конфигурации
+func (s setting) format(c *Config) string {
+	switch field := s.field(c).(type) {
+	case *string:
+		if s.secret && *field != "" {
+			return strconv.Quote("********")
+		}
+		return strconv.Quote(*field)
+	case *bool:
+		return strconv.FormatBool(*field)
+	case *int:
+		return strconv.Itoa(*field)
+	case *time.Duration:
+		return strconv.Quote(formatDuration(*field))
+	case *Size:
+		return strconv.Quote(field.String())
+	case *[]string:
+		return strconv.Quote(strings.Join(*field, ","))
+	case *[]LDAPRoleMapping:
+		return strconv.Quote(formatRoleMapping(*field))
+	}
+	return ""
+}
+
+// formatDuration записывает длительность без нулевых хвостов: "2h" вместо "2h0m0s"
+func formatDuration(d time.Duration) string {
+	text := d.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
+
+// Options параметры запуска, которые не относятся к конфигурации
+type Options struct {
+	ConfigFile  string
+	PrintConfig bool
+	// Args аргументы после флагов, например команда reconcile
+	Args []string
+}
+
+// Load собирает конфигурацию: значения по умолчанию, затем файл из --config или CONFIG_FILE,
+// затем переменные окружения, затем флаги. Каждый параметр задается флагом с именем его ключа,
+// например --server.listen=:9090. Конфигурация не проверяется: это делает Validate.
+func Load(args []string) (*Config, Options, error) {
+	var opts Options
+	flags := flag.NewFlagSet("file-exchange-app", flag.ContinueOnError)
+	flags.StringVar(&opts.ConfigFile, "config", os.Getenv("CONFIG_FILE"), "configuration file (TOML)")
+	flags.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective configuration and exit")
+	for _, s := range settings {
+		if !s.secret {
+			flags.String(s.key, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, opts, err
+	}
+	opts.Args = flags.Args()
+
+	cfg := Default()
+	if opts.ConfigFile != "" {
+		if err := cfg.loadFile(opts.ConfigFile); err != nil {
+			return nil, opts, err
+		}
+	}
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok && (value != "" || keepEmptyEnv[s.env]) {
+			if err := s.set(cfg, value); err != nil {
+				return nil, opts, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.key == f.Name && err == nil {
+				if setErr := s.set(cfg, f.Value.String()); setErr != nil {
+					err = fmt.Errorf("--%s: %w", s.key, setErr)
+				}
+			}
+		}
+	})
+	return cfg, opts, err
+}
+
+// loadFile читает файл конфигурации в формате TOML. Ключ параметра - путь из таблиц и имени:
+// server.listen задается как listen = ... в таблице [server]. Строки разбираются так же,
+// как переменные окружения; числа, логические значения и массивы строк можно писать
+// и в виде TOML-значений. Неизвестный ключ - ошибка, чтобы опечатка не прошла незамеченной.
+func (c *Config) loadFile(path string) error {
+	var raw map[string]interface{}
+	meta, err := toml.DecodeFile(path, &raw)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	known := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		known[s.key] = s
+	}
+	for _, key := range meta.Keys() {
+		if meta.Type(key...) == "Hash" {
+			continue
+		}
+		s, ok := known[strings.Join(key, ".")]
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", path, key.String())
+		}
+		if err := s.decode(c, lookup(raw, key)); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, s.key, err)
+		}
+	}
+	return nil
+}
+
+// lookup возвращает значение по пути ключа в разобранном TOML-документе
+func lookup(table map[string]interface{}, key toml.Key) interface{} {
+	for _, part := range key[:len(key)-1] {
+		table, _ = table[part].(map[string]interface{})
+	}
+	return table[key[len(key)-1]]
+}
+
+// decode присваивает параметру значение из файла конфигурации. Значение другого типа,
+// например число для строкового параметра, считается ошибкой.
+func (s setting) decode(c *Config, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		return s.set(c, v)
+	case bool:
+		if field, ok := s.field(c).(*bool); ok {
+			*field = v
+			return nil
+		}
+	case int64:
+		switch field := s.field(c).(type) {
+		case *int:
+			if int64(int(v)) == v {
+				*field = int(v)
+				return nil
+			}
+		case *Size:
+			// Число без единиц - размер в байтах
+			if v >= 0 {
+				*field = Size(v)
+				return nil
+			}
+		}
+	case []interface{}:
+		if field, ok := s.field(c).(*[]string); ok {
+			values := make([]string, 0, len(v))
+			for _, item := range v {
+				text, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("invalid value %v, expected a list of strings", value)
+				}
+				values = append(values, text)
+			}
+			*field = values
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid value %v", value)
+}
+
+// Write выводит конфигурацию в формате файла конфигурации. Секреты заменяются звездочками,
+// поэтому вывод можно показывать, но для запуска секреты придется вписать заново.
+func (c *Config) Write(w io.Writer) error {
+	section := ""
+	for _, s := range settings {
+		dot := strings.LastIndex(s.key, ".")
+		if s.key[:dot] != section {
+			if section != "" {
+				fmt.Fprintln(w)
+			}
+			section = s.key[:dot]
+			fmt.Fprintf(w, "[%s]\n", section)
+		}
+		if _, err := fmt.Fprintf(w, "%s = %s # %s\n", s.key[dot+1:], s.format(c), s.usage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
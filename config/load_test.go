@@ -0,0 +1,241 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unsetEnv убирает из окружения все переменные конфигурации на время теста
+func unsetEnv(t *testing.T) {
+	t.Helper()
+	names := []string{"CONFIG_FILE"}
+	for _, s := range settings {
+		names = append(names, s.env)
+	}
+	for _, name := range names {
+		// Setenv запоминает прежнее значение и вернет его после теста
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+// writeConfig записывает файл конфигурации во временную папку
+func writeConfig(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	unsetEnv(t)
+	path := writeConfig(t, `
+[server]
+listen = ":7000"
+static_dir = "/srv/static"
+idle_timeout = "1m"
+
+[uploads]
+max_files = 5
+`)
+	t.Setenv("LISTEN_ADDR", ":7001")
+	t.Setenv("MAX_UPLOAD_FILES", "6")
+
+	cfg, opts, err := Load([]string{"--config", path, "--server.listen=:7002", "reconcile"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ConfigFile != path || !reflect.DeepEqual(opts.Args, []string{"reconcile"}) {
+		t.Errorf("options = %+v", opts)
+	}
+	// флаг важнее переменной окружения, переменная важнее файла, файл важнее значения по умолчанию
+	if cfg.Server.Listen != ":7002" {
+		t.Errorf("server.listen = %q, want the flag value", cfg.Server.Listen)
+	}
+	if cfg.Uploads.MaxFiles != 6 {
+		t.Errorf("uploads.max_files = %d, want the environment value", cfg.Uploads.MaxFiles)
+	}
+	if cfg.Server.StaticDir != "/srv/static" || cfg.Server.IdleTimeout != time.Minute {
+		t.Errorf("server = %+v, want values from the file", cfg.Server)
+	}
+	if cfg.Database.Path != Default().Database.Path {
+		t.Errorf("database.path = %q, want the default", cfg.Database.Path)
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	unsetEnv(t)
+	t.Setenv("CONFIG_FILE", writeConfig(t, "[database]\npath = \"/var/lib/app.db\"\n"))
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Path != "/var/lib/app.db" {
+		t.Errorf("database.path = %q", cfg.Database.Path)
+	}
+}
+
+func TestLoadEmptyEnv(t *testing.T) {
+	unsetEnv(t)
+	t.Setenv("LISTEN_ADDR", "")
+	t.Setenv("LDAP_DEFAULT_ROLE", "")
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Listen != ":8080" {
+		t.Errorf("empty LISTEN_ADDR changed server.listen to %q", cfg.Server.Listen)
+	}
+	if cfg.LDAP.DefaultRole != "" {
+		t.Errorf("empty LDAP_DEFAULT_ROLE kept %q", cfg.LDAP.DefaultRole)
+	}
+}
+
+func TestLoadFileValues(t *testing.T) {
+	unsetEnv(t)
+	path := writeConfig(t, `
+# строки в обоих видах кавычек, числа, логические значения и массивы
+storage.backend = 's3'
+storage.s3 = { endpoint = "minio:9000", bucket = "files", use_ssl = true }
+
+[uploads]
+max_size = 1048576
+max_versions = 0
+
+[ldap]
+user_filter = '(&(objectClass=person)(uid={username}))'
+role_mapping = """
+cn=admins,ou=groups,dc=example:admin;
+staff:uploader"""
+
+[oidc]
+admin_values = ["admins", "root"]
+upload_values = "staff, editors"
+`)
+
+	cfg, _, err := Load([]string{"--config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := S3Config{Endpoint: "minio:9000", Bucket: "files", UseSSL: true}
+	if cfg.Storage.Backend != "s3" || cfg.Storage.S3 != want {
+		t.Errorf("storage = %+v", cfg.Storage)
+	}
+	if cfg.Uploads.MaxSize != MB || cfg.Uploads.MaxVersions != 0 {
+		t.Errorf("uploads = %+v", cfg.Uploads)
+	}
+	if cfg.LDAP.UserFilter != "(&(objectClass=person)(uid={username}))" {
+		t.Errorf("ldap.user_filter = %q", cfg.LDAP.UserFilter)
+	}
+	mapping := []LDAPRoleMapping{{"cn=admins,ou=groups,dc=example", "admin"}, {"staff", "uploader"}}
+	if !reflect.DeepEqual(cfg.LDAP.RoleMapping, mapping) {
+		t.Errorf("ldap.role_mapping = %+v", cfg.LDAP.RoleMapping)
+	}
+	if !reflect.DeepEqual(cfg.OIDC.AdminValues, []string{"admins", "root"}) ||
+		!reflect.DeepEqual(cfg.OIDC.UploadValues, []string{"staff", "editors"}) {
+		t.Errorf("oidc = %+v", cfg.OIDC)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+		want string
+	}{
+		{"unknown key", "[server]\nlisten_addr = \":80\"\n", nil, `unknown setting "server.listen_addr"`},
+		{"unknown section", "[srv]\nlisten = \":80\"\n", nil, `unknown setting "srv.listen"`},
+		{"syntax", "[server\nlisten = \":80\"\n", nil, "toml: line"},
+		{"unterminated string", "[server]\nlisten = \":80\n", nil, "line 2"},
+		{"duplicate key", "[server]\nlisten = \":80\"\nlisten = \":81\"\n", nil, "listen"},
+		{"wrong type", "[server]\nlisten = 80\n", nil, "server.listen: invalid value 80"},
+		{"bad duration", "[server]\nidle_timeout = \"soon\"\n", nil, `server.idle_timeout: invalid value "soon"`},
+		{"negative size", "[uploads]\nmax_size = -1\n", nil, "uploads.max_size: invalid value -1"},
+		{"list of numbers", "[oidc]\nadmin_values = [1, 2]\n", nil, "oidc.admin_values"},
+		{"bad flag", "", []string{"--login.free_attempts=many"}, `--login.free_attempts: invalid value "many"`},
+		{"secret flag", "", []string{"--storage.s3.secret_key=x"}, "flag provided but not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t)
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"--config", writeConfig(t, tt.file)}, args...)
+			}
+			_, _, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	unsetEnv(t)
+	_, _, err := Load([]string{"--config", filepath.Join(t.TempDir(), "missing.toml")})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load() error = %v, want a missing file error", err)
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	unsetEnv(t)
+	cfg := Default()
+	cfg.Server.Listen = "127.0.0.1:9090"
+	cfg.Server.ReadTimeout = 90 * time.Minute
+	cfg.TLS.CertFile = `C:\certs\server "main".pem`
+	cfg.Storage.S3.UseSSL = true
+	cfg.Uploads.MaxSize = 512 * MB
+	cfg.Login.LockoutThreshold = 0
+	cfg.LDAP.RoleMapping = []LDAPRoleMapping{{"cn=admins,dc=example", "admin"}, {"staff", "uploader"}}
+	cfg.LDAP.DefaultRole = ""
+	cfg.OIDC.Issuer = "https://sso.example.com/realms/main"
+	cfg.OIDC.AdminValues = []string{"admins", "root"}
+
+	var out bytes.Buffer
+	if err := cfg.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _, err := Load([]string{"--config", writeConfig(t, out.String())})
+	if err != nil {
+		t.Fatalf("reading --print-config output: %v\n%s", err, out.String())
+	}
+	if !reflect.DeepEqual(loaded, cfg) {
+		t.Errorf("round trip changed the configuration:\n got %+v\nwant %+v", loaded, cfg)
+	}
+}
+
+func TestWriteMasksSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.Storage.S3.SecretKey = "s3-secret"
+	cfg.LDAP.BindPassword = "ldap-secret"
+	cfg.OIDC.ClientSecret = "oidc-secret"
+
+	var out bytes.Buffer
+	if err := cfg.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"s3-secret", "ldap-secret", "oidc-secret"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("output contains %q", secret)
+		}
+	}
+	if !strings.Contains(out.String(), `secret_key = "********"`) {
+		t.Errorf("secret_key is not masked:\n%s", out.String())
+	}
+	// Пустой секрет выводится как есть, чтобы было видно, что он не задан
+	if !strings.Contains(out.String(), `access_key = ""`) {
+		t.Errorf("empty access_key is masked:\n%s", out.String())
+	}
+}
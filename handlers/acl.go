@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Ошибки проверки прав на файлы и папки
+var (
+	errAccessDenied      = errors.New("access denied")
+	errUnknownPrincipal  = errors.New("unknown user or group")
+	errInvalidPermission = errors.New("permission must be read, write or manage")
+	errTokenScope        = errors.New("not allowed for this API token scope")
+)
+
+// userAccess загружает права пользователя на файлы и папки
+func userAccess(user *models.User) (*models.Access, error) {
+	return storage.ACLStoreInstance.AccessFor(user)
+}
+
+// requireAccess проверяет уровень доступа пользователя к пути
+func requireAccess(user *models.User, path, permission string) error {
+	access, err := userAccess(user)
+	if err != nil {
+		return err
+	}
+	if !access.Allows(path, permission) {
+		return errAccessDenied
+	}
+	return nil
+}
+
+// canWriteInto сообщает, можно ли создавать файлы и папки в folder. В корень может писать
+// любой с правом загрузки; еще не созданная папка наследует права ближайшего предка.
+func canWriteInto(access *models.Access, folder string) (bool, error) {
+	for {
+		if folder == "" || access.Allows(folder, models.PermissionWrite) {
+			return true, nil
+		}
+		if _, err := storage.FolderStoreInstance.GetFolder(folder); err == nil {
+			return false, nil
+		} else if !errors.Is(err, storage.ErrFolderNotFound) {
+			return false, err
+		}
+		folder = storage.ParentPath(folder)
+	}
+}
+
+// requireChange проверяет, что запрос может менять существующие файлы (см. User.CanChange)
+func requireChange(user *models.User) error {
+	if !user.CanChange() {
+		return errTokenScope
+	}
+	return nil
+}
+
+// requireWriteInto проверяет, что пользователь может создавать объекты в папке
+func requireWriteInto(user *models.User, folder string) error {
+	access, err := userAccess(user)
+	if err != nil {
+		return err
+	}
+	allowed, err := canWriteInto(access, folder)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return errAccessDenied
+	}
+	return nil
+}
+
+// requireUpload проверяет права на загрузку файла name: запись в папку, а если
+// файл уже есть - запись в сам файл (новая версия). Токен upload существующие файлы
+// не заменяет.
+func requireUpload(user *models.User, name string) error {
+	access, err := userAccess(user)
+	if err != nil {
+		return err
+	}
+	if _, err := storage.FileStoreInstance.GetFileByName(name); err == nil {
+		if err := requireChange(user); err != nil {
+			return err
+		}
+		if !access.Allows(name, models.PermissionWrite) {
+			return errAccessDenied
+		}
+		return nil
+	} else if !errors.Is(err, storage.ErrFileNotFound) {
+		return err
+	}
+
+	allowed, err := canWriteInto(access, storage.ParentPath(name))
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return errAccessDenied
+	}
+	return nil
+}
+
+// readableFile возвращает файл, если пользователь может его читать. Недоступный файл
+// выглядит как несуществующий, чтобы не раскрывать имена.
+func readableFile(user *models.User, name string) (*models.File, error) {
+	if err := requireAccess(user, name, models.PermissionRead); err != nil {
+		if errors.Is(err, errAccessDenied) {
+			return nil, storage.ErrFileNotFound
+		}
+		return nil, err
+	}
+	return storage.FileStoreInstance.GetFileByName(name)
+}
+
+// filterFiles оставляет только файлы, доступные на чтение
+func filterFiles(access *models.Access, files []models.File) []models.File {
+	visible := []models.File{}
+	for _, file := range files {
+		if access.Allows(file.Name, models.PermissionRead) {
+			visible = append(visible, file)
+		}
+	}
+	return visible
+}
+
+// filterFolders оставляет только папки, которые пользователь может видеть
+func filterFolders(access *models.Access, folders []models.Folder) []models.Folder {
+	visible := []models.Folder{}
+	for _, folder := range folders {
+		if access.CanSee(folder.Path) {
+			visible = append(visible, folder)
+		}
+	}
+	return visible
+}
+
+// resolveResource находит файл или папку по пути. Пустой путь - корень (папка с ID 0).
+func resolveResource(path string) (string, int, error) {
+	if path == "" {
+		return models.ResourceFolder, 0, nil
+	}
+	file, err := storage.FileStoreInstance.GetFileByName(path)
+	if err == nil {
+		return models.ResourceFile, file.ID, nil
+	}
+	if !errors.Is(err, storage.ErrFileNotFound) {
+		return "", 0, err
+	}
+	folder, err := storage.FolderStoreInstance.GetFolder(path)
+	if err != nil {
+		return "", 0, err
+	}
+	return models.ResourceFolder, folder.ID, nil
+}
+
+// resolvePrincipal находит пользователя или группу по имени
+func resolvePrincipal(principalType, name string) (int, error) {
+	switch principalType {
+	case models.PrincipalUser:
+		user, err := storage.UserStoreInstance.GetUserByUsername(name)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				return 0, errUnknownPrincipal
+			}
+			return 0, err
+		}
+		return user.ID, nil
+	case models.PrincipalGroup:
+		group, err := storage.GroupStoreInstance.GetGroupByName(name)
+		if err != nil {
+			if errors.Is(err, storage.ErrGroupNotFound) {
+				return 0, errUnknownPrincipal
+			}
+			return 0, err
+		}
+		return group.ID, nil
+	}
+	return 0, errUnknownPrincipal
+}
+
+// managedResource проверяет право manage на путь и возвращает ресурс
+func managedResource(user *models.User, rawPath string) (string, string, int, error) {
+	path, err := storage.CleanPath(rawPath)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if err := requireAccess(user, path, models.PermissionManage); err != nil {
+		return "", "", 0, err
+	}
+	resourceType, resourceID, err := resolveResource(path)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return path, resourceType, resourceID, nil
+}
+
+// grantAccess выдает право на путь и пишет запись в журнал. Токены с ограниченной областью
+// действия доступ не раздают.
+func grantAccess(user *models.User, rawPath, principalType, principal, permission string) (*models.ACLEntry, error) {
+	if err := requireChange(user); err != nil {
+		return nil, err
+	}
+	if models.PermissionLevel(permission) == 0 {
+		return nil, errInvalidPermission
+	}
+	path, resourceType, resourceID, err := managedResource(user, rawPath)
+	if err != nil {
+		return nil, err
+	}
+	principalID, err := resolvePrincipal(principalType, principal)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := storage.ACLStoreInstance.Grant(resourceType, resourceID, principalType, principalID, permission)
+	if err != nil {
+		return nil, err
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionGrantAccess,
+		fmt.Sprintf("/%s: %s %s %s", path, permission, principalType, principal))
+	return entry, nil
+}
+
+// revokeAccess удаляет запись ACL, если у пользователя есть право manage на ее ресурс
+func revokeAccess(user *models.User, id int) (*models.ACLEntry, error) {
+	if err := requireChange(user); err != nil {
+		return nil, err
+	}
+	entry, err := storage.ACLStoreInstance.GetEntry(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireAccess(user, entry.Path, models.PermissionManage); err != nil {
+		return nil, err
+	}
+	if err := storage.ACLStoreInstance.Revoke(id); err != nil {
+		return nil, err
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionRevokeAccess,
+		fmt.Sprintf("/%s: %s %s %s", entry.Path, entry.Permission, entry.PrincipalType, entry.PrincipalName))
+	return entry, nil
+}
+
+// aclErrorStatus переводит ошибки работы с ACL в HTTP-статус и сообщение
+func aclErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, errUnknownPrincipal), errors.Is(err, errInvalidPermission):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, storage.ErrACLEntryNotFound):
+		return http.StatusNotFound, err.Error()
+	}
+	return pathErrorStatus(err)
+}
+
+// accessURL адрес страницы доступа к пути
+func accessURL(path string) string {
+	return "/access?path=" + url.QueryEscape(path)
+}
+
+// AccessHandler отображает права на файл или папку из ?path= и форму выдачи прав
+func AccessHandler(w http.ResponseWriter, r *http.Request) {
+	renderAccessPage(w, r, r.URL.Query().Get("path"), "")
+}
+
+// GrantAccessHandler выдает право из формы на странице доступа
+func GrantAccessHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	path := r.FormValue("path")
+	_, err := grantAccess(currentUser(r), path, r.FormValue("principal_type"), r.FormValue("principal"), r.FormValue("permission"))
+	if err != nil {
+		status, message := aclErrorStatus(err)
+		if status == http.StatusBadRequest {
+			renderAccessPage(w, r, path, message)
+			return
+		}
+		http.Error(w, message, status)
+		return
+	}
+	http.Redirect(w, r, accessURL(path), http.StatusSeeOther)
+}
+
+// RevokeAccessHandler удаляет запись ACL
+func RevokeAccessHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	entry, err := revokeAccess(currentUser(r), id)
+	if err != nil {
+		status, message := aclErrorStatus(err)
+		http.Error(w, message, status)
+		return
+	}
+	http.Redirect(w, r, accessURL(entry.Path), http.StatusSeeOther)
+}
+
+// renderAccessPage выводит записи ACL ресурса; требует права manage
+func renderAccessPage(w http.ResponseWriter, r *http.Request, rawPath, errorMessage string) {
+	user := currentUser(r)
+	path, resourceType, resourceID, err := managedResource(user, rawPath)
+	if err != nil {
+		status, message := aclErrorStatus(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	entries, err := storage.ACLStoreInstance.ListEntries(resourceType, resourceID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Username     string
+		IsAdmin      bool
+		Path         string
+		ResourceType string
+		Back         string
+		Entries      []models.ACLEntry
+		Error        string
+		CSRFToken    string
+	}{
+		Username:     user.Username,
+		IsAdmin:      user.IsAdmin,
+		Path:         path,
+		ResourceType: resourceType,
+		Back:         folderURL(storage.ParentPath(path)),
+		Entries:      entries,
+		Error:        errorMessage,
+		CSRFToken:    csrfToken(w, r),
+	}
+	if resourceType == models.ResourceFolder {
+		data.Back = folderURL(path)
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/access.html"))
+	tmpl.Execute(w, data)
+}
+
+// aclRequest тело запроса на выдачу права через API
+type aclRequest struct {
+	Path          string `json:"path"`
+	PrincipalType string `json:"principal_type"`
+	Principal     string `json:"principal"`
+	Permission    string `json:"permission"`
+}
+
+// writeAPIACLError отвечает JSON-ошибкой для API списков доступа
+func writeAPIACLError(w http.ResponseWriter, err error) {
+	status, message := aclErrorStatus(err)
+	writeJSONError(w, status, message)
+}
+
+// APIListACLHandler возвращает записи ACL файла или папки из ?path=
+func APIListACLHandler(w http.ResponseWriter, r *http.Request) {
+	_, resourceType, resourceID, err := managedResource(currentUser(r), r.URL.Query().Get("path"))
+	if err != nil {
+		writeAPIACLError(w, err)
+		return
+	}
+
+	entries, err := storage.ACLStoreInstance.ListEntries(resourceType, resourceID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if entries == nil {
+		entries = []models.ACLEntry{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entries": entries})
+}
+
+// APIGrantACLHandler выдает или меняет право субъекта на файл или папку
+func APIGrantACLHandler(w http.ResponseWriter, r *http.Request) {
+	var req aclRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	entry, err := grantAccess(currentUser(r), req.Path, req.PrincipalType, req.Principal, req.Permission)
+	if err != nil {
+		writeAPIACLError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// APIRevokeACLHandler удаляет запись ACL
+func APIRevokeACLHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if _, err := revokeAccess(currentUser(r), id); err != nil {
+		writeAPIACLError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,87 +1,99 @@
 package handlers
 
 import (
+	"errors"
+	"file-exchange-app/models"
 	"file-exchange-app/storage"
 	"fmt"
 	"html/template"
 	"net/http"
-
-	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"time"
 )
 
 // AdminHandler отображает админскую панель
 func AdminHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl := template.Must(template.ParseFiles("templates/admin.html"))
 
-	// Получаем список всех пользователей из БД для отображения
-	rows, err := storage.DB.Query("SELECT id, username, can_upload, can_download, is_admin FROM users")
+	// Пользователи с итоговыми правами, роли и группы с участниками
+	users, err := storage.UserStoreInstance.GetAllUsers()
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	type UserView struct {
-		ID          int
-		Username    string
-		CanUpload   bool
-		CanDownload bool
-		IsAdmin     bool
-		Role        string
+	roles, err := storage.RoleStoreInstance.ListRoles()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	groupList, err := storage.GroupStoreInstance.ListGroups()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
 	}
 
-	var users []UserView
-	for rows.Next() {
-		var u UserView
-		err := rows.Scan(&u.ID, &u.Username, &u.CanUpload, &u.CanDownload, &u.IsAdmin)
-		if err != nil {
-			continue
-		}
+	type GroupView struct {
+		models.Group
+		Members []models.User
+	}
 
-		// Определяем роль для отображения
-		if u.IsAdmin {
-			u.Role = "Admin"
-		} else if u.CanUpload && u.CanDownload {
-			u.Role = "Uploader/Downloader"
-		} else if u.CanDownload {
-			u.Role = "Downloader"
-		} else {
-			u.Role = "No access"
+	var groups []GroupView
+	for _, group := range groupList {
+		members, err := storage.GroupStoreInstance.ListMembers(group.ID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
 		}
-		users = append(users, u)
+		groups = append(groups, GroupView{Group: group, Members: members})
 	}
 
-	// Получаем логи для отображения
-	logRows, err := storage.DB.Query("SELECT username, action, filename, timestamp FROM logs ORDER BY timestamp DESC LIMIT 100")
+	requireAdmin2FA, err := storage.SettingsStoreInstance.GetBool(storage.SettingRequireAdmin2FA)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-	defer logRows.Close()
 
-	type LogEntry struct {
-		Username  string
-		Action    string
-		Filename  string
-		Timestamp string
+	// Учетные записи, заблокированные после неудачных попыток входа
+	lockedAccounts, err := storage.LoginThrottleInstance.LockedAccounts(time.Now())
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
 	}
-
-	var logs []LogEntry
-	for logRows.Next() {
-		var l LogEntry
-		err := logRows.Scan(&l.Username, &l.Action, &l.Filename, &l.Timestamp)
-		if err != nil {
-			continue
+	locked := make(map[int]time.Time)
+	for _, user := range users {
+		if until, ok := lockedAccounts[strings.ToLower(user.Username)]; ok {
+			locked[user.ID] = until
 		}
-		logs = append(logs, l)
+	}
+
+	// Получаем логи для отображения
+	logs, err := storage.LogStoreInstance.GetLogs(storage.LogFilter{Limit: 100})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
 	}
 
 	data := struct {
-		Users []UserView
-		Logs  []LogEntry
+		Self   int
+		Users  []models.User
+		Roles  []models.Role
+		Groups []GroupView
+		Logs   []models.LogEntry
+		// Locked срок блокировки входа по ID пользователя
+		Locked map[int]time.Time
+		// RequireAdmin2FA текущее значение настройки обязательной 2FA для администраторов
+		RequireAdmin2FA bool
+		CSRFToken       string
 	}{
-		Users: users,
-		Logs:  logs,
+		Self:   currentUser(r).ID,
+		Users:  users,
+		Roles:  roles,
+		Groups: groups,
+		Logs:   logs,
+		Locked: locked,
+
+		RequireAdmin2FA: requireAdmin2FA,
+		CSRFToken:       csrfToken(w, r),
 	}
 
 	tmpl.Execute(w, data)
@@ -105,50 +117,35 @@ func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Определяем права в зависимости от роли
-	var canUpload, canDownload, isAdmin bool
-	switch role {
-	case "admin":
-		canUpload, canDownload, isAdmin = true, true, true
-	case "uploader":
-		canUpload, canDownload, isAdmin = true, true, false
-	case "downloader":
-		canUpload, canDownload, isAdmin = false, true, false
-	default:
-		http.Error(w, "Invalid role", http.StatusBadRequest)
-		return
-	}
-
-	// Хэшируем пароль
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	// Роль берем из таблицы ролей: встроенную или созданную администратором
+	userRole, err := storage.RoleStoreInstance.GetRoleByName(role)
 	if err != nil {
-		http.Error(w, "Error creating user", http.StatusInternalServerError)
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			http.Error(w, "Invalid role", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Вставляем пользователя в БД
-	_, err = storage.DB.Exec(
-		"INSERT INTO users (username, password_hash, can_upload, can_download, is_admin) VALUES (?, ?, ?, ?, ?)",
-		username, string(hashedPassword), canUpload, canDownload, isAdmin,
-	)
-
+	// Создаем пользователя через UserStore (пароль хэшируется внутри)
+	err = storage.UserStoreInstance.CreateUser(username, password, userRole.ID)
 	if err != nil {
 		// Если пользователь уже существует
-		if err.Error() == "UNIQUE constraint failed: users.username" {
+		if errors.Is(err, storage.ErrUserExists) {
 			http.Error(w, "Username already exists", http.StatusBadRequest)
 			return
 		}
+		if errors.Is(err, storage.ErrWeakPassword) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
 	// Логируем действие
-	session, _ := store.Get(r, "session-name")
-	adminUser, _ := session.Values["username"].(string)
-	_, err = storage.DB.Exec(
-		"INSERT INTO logs (username, action, filename) VALUES (?, ?, ?)",
-		adminUser, "create_user", fmt.Sprintf("Created user: %s with role: %s", username, role),
-	)
+	storage.LogStoreInstance.AddLog(currentUser(r).Username, models.ActionCreateUser, fmt.Sprintf("Created user: %s with role: %s", username, role))
 
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// apiError тело ответа с ошибкой для JSON API
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeJSON отправляет value в виде JSON с указанным статусом
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// writeJSONError отправляет ошибку в едином для API формате {"error": "..."}
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// RegisterAPIRoutes регистрирует маршруты JSON API версии 1 на переданном роутере;
+// загрузку файлов обслуживает uploads
+func RegisterAPIRoutes(r *mux.Router, uploads *Uploads) {
+	r.Use(APIAuthMiddleware)
+
+	r.HandleFunc("/files", APIListFilesHandler).Methods("GET")
+	r.HandleFunc("/files", uploads.APIUploadFileHandler).Methods("POST")
+	// Несколько файлов или папка одним архивом: ?format=zip|tar.gz&folder=...&file=...
+	r.HandleFunc("/archive", APIArchiveHandler).Methods("GET", "POST")
+	// Имя файла может содержать папки, поэтому маршруты с суффиксом регистрируются первыми
+	r.HandleFunc("/files/{filename:.+}/versions", APIListVersionsHandler).Methods("GET")
+	r.HandleFunc("/files/{filename:.+}/versions/{version:[0-9]+}/restore", APIRestoreVersionHandler).Methods("POST")
+	r.HandleFunc("/files/{filename:.+}", APIDownloadFileHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/files/{filename:.+}", APIMoveFileHandler).Methods("PATCH")
+	r.HandleFunc("/files/{filename:.+}", APIDeleteFileHandler).Methods("DELETE")
+	r.HandleFunc("/folders", APIListFolderHandler).Methods("GET")
+	r.HandleFunc("/folders", APICreateFolderHandler).Methods("POST")
+	r.HandleFunc("/folders", APIMoveFolderHandler).Methods("PATCH")
+	r.HandleFunc("/folders", APIDeleteFolderHandler).Methods("DELETE")
+	r.HandleFunc("/acl", APIListACLHandler).Methods("GET")
+	r.HandleFunc("/acl", APIGrantACLHandler).Methods("POST")
+	r.HandleFunc("/acl/{id:[0-9]+}", APIRevokeACLHandler).Methods("DELETE")
+	r.HandleFunc("/shares", APIListSharesHandler).Methods("GET")
+	r.HandleFunc("/shares", APICreateShareHandler).Methods("POST")
+	r.HandleFunc("/shares/{id:[0-9]+}", APIRevokeShareHandler).Methods("DELETE")
+	r.HandleFunc("/tokens", APIListTokensHandler).Methods("GET")
+	r.HandleFunc("/tokens", APICreateTokenHandler).Methods("POST")
+	r.HandleFunc("/tokens/{id:[0-9]+}", APIRevokeTokenHandler).Methods("DELETE")
+
+	// Управление пользователями и журнал доступны только администраторам
+	admin := r.NewRoute().Subrouter()
+	admin.Use(APIAdminMiddleware)
+	admin.HandleFunc("/users", APIListUsersHandler).Methods("GET")
+	admin.HandleFunc("/users", APICreateUserHandler).Methods("POST")
+	admin.HandleFunc("/users/{id:[0-9]+}", APIGetUserHandler).Methods("GET")
+	admin.HandleFunc("/users/{id:[0-9]+}", APIUpdateUserHandler).Methods("PUT")
+	admin.HandleFunc("/users/{id:[0-9]+}", APIDeleteUserHandler).Methods("DELETE")
+	admin.HandleFunc("/users/{id:[0-9]+}/sessions", APIRevokeUserSessionsHandler).Methods("DELETE")
+	admin.HandleFunc("/users/{id:[0-9]+}/unlock", APIUnlockUserHandler).Methods("POST")
+	admin.HandleFunc("/roles", APIListRolesHandler).Methods("GET")
+	admin.HandleFunc("/roles", APICreateRoleHandler).Methods("POST")
+	admin.HandleFunc("/roles/{id:[0-9]+}", APIUpdateRoleHandler).Methods("PUT")
+	admin.HandleFunc("/roles/{id:[0-9]+}", APIDeleteRoleHandler).Methods("DELETE")
+	admin.HandleFunc("/groups", APIListGroupsHandler).Methods("GET")
+	admin.HandleFunc("/groups", APICreateGroupHandler).Methods("POST")
+	admin.HandleFunc("/groups/{id:[0-9]+}", APIUpdateGroupHandler).Methods("PUT")
+	admin.HandleFunc("/groups/{id:[0-9]+}", APIDeleteGroupHandler).Methods("DELETE")
+	admin.HandleFunc("/groups/{id:[0-9]+}/members", APIListGroupMembersHandler).Methods("GET")
+	admin.HandleFunc("/groups/{id:[0-9]+}/members", APIAddGroupMemberHandler).Methods("POST")
+	admin.HandleFunc("/groups/{id:[0-9]+}/members/{user_id:[0-9]+}", APIRemoveGroupMemberHandler).Methods("DELETE")
+	admin.HandleFunc("/logs", APIListLogsHandler).Methods("GET")
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+}
+
+// APIListFilesHandler возвращает список файлов
+func APIListFilesHandler(w http.ResponseWriter, r *http.Request) {
+	access, err := userAccess(currentUser(r))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	files, err := storage.FileStoreInstance.ListFiles()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "error reading files")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"files": filterFiles(access, files)})
+}
+
+// APIUploadFileHandler принимает файлы из multipart-полей "file" и кладет их в папку из поля "folder".
+// На форму с несколькими файлами отвечает отчетом {"files": [...]} со статусом каждого файла.
+func (u *Uploads) APIUploadFileHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		writeJSONError(w, http.StatusForbidden, "you don't have permission to upload files")
+		return
+	}
+
+	results, err := u.streamUpload(w, r, user)
+	if err != nil {
+		u.writeAPIUploadError(w, err)
+		return
+	}
+	logUploads(user, results)
+
+	// Один файл - прежний ответ: сам файл или ошибка. Несколько - отчет по каждому.
+	if len(results) == 1 {
+		if results[0].File == nil {
+			writeJSONError(w, results[0].Status, results[0].Error)
+			return
+		}
+		writeJSON(w, http.StatusCreated, results[0].File)
+		return
+	}
+	writeJSON(w, uploadsStatus(results), map[string]interface{}{"files": results})
+}
+
+// APIDownloadFileHandler отдает содержимое файла; ?version=N - одну из прошлых версий
+func APIDownloadFileHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanDownload {
+		writeJSONError(w, http.StatusForbidden, "you don't have permission to download files")
+		return
+	}
+
+	filename := mux.Vars(r)["filename"]
+	file, err := readableFile(user, filename)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			writeJSONError(w, http.StatusNotFound, "file not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "error reading file")
+		return
+	}
+
+	requested, err := fileAtVersion(r, file)
+	if err != nil {
+		if errors.Is(err, errInvalidVersion) || errors.Is(err, storage.ErrVersionNotFound) {
+			writeJSONError(w, http.StatusNotFound, "version not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "error reading file")
+		return
+	}
+
+	if countsAsDownload(r, serveFile(w, r, requested)) {
+		if err := storage.LogStoreInstance.AddLog(user.Username, models.ActionDownload, downloadLogName(requested, file)); err != nil {
+			log.Printf("Failed to log download action: %v", err)
+		}
+	}
+}
+
+// APIDeleteFileHandler удаляет файл
+func APIDeleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		writeJSONError(w, http.StatusForbidden, "you don't have permission to delete files")
+		return
+	}
+
+	filename := mux.Vars(r)["filename"]
+	if err := requireChange(user); err != nil {
+		writeAPIPathError(w, err)
+		return
+	}
+	if err := requireAccess(user, filename, models.PermissionManage); err != nil {
+		writeAPIPathError(w, err)
+		return
+	}
+	if err := deleteFile(r.Context(), filename); err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			writeJSONError(w, http.StatusNotFound, "file not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "error deleting file")
+		return
+	}
+
+	if err := storage.LogStoreInstance.AddLog(user.Username, models.ActionDeleteFile, filename); err != nil {
+		log.Printf("Failed to log delete action: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userRequest тело запроса на создание или изменение пользователя.
+// При изменении применяются только переданные поля.
+type userRequest struct {
+	Username    string           `json:"username"`
+	Password    string           `json:"password"`
+	Role        string           `json:"role"`
+	Permissions *userPermissions `json:"permissions"`
+	Disabled    *bool            `json:"disabled"`
+}
+
+// userPermissions собственные флаги прав пользователя без роли
+type userPermissions struct {
+	CanUpload   bool `json:"can_upload"`
+	CanDownload bool `json:"can_download"`
+	IsAdmin     bool `json:"is_admin"`
+}
+
+// APIListUsersHandler возвращает всех пользователей
+func APIListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := storage.UserStoreInstance.GetAllUsers()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"users": users})
+}
+
+// APICreateUserHandler создает пользователя с указанной ролью
+func APICreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Username == "" || req.Password == "" || req.Role == "" {
+		writeJSONError(w, http.StatusBadRequest, "username, password and role are required")
+		return
+	}
+
+	role, err := storage.RoleStoreInstance.GetRoleByName(req.Role)
+	if err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			writeJSONError(w, http.StatusBadRequest, "invalid role")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	err = storage.UserStoreInstance.CreateUser(req.Username, req.Password, role.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			writeJSONError(w, http.StatusConflict, "username already exists")
+			return
+		}
+		if errors.Is(err, storage.ErrWeakPassword) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	storage.LogStoreInstance.AddLog(currentUser(r).Username, models.ActionCreateUser,
+		fmt.Sprintf("Created user: %s with role: %s", req.Username, req.Role))
+
+	user, err := storage.UserStoreInstance.GetUserByUsername(req.Username)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// APIGetUserHandler возвращает пользователя по ID
+func APIGetUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	user, err := storage.UserStoreInstance.GetUserByID(id)
+	if err != nil {
+		writeUserStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// APIUpdateUserHandler меняет роль или собственные права, пароль и блокировку пользователя
+func APIUpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	admin := currentUser(r)
+
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Role == "" && req.Permissions == nil && req.Password == "" && req.Disabled == nil {
+		writeJSONError(w, http.StatusBadRequest, "nothing to update")
+		return
+	}
+	if req.Role != "" && req.Permissions != nil {
+		writeJSONError(w, http.StatusBadRequest, "role and permissions are mutually exclusive")
+		return
+	}
+
+	if req.Role != "" {
+		if _, err := setUserRole(admin, id, req.Role); err != nil {
+			if errors.Is(err, storage.ErrRoleNotFound) {
+				writeJSONError(w, http.StatusBadRequest, "invalid role")
+				return
+			}
+			writeAPIUserError(w, err)
+			return
+		}
+	}
+	if p := req.Permissions; p != nil {
+		if _, err := updateUserPermissions(admin, id, p.CanUpload, p.CanDownload, p.IsAdmin); err != nil {
+			writeAPIUserError(w, err)
+			return
+		}
+	}
+	if req.Password != "" {
+		if err := resetPassword(admin, id, req.Password); err != nil {
+			writeAPIUserError(w, err)
+			return
+		}
+	}
+	if req.Disabled != nil {
+		if _, err := setUserDisabled(admin, id, *req.Disabled); err != nil {
+			writeAPIUserError(w, err)
+			return
+		}
+	}
+
+	user, err := storage.UserStoreInstance.GetUserByID(id)
+	if err != nil {
+		writeUserStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// APIDeleteUserHandler удаляет пользователя по ID. Файлы пользователя переходят
+// к ?reassign_to=<ID> (по умолчанию - к администратору, выполняющему запрос),
+// а с ?delete_files=true удаляются.
+func APIDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	admin := currentUser(r)
+	query := r.URL.Query()
+
+	reassignTo := admin.ID
+	if value := query.Get("reassign_to"); value != "" {
+		var err error
+		if reassignTo, err = strconv.Atoi(value); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid reassign_to")
+			return
+		}
+	}
+	if query.Get("delete_files") == "true" {
+		reassignTo = 0
+	}
+
+	if err := deleteUser(r.Context(), admin, id, reassignTo); err != nil {
+		writeAPIUserError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIRevokeUserSessionsHandler завершает все сессии пользователя и возвращает их число
+func APIRevokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	revoked, err := revokeUserSessions(currentUser(r), id)
+	if err != nil {
+		writeAPIUserError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"revoked": revoked})
+}
+
+// APIUnlockUserHandler снимает с пользователя блокировку входа
+func APIUnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := unlockUser(currentUser(r), id); err != nil {
+		writeAPIUserError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAPIUserError отвечает JSON-ошибкой для API управления пользователями
+func writeAPIUserError(w http.ResponseWriter, err error) {
+	status, message := userErrorStatus(err)
+	writeJSONError(w, status, message)
+}
+
+// APIListLogsHandler возвращает записи журнала с фильтрами username, action, limit и offset
+func APIListLogsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := storage.LogFilter{
+		Username: query.Get("username"),
+		Action:   query.Get("action"),
+	}
+
+	var err error
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid offset")
+			return
+		}
+	}
+
+	logs, err := storage.LogStoreInstance.GetLogs(filter)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if logs == nil {
+		logs = []models.LogEntry{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"logs": logs})
+}
+
+// writeUserStoreError переводит ошибку UserStore в HTTP-статус
+func writeUserStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrUserNotFound) {
+		writeJSONError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, "database error")
+}
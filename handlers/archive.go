@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Форматы архива для скачивания нескольких файлов
+const (
+	archiveZip   = "zip"
+	archiveTarGz = "tar.gz"
+)
+
+// Ошибки разбора запроса архива
+var (
+	errInvalidArchiveFormat = errors.New(`format must be "zip" or "tar.gz"`)
+	errOutsideArchiveFolder = errors.New("selected files must be inside folder")
+)
+
+// archiveRequest что именно запрошено в архив
+type archiveRequest struct {
+	format string
+	folder string        // корень архива: пути внутри архива считаются от него
+	files  []models.File // текущие версии файлов, по алфавиту
+}
+
+// parseArchiveRequest разбирает параметры format, folder и file (может повторяться) и
+// проверяет права. Без file в архив попадает вся папка folder с подпапками, точнее те
+// файлы, которые пользователь может читать. Выбранные файлы, наоборот, должны быть
+// доступны все и лежать внутри folder, иначе запрос отклоняется целиком: так пути
+// в архиве не совпадут у разных файлов.
+func parseArchiveRequest(r *http.Request, user *models.User) (*archiveRequest, error) {
+	request := &archiveRequest{format: r.FormValue("format")}
+	switch request.format {
+	case "":
+		request.format = archiveZip
+	case archiveZip, archiveTarGz:
+	default:
+		return nil, errInvalidArchiveFormat
+	}
+
+	folder, err := storage.CleanPath(r.FormValue("folder"))
+	if err != nil {
+		return nil, err
+	}
+	request.folder = folder
+
+	if names := r.Form["file"]; len(names) > 0 {
+		seen := make(map[string]bool)
+		for _, raw := range names {
+			name, err := storage.CleanPath(raw)
+			if err != nil {
+				return nil, err
+			}
+			if name == "" || seen[name] {
+				continue
+			}
+			if folder != "" && !strings.HasPrefix(name, folder+"/") {
+				return nil, errOutsideArchiveFolder
+			}
+			seen[name] = true
+			file, err := readableFile(user, name)
+			if err != nil {
+				return nil, err
+			}
+			request.files = append(request.files, *file)
+		}
+	} else {
+		request.files, err = folderFiles(user, folder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(request.files, func(i, j int) bool { return request.files[i].Name < request.files[j].Name })
+	return request, nil
+}
+
+// folderFiles возвращает доступные пользователю файлы папки и всех ее подпапок
+func folderFiles(user *models.User, folder string) ([]models.File, error) {
+	access, err := userAccess(user)
+	if err != nil {
+		return nil, err
+	}
+	if !access.CanSee(folder) {
+		return nil, storage.ErrFolderNotFound
+	}
+	if folder != "" {
+		if _, err := storage.FolderStoreInstance.GetFolder(folder); err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := storage.FileStoreInstance.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	var inside []models.File
+	for _, file := range filterFiles(access, files) {
+		if folder == "" || strings.HasPrefix(file.Name, folder+"/") {
+			inside = append(inside, file)
+		}
+	}
+	return inside, nil
+}
+
+// entryName путь файла внутри архива относительно корня архива
+func (a *archiveRequest) entryName(file *models.File) string {
+	if a.folder == "" {
+		return file.Name
+	}
+	return strings.TrimPrefix(file.Name, a.folder+"/")
+}
+
+// filename имя скачиваемого архива: по имени папки, для корня - "files"
+func (a *archiveRequest) filename() string {
+	name := "files"
+	if a.folder != "" {
+		name = storage.BaseName(a.folder)
+	}
+	return name + "." + a.format
+}
+
+// archiveWriter пишет записи архива одну за другой
+type archiveWriter interface {
+	add(file *models.File, name string, content io.Reader) error
+	Close() error
+}
+
+// zipArchive архив ZIP. Размеры записей заранее не пишутся (data descriptor), поэтому
+// архив отдается потоком; для файлов больше 4 ГБ и архивов больше 65535 файлов
+// archive/zip сам переходит на ZIP64.
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a *zipArchive) add(file *models.File, name string, content io.Reader) error {
+	header := &zip.FileHeader{Name: name, Method: zipMethod(file.MimeType), Modified: file.UpdatedAt}
+	entry, err := a.w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, content)
+	return err
+}
+
+func (a *zipArchive) Close() error { return a.w.Close() }
+
+// zipMethod не сжимает повторно то, что уже сжато: картинки, видео, звук и архивы
+func zipMethod(mimeType string) uint16 {
+	switch {
+	case mimeType == "image/bmp", strings.HasPrefix(mimeType, "image/svg"):
+		return zip.Deflate
+	case strings.HasPrefix(mimeType, "image/"), strings.HasPrefix(mimeType, "video/"), strings.HasPrefix(mimeType, "audio/"),
+		mimeType == "application/zip", mimeType == "application/gzip", mimeType == "application/x-7z-compressed":
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// tarGzArchive архив tar, сжатый gzip. Длинные имена и имена не в ASCII записываются
+// в заголовках PAX.
+type tarGzArchive struct {
+	gz *gzip.Writer
+	w  *tar.Writer
+}
+
+func newTarGzArchive(w io.Writer) *tarGzArchive {
+	gz := gzip.NewWriter(w)
+	return &tarGzArchive{gz: gz, w: tar.NewWriter(gz)}
+}
+
+func (a *tarGzArchive) add(file *models.File, name string, content io.Reader) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     file.Size,
+		Mode:     0644,
+		ModTime:  file.UpdatedAt,
+	}
+	if err := a.w.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.w, content)
+	return err
+}
+
+func (a *tarGzArchive) Close() error {
+	if err := a.w.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// writeArchive отдает архив потоком: файлы читаются из хранилища по одному и сразу
+// уходят клиенту, ничего не складывается на диск. Каждый файл пишется в журнал как
+// отдельное скачивание. Если хранилище подвело посреди архива, статус уже отправлен,
+// поэтому соединение обрывается, чтобы клиент не принял неполный архив за целый.
+func writeArchive(w http.ResponseWriter, r *http.Request, user *models.User, request *archiveRequest) {
+	contentType := "application/zip"
+	if request.format == archiveTarGz {
+		contentType = "application/gzip"
+	}
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", contentDisposition("attachment", request.filename()))
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Cache-Control", "private, no-store")
+
+	var archive archiveWriter
+	if request.format == archiveTarGz {
+		archive = newTarGzArchive(w)
+	} else {
+		archive = &zipArchive{w: zip.NewWriter(w)}
+	}
+
+	for i := range request.files {
+		file := &request.files[i]
+		if err := addToArchive(r.Context(), archive, file, request.entryName(file)); err != nil {
+			log.Printf("Archive download of %s failed: %v", file.Name, err)
+			panic(http.ErrAbortHandler)
+		}
+		if err := storage.LogStoreInstance.AddLog(user.Username, models.ActionDownload, file.Name); err != nil {
+			log.Printf("Failed to log download action: %v", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Archive download failed: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// addToArchive копирует содержимое файла из хранилища в архив
+func addToArchive(ctx context.Context, archive archiveWriter, file *models.File, name string) error {
+	content, err := storage.BlobStoreInstance.Get(ctx, file.StoredKey, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	return archive.add(file, name, content)
+}
+
+// archiveErrorStatus подбирает код ответа для ошибки разбора запроса архива
+func archiveErrorStatus(err error) (int, string) {
+	if errors.Is(err, errInvalidArchiveFormat) || errors.Is(err, errOutsideArchiveFolder) {
+		return http.StatusBadRequest, err.Error()
+	}
+	status, message := pathErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("Archive request failed: %v", err)
+	}
+	return status, message
+}
+
+// ArchiveHandler скачивает выбранные файлы или целую папку одним архивом
+func ArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanDownload {
+		http.Error(w, "You don't have permission to download files", http.StatusForbidden)
+		return
+	}
+
+	request, err := parseArchiveRequest(r, user)
+	if err != nil {
+		status, message := archiveErrorStatus(err)
+		http.Error(w, message, status)
+		return
+	}
+	writeArchive(w, r, user, request)
+}
+
+// APIArchiveHandler то же, что ArchiveHandler, с ошибками в формате API
+func APIArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanDownload {
+		writeJSONError(w, http.StatusForbidden, "you don't have permission to download files")
+		return
+	}
+
+	request, err := parseArchiveRequest(r, user)
+	if err != nil {
+		status, message := archiveErrorStatus(err)
+		writeJSONError(w, status, message)
+		return
+	}
+	writeArchive(w, r, user, request)
+}
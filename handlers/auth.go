@@ -1,48 +1,170 @@
 package handlers
 
 import (
+	"errors"
+	"file-exchange-app/models"
 	"file-exchange-app/storage"
+	"fmt"
 	"html/template"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
-	"github.com/gorilla/sessions"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-var store = sessions.NewCookieStore([]byte("your-secret-key-change-in-production"))
+// LoginAttemptsCounter счетчик попыток входа с меткой status: success, failure или blocked
+// (попытка отклонена защитой от подбора без проверки пароля). Задается в main.
+var LoginAttemptsCounter *prometheus.CounterVec
 
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		tmpl := template.Must(template.ParseFiles("templates/login.html"))
-		tmpl.Execute(w, nil)
+		renderLoginPage(w, r, http.StatusOK, "")
 	} else if r.Method == "POST" {
 		r.ParseForm()
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 
-		// Используем UserStore для проверки учетных данных
-		user, err := storage.UserStoreInstance.VerifyUserCredentials(username, password)
-		if err != nil {
+		// Пока действует задержка или блокировка, пароль даже не проверяем
+		if wait, err := storage.LoginThrottleInstance.Attempt(username, clientIP(r), time.Now()); err != nil {
+			if !loginThrottled(w, wait, err) {
+				log.Printf("Login throttle error for %s: %v", username, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// Учетные данные проверяет провайдер пользователя: локальная база или каталог LDAP
+		user, err := storage.AuthenticatorInstance.Authenticate(username, password)
+		if errors.Is(err, storage.ErrUserDisabled) {
+			recordLoginAttempt(r, username, false, "account disabled")
+			http.Error(w, "Account is disabled", http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, storage.ErrNotPermitted) {
+			recordLoginAttempt(r, username, false, "not permitted")
+			http.Error(w, "Account is not permitted to sign in", http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, storage.ErrInvalidCredentials) {
+			recordLoginAttempt(r, username, false, "invalid credentials")
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
+		if err != nil {
+			// Пароль не проверен по вине сервера, попытку не засчитываем
+			storage.LoginThrottleInstance.Cancel(username, clientIP(r))
+			log.Printf("Login error for %s: %v", username, err)
+			http.Error(w, "Authentication service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		// С включенной 2FA сессия станет авторизованной только после второго шага,
+		// а счетчик неудач до тех пор не сбрасывается
+		if user.TOTPEnabled {
+			startTwoFactorLogin(w, r, user)
+			return
+		}
+
+		loginSucceeded(w, r, user, "password")
+	}
+}
+
+// loginThrottled отвечает 429, если попытка входа отклонена защитой от подбора.
+// Возвращает false для остальных ошибок, на них отвечает вызывающий.
+func loginThrottled(w http.ResponseWriter, wait time.Duration, err error) bool {
+	var message string
+	switch {
+	case errors.Is(err, storage.ErrAccountLocked):
+		message = "Account is temporarily locked after too many failed attempts"
+	case errors.Is(err, storage.ErrLoginThrottled):
+		message = "Too many failed attempts"
+	default:
+		return false
+	}
+
+	countLoginAttempt("blocked")
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, fmt.Sprintf("%s, try again in %s", message, time.Duration(seconds)*time.Second), http.StatusTooManyRequests)
+	return true
+}
+
+// loginSucceeded сбрасывает счетчик неудачных попыток, записывает вход в журнал и открывает сессию
+func loginSucceeded(w http.ResponseWriter, r *http.Request, user *models.User, method string) {
+	if err := storage.LoginThrottleInstance.Succeeded(user.Username, clientIP(r)); err != nil {
+		log.Printf("Failed to reset login failures of %s: %v", user.Username, err)
+	}
+	recordLoginAttempt(r, user.Username, true, method)
+	startSession(w, r, user)
+}
+
+// recordLoginAttempt пишет попытку входа в журнал и в метрику
+func recordLoginAttempt(r *http.Request, username string, success bool, details string) {
+	action, status := models.ActionLoginSuccess, "success"
+	if !success {
+		action, status = models.ActionLoginFailed, "failure"
+	}
+	storage.LogStoreInstance.AddLog(username, action, details+" from "+clientIP(r))
+	countLoginAttempt(status)
+}
 
-		session, _ := store.Get(r, "session-name")
-		session.Values["authenticated"] = true
-		session.Values["username"] = username
-		session.Values["userID"] = user.ID
-		session.Values["canUpload"] = user.CanUpload
-		session.Values["canDownload"] = user.CanDownload
-		session.Values["isAdmin"] = user.IsAdmin
-		session.Save(r, w)
+// countLoginAttempt увеличивает счетчик попыток входа, если метрики подключены
+func countLoginAttempt(status string) {
+	if LoginAttemptsCounter != nil {
+		LoginAttemptsCounter.WithLabelValues(status).Inc()
+	}
+}
 
-		// Редирект на главную страницу пользователя
-		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+// renderLoginPage выводит форму входа и, если настроен единый вход, кнопку для него
+func renderLoginPage(w http.ResponseWriter, r *http.Request, status int, errorMessage string) {
+	data := struct {
+		Error     string
+		OIDCName  string // пустое, если единый вход не настроен
+		CSRFToken string
+	}{Error: errorMessage, CSRFToken: csrfToken(w, r)}
+	if storage.OIDCSignInInstance != nil {
+		data.OIDCName = storage.OIDCSignInInstance.DisplayName()
 	}
+
+	tmpl := template.Must(template.ParseFiles("templates/login.html"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
 }
 
+// startSession помечает сессию авторизованной и отправляет пользователя на главную
+func startSession(w http.ResponseWriter, r *http.Request, user *models.User) {
+	session, _ := store.Get(r, sessionCookieName)
+	if err := store.RenewID(session); err != nil {
+		log.Printf("Failed to renew session: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	session.Values["authenticated"] = true
+	session.Values["username"] = user.Username
+	session.Values["userID"] = user.ID
+	delete(session.Values, pendingUserKey)
+	delete(session.Values, pendingAtKey)
+	// Токен, выданный странице входа, после входа заменяется новым
+	delete(session.Values, csrfTokenKey)
+	if err := session.Save(r, w); err != nil {
+		log.Printf("Failed to save session: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Редирект на главную страницу пользователя
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// LogoutHandler завершает сессию на сервере, так что сохраненная cookie больше не действует
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "session-name")
-	session.Values["authenticated"] = false
-	session.Save(r, w)
+	session, _ := store.Get(r, sessionCookieName)
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		log.Printf("Failed to delete session: %v", err)
+	}
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
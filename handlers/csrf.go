@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+const (
+	// csrfTokenKey ключ сессии с CSRF-токеном
+	csrfTokenKey = "csrfToken"
+	// csrfFormField скрытое поле форм с токеном
+	csrfFormField = "csrf_token"
+	// csrfHeader заголовок с токеном для XHR-запросов
+	csrfHeader = "X-CSRF-Token"
+	// csrfMaxLength ограничивает чтение поля с токеном из multipart-формы
+	csrfMaxLength = 256
+)
+
+// csrfExemptPrefixes пути без проверки токена. Публичные ссылки открываются без входа,
+// у их посетителей нет сессии, от имени которой можно было бы подделать запрос.
+var csrfExemptPrefixes = []string{"/s/"}
+
+// csrfToken возвращает CSRF-токен сессии, создавая его при первом обращении.
+// Вызывается до записи ответа: новая сессия выставляет cookie.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	session, _ := store.Get(r, sessionCookieName)
+	if token, ok := session.Values[csrfTokenKey].(string); ok && token != "" {
+		return token
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		log.Printf("Failed to generate CSRF token: %v", err)
+		return ""
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	session.Values[csrfTokenKey] = token
+	if err := session.Save(r, w); err != nil {
+		log.Printf("Failed to save CSRF token: %v", err)
+		return ""
+	}
+	return token
+}
+
+// CSRFMiddleware проверяет CSRF-токен у всех запросов, меняющих состояние. Токен берется
+// из заголовка X-CSRF-Token или из поля csrf_token формы. Запросы с заголовком Authorization
+// не проверяются: браузер не подставляет его сам, а cookie-сессия для них не используется.
+func CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "" || csrfExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, _ := store.Get(r, sessionCookieName)
+		expected, _ := session.Values[csrfTokenKey].(string)
+		if expected == "" || !validCSRFToken(requestCSRFToken(r), expected) {
+			http.Error(w, "Invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfExempt сообщает, что путь запроса не требует CSRF-токена
+func csrfExempt(r *http.Request) bool {
+	for _, prefix := range csrfExemptPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// validCSRFToken сравнивает токены за постоянное время
+func validCSRFToken(got, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(expected)) == 1
+}
+
+// requestCSRFToken достает токен из заголовка или формы
+func requestCSRFToken(r *http.Request) string {
+	if token := r.Header.Get(csrfHeader); token != "" {
+		return token
+	}
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return multipartCSRFToken(r, params["boundary"])
+	}
+	return r.PostFormValue(csrfFormField)
+}
+
+// multipartCSRFToken читает токен из первой части multipart-формы, не разбирая остальное
+// тело: файл дальше читает обработчик. Поэтому поле csrf_token должно стоять в форме первым.
+// Прочитанное начало тела возвращается в r.Body, и обработчик видит запрос целиком.
+func multipartCSRFToken(r *http.Request, boundary string) string {
+	if boundary == "" {
+		return ""
+	}
+	var consumed bytes.Buffer
+	body := r.Body
+	defer func() {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(&consumed, body), body}
+	}()
+
+	part, err := multipart.NewReader(io.TeeReader(body, &consumed), boundary).NextPart()
+	if err != nil || part.FormName() != csrfFormField {
+		return ""
+	}
+	value, err := io.ReadAll(io.LimitReader(part, csrfMaxLength))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(value))
+}
@@ -1,143 +1,223 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"file-exchange-app/models"
 	"file-exchange-app/storage"
+	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
-	"time"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
-// FileInfo представляет информацию о файле
-type FileInfo struct {
-	Name    string
-	Size    int64
-	ModTime time.Time
-}
-
 // DashboardHandler отображает главную страницу пользователя
 func DashboardHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "session-name")
-	username, _ := session.Values["username"].(string)
-	canUpload, _ := session.Values["canUpload"].(bool)
-	isAdmin, _ := session.Values["isAdmin"].(bool)
+	user := currentUser(r)
+
+	folder, err := storage.CleanPath(r.URL.Query().Get("folder"))
+	if err != nil {
+		http.Error(w, "Invalid folder", http.StatusBadRequest)
+		return
+	}
+
+	access, err := userAccess(user)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !access.CanSee(folder) {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
 
-	// Получаем список файлов
-	files, err := getFileList("./uploads")
+	// Получаем содержимое открытой папки и оставляем только доступное пользователю
+	folders, files, err := storage.FolderStoreInstance.ListFolder(folder)
 	if err != nil {
+		if errors.Is(err, storage.ErrFolderNotFound) {
+			http.Error(w, "Folder not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, "Error reading files", http.StatusInternalServerError)
 		return
 	}
+	canWrite, err := canWriteInto(access, folder)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
 
 	type TemplateData struct {
-		Username  string
-		CanUpload bool
-		IsAdmin   bool
-		Files     []FileInfo
+		Username    string
+		CanUpload   bool
+		CanDownload bool
+		IsAdmin     bool
+		CanWrite    bool
+		Folder      string
+		Breadcrumbs []Breadcrumb
+		Folders     []models.Folder
+		Files       []models.File
+		CSRFToken   string
 	}
 
 	data := TemplateData{
-		Username:  username,
-		CanUpload: canUpload,
-		IsAdmin:   isAdmin,
-		Files:     files,
+		Username:    user.Username,
+		CanUpload:   user.CanUpload,
+		CanDownload: user.CanDownload,
+		IsAdmin:     user.IsAdmin,
+		CanWrite:    user.CanUpload && canWrite,
+		Folder:      folder,
+		Breadcrumbs: breadcrumbs(folder),
+		Folders:     filterFolders(access, folders),
+		Files:       filterFiles(access, files),
+		CSRFToken:   csrfToken(w, r),
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/dashboard.html"))
+	tmpl := template.Must(template.New("dashboard.html").
+		Funcs(template.FuncMap{
+			"baseName": storage.BaseName,
+			// escapePath - путь в адресе ссылки: "#" и "?" в именах не должны обрезать путь
+			"escapePath": escapePath,
+			// viewable - файл можно открыть в браузере (см. inlineSafe)
+			"viewable": inlineSafe,
+			// can проверяет уровень доступа к пути: {{if can "manage" .Name}}
+			"can": func(permission, path string) bool { return access.Allows(path, permission) },
+		}).
+		ParseFiles("templates/dashboard.html"))
 	tmpl.Execute(w, data)
 }
 
-// Вспомогательная функция для получения списка файлов
-func getFileList(dir string) ([]FileInfo, error) {
-	var files []FileInfo
+// storeFile сохраняет содержимое src в хранилище под новым ключом и записывает
+// метаданные файла. SHA-256 и MIME-тип считаются в том же проходе, что и запись.
+// Что делать, если файл с таким именем уже есть, решает policy (см. conflictOverwrite).
+// size равен -1, если размер заранее неизвестен.
+func storeFile(ctx context.Context, ownerID int, name string, src io.Reader, size int64, policy string) (*models.File, error) {
+	key, inspector, err := putContent(ctx, src, size)
+	if err != nil {
+		return nil, err
+	}
+	return saveContent(ctx, ownerID, name, key, inspector, policy)
+}
 
-	entries, err := os.ReadDir(dir)
+// putContent записывает содержимое в хранилище под новым ключом. Пока по ключу нет
+// записи в БД, объект никому не виден.
+func putContent(ctx context.Context, src io.Reader, size int64) (string, *storage.ContentInspector, error) {
+	key, err := storage.NewBlobKey()
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Если папка не существует, создаем ее
-			os.MkdirAll(dir, 0755)
-			return files, nil
-		}
+		return "", nil, err
+	}
+
+	inspector := storage.NewContentInspector(src)
+	if err := storage.BlobStoreInstance.Put(ctx, key, inspector, size); err != nil {
+		return "", nil, err
+	}
+	return key, inspector, nil
+}
+
+// saveContent записывает метаданные файла, содержимое которого уже лежит в хранилище под key.
+// При policy=rename итоговое имя может отличаться от name.
+func saveContent(ctx context.Context, ownerID int, name, key string, inspector *storage.ContentInspector, policy string) (*models.File, error) {
+	file := &models.File{
+		OwnerID:   ownerID,
+		Name:      name,
+		StoredKey: key,
+		Size:      inspector.Size(),
+		SHA256:    inspector.SHA256(),
+		MimeType:  inspector.MimeType(name),
+	}
+
+	var orphans []string
+	var err error
+	switch policy {
+	case conflictReject:
+		err = storage.FileStoreInstance.CreateFile(file)
+	case conflictRename:
+		err = createRenamedFile(file)
+	default:
+		orphans, err = storage.FileStoreInstance.SaveFile(file)
+	}
+	if err != nil {
+		// Без записи в БД объект никому не виден, убираем его
+		storage.BlobStoreInstance.Delete(ctx, key)
 		return nil, err
 	}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			info, err := entry.Info()
-			if err != nil {
-				continue
-			}
-			files = append(files, FileInfo{
-				Name:    entry.Name(),
-				Size:    info.Size(),
-				ModTime: info.ModTime(),
-			})
+	removeBlobs(ctx, orphans)
+	return file, nil
+}
+
+// removeBlobs удаляет из хранилища объекты, на которые больше не ссылается ни одна версия.
+// Ошибки только логируем: запись в БД уже изменена, а лишний объект не мешает работе.
+func removeBlobs(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := storage.BlobStoreInstance.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrBlobNotFound) {
+			log.Printf("Failed to delete stored content %s: %v", key, err)
 		}
 	}
-	return files, nil
+}
+
+// deleteFile удаляет файл со всеми версиями и их содержимое
+func deleteFile(ctx context.Context, name string) error {
+	file, err := storage.FileStoreInstance.GetFileByName(name)
+	if err != nil {
+		return err
+	}
+	keys, err := storage.FileStoreInstance.DeleteFile(file.ID)
+	if err != nil {
+		return err
+	}
+	removeBlobs(ctx, keys)
+	return nil
 }
 
 // UploadHandler обрабатывает загрузку файлов
-func UploadHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "session-name")
-	username, _ := session.Values["username"].(string)
-	canUpload, _ := session.Values["canUpload"].(bool)
+func (u *Uploads) UploadHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
 
-	if !canUpload {
+	if !user.CanUpload {
 		http.Error(w, "You don't have permission to upload files", http.StatusForbidden)
 		return
 	}
 
-	// Ограничиваем размер файла (например, 100MB)
-	r.ParseMultipartForm(100 << 20)
-
-	file, handler, err := r.FormFile("file")
+	// Форма читается потоком: файл идет в хранилище, не задерживаясь в памяти и временных файлах
+	results, err := u.streamUpload(w, r, user)
 	if err != nil {
-		http.Error(w, "Error retrieving the file", http.StatusBadRequest)
+		u.writeUploadError(w, err)
 		return
 	}
-	defer file.Close()
 
-	// Создаем папку uploads если ее нет
-	if _, err := os.Stat("./uploads"); os.IsNotExist(err) {
-		os.MkdirAll("./uploads", 0755)
-	}
+	// Логируем действие
+	logUploads(user, results)
 
-	// Создаем файл на диске
-	dst, err := os.Create(filepath.Join("./uploads", handler.Filename))
-	if err != nil {
-		http.Error(w, "Error creating file", http.StatusInternalServerError)
-		return
+	// Если что-то не сохранилось, показываем, какие файлы и почему
+	var failed []string
+	for _, result := range results {
+		if result.File == nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", result.Name, result.Error))
+		}
 	}
-	defer dst.Close()
-
-	// Копируем содержимое файла
-	if _, err := io.Copy(dst, file); err != nil {
-		http.Error(w, "Error saving file", http.StatusInternalServerError)
+	if len(failed) > 0 {
+		message := strings.Join(failed, "\n")
+		if len(results) > 1 {
+			message = fmt.Sprintf("Uploaded %d of %d files\n%s", len(results)-len(failed), len(results), message)
+		}
+		http.Error(w, message, uploadsStatus(results))
 		return
 	}
 
-	// Логируем действие
-	_, err = storage.DB.Exec(
-		"INSERT INTO logs (username, action, filename) VALUES (?, ?, ?)",
-		username, "upload", handler.Filename,
-	)
-
-	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	http.Redirect(w, r, folderURL(storage.ParentPath(results[0].File.Name)), http.StatusSeeOther)
 }
 
 // DownloadHandler обрабатывает скачивание файлов
 func DownloadHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "session-name")
-	username, _ := session.Values["username"].(string)
-	canDownload, _ := session.Values["canDownload"].(bool)
+	user := currentUser(r)
 
-	if !canDownload {
+	if !user.CanDownload {
 		http.Error(w, "You don't have permission to download files", http.StatusForbidden)
 		return
 	}
@@ -145,24 +225,184 @@ func DownloadHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	filename := vars["filename"]
 
-	// Проверяем существование файла
-	filePath := filepath.Join("./uploads", filename)
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		http.Error(w, "File not found", http.StatusNotFound)
+	// Проверяем существование файла и право на чтение
+	file, err := readableFile(user, filename)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
 		return
 	}
 
-	_, err := storage.DB.Exec(
-		"INSERT INTO logs (username, action, filename) VALUES (?, ?, ?)",
-		username, "download", filename,
-	)
+	// По ?version=N отдаем одну из прошлых версий
+	requested, err := fileAtVersion(r, file)
 	if err != nil {
-		log.Printf("Failed to log download action: %v", err)
-		// Можно также вернуть ошибку или обработать её другим способом
+		if errors.Is(err, errInvalidVersion) || errors.Is(err, storage.ErrVersionNotFound) {
+			http.Error(w, "Version not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
 	}
 
 	// Отдаем файл пользователю
-	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
-	w.Header().Set("Content-Type", "application/octet-stream")
-	http.ServeFile(w, r, filePath)
+	status := serveFile(w, r, requested)
+	if countsAsDownload(r, status) {
+		if err := storage.LogStoreInstance.AddLog(user.Username, models.ActionDownload, downloadLogName(requested, file)); err != nil {
+			log.Printf("Failed to log download action: %v", err)
+			// Можно также вернуть ошибку или обработать её другим способом
+		}
+	}
+}
+
+// countsAsDownload сообщает, что ответ нужно записать в журнал как скачивание. HEAD только
+// узнает размер и ETag, 304 подтверждает копию в кэше браузера, а 206 с диапазоном не
+// с начала файла - докачка или перемотка уже записанного скачивания.
+func countsAsDownload(r *http.Request, status int) bool {
+	if r.Method == http.MethodHead {
+		return false
+	}
+	switch status {
+	case http.StatusOK:
+		return true
+	case http.StatusPartialContent:
+		return strings.HasPrefix(r.Header.Get("Range"), "bytes=0-")
+	}
+	return false
+}
+
+// mayCountAsDownload сообщает, может ли ответ на запрос засчитаться скачиванием
+// (см. countsAsDownload), еще до того, как он отправлен
+func mayCountAsDownload(r *http.Request) bool {
+	rangeHeader := r.Header.Get("Range")
+	return r.Method != http.MethodHead && (rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-"))
+}
+
+// statusWriter запоминает код ответа, который отправил обработчик
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap нужен http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveFile отдает содержимое файла через http.ServeContent, поэтому для любого бэкенда
+// хранилища работают Range-запросы (в том числе несколько диапазонов), If-Range,
+// If-None-Match и If-Modified-Since. Сильный ETag - SHA-256 содержимого, так что докачка
+// (curl -C -) не склеит части разных версий. С ?inline=1 безопасные типы открываются в браузере.
+// Возвращает отправленный код ответа.
+func serveFile(w http.ResponseWriter, r *http.Request, file *models.File) int {
+	content := storage.NewBlobReadSeeker(r.Context(), storage.BlobStoreInstance, file.StoredKey, file.Size)
+	defer content.Close()
+
+	mimeType := file.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if r.URL.Query().Get("inline") == "1" && inlineSafe(mimeType) {
+		disposition = "inline"
+	}
+
+	header := w.Header()
+	header.Set("Content-Disposition", contentDisposition(disposition, storage.BaseName(file.Name)))
+	header.Set("Content-Type", mimeType)
+	// Браузер не должен угадывать тип: иначе текст с разметкой мог бы исполниться как HTML
+	header.Set("X-Content-Type-Options", "nosniff")
+	// Файлы закрыты авторизацией: кэшировать можно только в браузере и только с проверкой ETag
+	header.Set("Cache-Control", "private, no-cache")
+	if file.SHA256 != "" {
+		header.Set("ETag", `"`+file.SHA256+`"`)
+	}
+	recorder := &statusWriter{ResponseWriter: w}
+	http.ServeContent(recorder, r, file.Name, file.UpdatedAt, content)
+	return recorder.status
+}
+
+// inlineSafeTypes типы, которые можно показывать в браузере: они не исполняют скриптов
+// в контексте сайта. HTML, SVG и XML всегда скачиваются.
+var inlineSafeTypes = map[string]bool{
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/avif":      true,
+	"image/bmp":       true,
+	"text/plain":      true,
+	"audio/mpeg":      true,
+	"audio/ogg":       true,
+	"audio/wav":       true,
+	"audio/webm":      true,
+	"audio/flac":      true,
+	"video/mp4":       true,
+	"video/webm":      true,
+	"video/ogg":       true,
+}
+
+// inlineSafe сообщает, можно ли открыть файл с таким MIME-типом прямо в браузере
+func inlineSafe(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	return err == nil && inlineSafeTypes[mediaType]
+}
+
+// contentDisposition строит заголовок Content-Disposition по RFC 6266. Имя с не-ASCII
+// символами (например, кириллицей) передается в filename* в UTF-8 по RFC 5987, а в filename
+// остается ASCII-вариант для старых клиентов.
+func contentDisposition(disposition, name string) string {
+	var fallback strings.Builder
+	ascii := true
+	for _, c := range name {
+		switch {
+		case c < 0x20 || c > 0x7e:
+			ascii = false
+			fallback.WriteByte('_')
+		case c == '"' || c == '\\' || c == '%':
+			// Кавычки и обратная косая черта ломают quoted-string, а % некоторые браузеры раскодируют
+			ascii = false
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteRune(c)
+		}
+	}
+
+	header := fmt.Sprintf(`%s; filename="%s"`, disposition, fallback.String())
+	if !ascii {
+		header += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+	return header
+}
+
+// encodeRFC5987 кодирует значение ext-value: все, кроме attr-char, в виде %XX по байтам UTF-8
+func encodeRFC5987(value string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	var encoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		b := value[i]
+		if 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || strings.IndexByte(attrChars, b) >= 0 {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
 }
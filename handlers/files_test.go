@@ -0,0 +1,44 @@
+package handlers
+
+import "testing"
+
+func TestEncodeRFC5987(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"a b", "a%20b"},
+		{"отчет.pdf", "%D0%BE%D1%82%D1%87%D0%B5%D1%82.pdf"},
+		{`"quoted"`, "%22quoted%22"},
+		{"100%", "100%25"},
+		{"a;b,c", "a%3Bb%2Cc"},
+		{"x'y*z", "x%27y%2Az"},
+		{"!#$&+-.^_`|~", "!#$&+-.^_`|~"},
+	}
+	for _, tt := range tests {
+		if got := encodeRFC5987(tt.value); got != tt.want {
+			t.Errorf("encodeRFC5987(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		disposition string
+		name        string
+		want        string
+	}{
+		{"attachment", "report.pdf", `attachment; filename="report.pdf"`},
+		{"inline", "photo 1.jpg", `inline; filename="photo 1.jpg"`},
+		{"attachment", "отчет.pdf", `attachment; filename="_____.pdf"; filename*=UTF-8''%D0%BE%D1%82%D1%87%D0%B5%D1%82.pdf`},
+		{"attachment", `a"b\c.txt`, `attachment; filename="a_b_c.txt"; filename*=UTF-8''a%22b%5Cc.txt`},
+		{"attachment", "50%.txt", `attachment; filename="50_.txt"; filename*=UTF-8''50%25.txt`},
+		{"attachment", "tab\there", `attachment; filename="tab_here"; filename*=UTF-8''tab%09here`},
+	}
+	for _, tt := range tests {
+		if got := contentDisposition(tt.disposition, tt.name); got != tt.want {
+			t.Errorf("contentDisposition(%q, %q) = %s, want %s", tt.disposition, tt.name, got, tt.want)
+		}
+	}
+}
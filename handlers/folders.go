@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Breadcrumb один элемент навигационной цепочки над списком файлов
+type Breadcrumb struct {
+	Name string
+	Path string
+}
+
+// breadcrumbs строит цепочку от корня до папки path
+func breadcrumbs(path string) []Breadcrumb {
+	crumbs := []Breadcrumb{{Name: "Home", Path: ""}}
+	current := ""
+	if path == "" {
+		return crumbs
+	}
+	for _, segment := range splitPath(path) {
+		current = storage.JoinPath(current, segment)
+		crumbs = append(crumbs, Breadcrumb{Name: segment, Path: current})
+	}
+	return crumbs
+}
+
+// splitPath разбивает очищенный путь на сегменты
+func splitPath(path string) []string {
+	var segments []string
+	for path != "" {
+		segments = append([]string{storage.BaseName(path)}, segments...)
+		path = storage.ParentPath(path)
+	}
+	return segments
+}
+
+// escapePath экранирует сегменты пути для URL, сохраняя разделители "/"
+func escapePath(path string) string {
+	segments := splitPath(path)
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// folderURL адрес дашборда, открытого на папке path
+func folderURL(path string) string {
+	if path == "" {
+		return "/dashboard"
+	}
+	return "/dashboard?folder=" + url.QueryEscape(path)
+}
+
+// pathErrorStatus переводит ошибки работы с файлами и папками в HTTP-статус и сообщение
+func pathErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidPath):
+		// Ошибки нормализации имен объясняют, что не так с именем
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, errAccessDenied):
+		return http.StatusForbidden, "access denied"
+	case errors.Is(err, errTokenScope):
+		return http.StatusForbidden, err.Error()
+	case errors.Is(err, storage.ErrFolderNotFound):
+		return http.StatusNotFound, "folder not found"
+	case errors.Is(err, storage.ErrFileNotFound):
+		return http.StatusNotFound, "file not found"
+	case errors.Is(err, storage.ErrFolderExists):
+		return http.StatusConflict, "folder already exists"
+	case errors.Is(err, storage.ErrFileExists):
+		return http.StatusConflict, "file already exists"
+	case errors.Is(err, storage.ErrPathConflict):
+		return http.StatusConflict, "path is used by another file or folder"
+	case errors.Is(err, storage.ErrFolderNotEmpty):
+		return http.StatusConflict, "folder is not empty"
+	}
+	return http.StatusInternalServerError, "database error"
+}
+
+// writePathError отвечает текстовой ошибкой для HTML-хендлеров
+func writePathError(w http.ResponseWriter, err error) {
+	status, message := pathErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("File operation failed: %v", err)
+	}
+	http.Error(w, message, status)
+}
+
+// writeAPIPathError отвечает JSON-ошибкой для API
+func writeAPIPathError(w http.ResponseWriter, err error) {
+	status, message := pathErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("File operation failed: %v", err)
+	}
+	writeJSONError(w, status, message)
+}
+
+// createFolder создает папку и пишет запись в журнал
+func createFolder(user *models.User, rawPath string) (*models.Folder, error) {
+	path, err := storage.NormalizePath(rawPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireWriteInto(user, storage.ParentPath(path)); err != nil {
+		return nil, err
+	}
+	folder, err := storage.FolderStoreInstance.CreateFolder(path, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionCreateFolder, path)
+	return folder, nil
+}
+
+// moveFolder переименовывает или переносит папку и пишет запись в журнал
+func moveFolder(user *models.User, rawOld, rawNew string) (string, error) {
+	if err := requireChange(user); err != nil {
+		return "", err
+	}
+	oldPath, err := storage.CleanPath(rawOld)
+	if err != nil {
+		return "", err
+	}
+	newPath, err := storage.NormalizePath(rawNew)
+	if err != nil {
+		return "", err
+	}
+	if err := requireAccess(user, oldPath, models.PermissionManage); err != nil {
+		return "", err
+	}
+	if err := requireWriteInto(user, storage.ParentPath(newPath)); err != nil {
+		return "", err
+	}
+	if err := storage.FolderStoreInstance.MoveFolder(oldPath, newPath, user.ID); err != nil {
+		return "", err
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionMoveFolder, fmt.Sprintf("%s -> %s", oldPath, newPath))
+	return newPath, nil
+}
+
+// deleteFolder удаляет папку, содержимое в хранилище и пишет запись в журнал
+func deleteFolder(r *http.Request, user *models.User, rawPath string, recursive bool) error {
+	if err := requireChange(user); err != nil {
+		return err
+	}
+	path, err := storage.CleanPath(rawPath)
+	if err != nil {
+		return err
+	}
+	if err := requireAccess(user, path, models.PermissionManage); err != nil {
+		return err
+	}
+	keys, err := storage.FolderStoreInstance.DeleteFolder(path, recursive)
+	if err != nil {
+		return err
+	}
+	removeBlobs(r.Context(), keys)
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionDeleteFolder, path)
+	return nil
+}
+
+// moveFile переименовывает или переносит файл и пишет запись в журнал
+func moveFile(user *models.User, name, rawNew string) (string, error) {
+	if err := requireChange(user); err != nil {
+		return "", err
+	}
+	newName, err := storage.NormalizePath(rawNew)
+	if err != nil {
+		return "", err
+	}
+	if newName == "" {
+		return "", storage.ErrInvalidPath
+	}
+	file, err := readableFile(user, name)
+	if err != nil {
+		return "", err
+	}
+	if err := requireAccess(user, name, models.PermissionManage); err != nil {
+		return "", err
+	}
+	if err := requireWriteInto(user, storage.ParentPath(newName)); err != nil {
+		return "", err
+	}
+	if err := storage.FileStoreInstance.MoveFile(file.ID, newName, user.ID); err != nil {
+		return "", err
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionMoveFile, fmt.Sprintf("%s -> %s", name, newName))
+	return newName, nil
+}
+
+// CreateFolderHandler создает папку внутри parent
+func CreateFolderHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		http.Error(w, "You don't have permission to create folders", http.StatusForbidden)
+		return
+	}
+
+	r.ParseForm()
+	parent := r.FormValue("parent")
+	name := r.FormValue("name")
+	if name == "" {
+		http.Error(w, "Folder name is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := createFolder(user, storage.JoinPath(parent, name)); err != nil {
+		writePathError(w, err)
+		return
+	}
+	http.Redirect(w, r, folderURL(parent), http.StatusSeeOther)
+}
+
+// MoveFolderHandler переименовывает папку или переносит ее по новому пути
+func MoveFolderHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		http.Error(w, "You don't have permission to move folders", http.StatusForbidden)
+		return
+	}
+
+	r.ParseForm()
+	newPath, err := moveFolder(user, r.FormValue("path"), r.FormValue("new_path"))
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	http.Redirect(w, r, folderURL(storage.ParentPath(newPath)), http.StatusSeeOther)
+}
+
+// DeleteFolderHandler удаляет папку; непустую - только если отмечен recursive
+func DeleteFolderHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		http.Error(w, "You don't have permission to delete folders", http.StatusForbidden)
+		return
+	}
+
+	r.ParseForm()
+	path := r.FormValue("path")
+	if err := deleteFolder(r, user, path, r.FormValue("recursive") == "on"); err != nil {
+		writePathError(w, err)
+		return
+	}
+	http.Redirect(w, r, folderURL(storage.ParentPath(path)), http.StatusSeeOther)
+}
+
+// MoveFileHandler переименовывает файл или переносит его в другую папку
+func MoveFileHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		http.Error(w, "You don't have permission to move files", http.StatusForbidden)
+		return
+	}
+
+	r.ParseForm()
+	newName, err := moveFile(user, r.FormValue("name"), r.FormValue("new_name"))
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	http.Redirect(w, r, folderURL(storage.ParentPath(newName)), http.StatusSeeOther)
+}
+
+// folderRequest тело запросов API для работы с папками
+type folderRequest struct {
+	Path    string `json:"path"`
+	NewPath string `json:"new_path"`
+}
+
+// APIListFolderHandler возвращает подпапки и файлы папки из ?path= (корень по умолчанию)
+func APIListFolderHandler(w http.ResponseWriter, r *http.Request) {
+	path, err := storage.CleanPath(r.URL.Query().Get("path"))
+	if err != nil {
+		writeAPIPathError(w, err)
+		return
+	}
+
+	access, err := userAccess(currentUser(r))
+	if err != nil {
+		writeAPIPathError(w, err)
+		return
+	}
+	if !access.CanSee(path) {
+		writeAPIPathError(w, storage.ErrFolderNotFound)
+		return
+	}
+
+	folders, files, err := storage.FolderStoreInstance.ListFolder(path)
+	if err != nil {
+		writeAPIPathError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"path":    path,
+		"folders": filterFolders(access, folders),
+		"files":   filterFiles(access, files),
+	})
+}
+
+// APICreateFolderHandler создает папку (и недостающие родительские)
+func APICreateFolderHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		writeJSONError(w, http.StatusForbidden, "you don't have permission to create folders")
+		return
+	}
+
+	var req folderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	folder, err := createFolder(user, req.Path)
+	if err != nil {
+		writeAPIPathError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, folder)
+}
+
+// APIMoveFolderHandler переименовывает или переносит папку
+func APIMoveFolderHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		writeJSONError(w, http.StatusForbidden, "you don't have permission to move folders")
+		return
+	}
+
+	var req folderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	newPath, err := moveFolder(user, req.Path, req.NewPath)
+	if err != nil {
+		writeAPIPathError(w, err)
+		return
+	}
+	folder, err := storage.FolderStoreInstance.GetFolder(newPath)
+	if err != nil {
+		writeAPIPathError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, folder)
+}
+
+// APIDeleteFolderHandler удаляет папку из ?path=; непустую - только с ?recursive=true
+func APIDeleteFolderHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		writeJSONError(w, http.StatusForbidden, "you don't have permission to delete folders")
+		return
+	}
+
+	query := r.URL.Query()
+	if err := deleteFolder(r, user, query.Get("path"), query.Get("recursive") == "true"); err != nil {
+		writeAPIPathError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// moveFileRequest тело запроса на переименование или перенос файла
+type moveFileRequest struct {
+	Name string `json:"name"`
+}
+
+// APIMoveFileHandler переименовывает файл или переносит его по новому пути
+func APIMoveFileHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		writeJSONError(w, http.StatusForbidden, "you don't have permission to move files")
+		return
+	}
+
+	var req moveFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	newName, err := moveFile(user, mux.Vars(r)["filename"], req.Name)
+	if err != nil {
+		writeAPIPathError(w, err)
+		return
+	}
+	file, err := storage.FileStoreInstance.GetFileByName(newName)
+	if err != nil {
+		writeAPIPathError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, file)
+}
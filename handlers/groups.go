@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"encoding/json"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// groupRequest тело запроса на создание или изменение группы. Пустая роль - группа без роли.
+type groupRequest struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// memberRequest тело запроса на добавление участника группы
+type memberRequest struct {
+	Username string `json:"username"`
+}
+
+// groupRoleID находит роль группы по имени; пустое имя - без роли (0)
+func groupRoleID(roleName string) (int, error) {
+	if roleName == "" {
+		return 0, nil
+	}
+	role, err := storage.RoleStoreInstance.GetRoleByName(roleName)
+	if err != nil {
+		return 0, err
+	}
+	return role.ID, nil
+}
+
+// createGroup создает группу с необязательной ролью и пишет запись в журнал
+func createGroup(admin *models.User, name, roleName string) (*models.Group, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errNameRequired
+	}
+	roleID, err := groupRoleID(roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := storage.GroupStoreInstance.CreateGroup(name)
+	if err != nil {
+		return nil, err
+	}
+	if roleID != 0 {
+		if err := storage.GroupStoreInstance.SetGroupRole(group.ID, roleID); err != nil {
+			return nil, err
+		}
+		group.RoleID, group.RoleName = roleID, roleName
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionCreateGroup, "Group: "+group.Name)
+	return group, nil
+}
+
+// setGroupRole назначает или снимает роль группы и пишет запись в журнал
+func setGroupRole(admin *models.User, id int, roleName string) (*models.Group, error) {
+	roleID, err := groupRoleID(roleName)
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.GroupStoreInstance.SetGroupRole(id, roleID); err != nil {
+		return nil, err
+	}
+
+	group, err := storage.GroupStoreInstance.GetGroupByID(id)
+	if err != nil {
+		return nil, err
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionUpdateGroup,
+		fmt.Sprintf("Group: %s role: %s", group.Name, roleName))
+	return group, nil
+}
+
+// deleteGroup удаляет группу и пишет запись в журнал
+func deleteGroup(admin *models.User, id int) error {
+	group, err := storage.GroupStoreInstance.GetGroupByID(id)
+	if err != nil {
+		return err
+	}
+	if err := storage.GroupStoreInstance.DeleteGroup(id); err != nil {
+		return err
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionDeleteGroup, "Group: "+group.Name)
+	return nil
+}
+
+// addGroupMember добавляет пользователя в группу и пишет запись в журнал
+func addGroupMember(admin *models.User, id int, username string) error {
+	group, err := storage.GroupStoreInstance.GetGroupByID(id)
+	if err != nil {
+		return err
+	}
+	user, err := storage.UserStoreInstance.GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if err := storage.GroupStoreInstance.AddMember(id, user.ID); err != nil {
+		return err
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionUpdateGroup,
+		fmt.Sprintf("Group: %s added: %s", group.Name, user.Username))
+	return nil
+}
+
+// removeGroupMember исключает пользователя из группы и пишет запись в журнал
+func removeGroupMember(admin *models.User, id, userID int) error {
+	group, err := storage.GroupStoreInstance.GetGroupByID(id)
+	if err != nil {
+		return err
+	}
+	if err := storage.GroupStoreInstance.RemoveMember(id, userID); err != nil {
+		return err
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionUpdateGroup,
+		fmt.Sprintf("Group: %s removed user ID: %d", group.Name, userID))
+	return nil
+}
+
+// CreateGroupHandler создает группу из формы админки
+func CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if _, err := createGroup(currentUser(r), r.FormValue("name"), r.FormValue("role")); err != nil {
+		adminFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// SetGroupRoleHandler меняет роль группы из формы админки
+func SetGroupRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	r.ParseForm()
+	if _, err := setGroupRole(currentUser(r), id, r.FormValue("role")); err != nil {
+		adminFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// DeleteGroupHandler удаляет группу
+func DeleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := deleteGroup(currentUser(r), id); err != nil {
+		adminFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// AddGroupMemberHandler добавляет пользователя в группу из формы админки
+func AddGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	r.ParseForm()
+	if err := addGroupMember(currentUser(r), id, r.FormValue("username")); err != nil {
+		adminFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// RemoveGroupMemberHandler исключает пользователя из группы
+func RemoveGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupID, _ := strconv.Atoi(vars["id"])
+	userID, _ := strconv.Atoi(vars["user_id"])
+	if err := removeGroupMember(currentUser(r), groupID, userID); err != nil {
+		adminFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// APIListGroupsHandler возвращает все группы
+func APIListGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	groups, err := storage.GroupStoreInstance.ListGroups()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	if groups == nil {
+		groups = []models.Group{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"groups": groups})
+}
+
+// APICreateGroupHandler создает группу
+func APICreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var req groupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	group, err := createGroup(currentUser(r), req.Name, req.Role)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, group)
+}
+
+// APIUpdateGroupHandler меняет роль группы; имя в теле запроса игнорируется
+func APIUpdateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	var req groupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	group, err := setGroupRole(currentUser(r), id, req.Role)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, group)
+}
+
+// APIDeleteGroupHandler удаляет группу
+func APIDeleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := deleteGroup(currentUser(r), id); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIListGroupMembersHandler возвращает участников группы
+func APIListGroupMembersHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if _, err := storage.GroupStoreInstance.GetGroupByID(id); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	members, err := storage.GroupStoreInstance.ListMembers(id)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	if members == nil {
+		members = []models.User{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"members": members})
+}
+
+// APIAddGroupMemberHandler добавляет пользователя в группу
+func APIAddGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	var req memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	if err := addGroupMember(currentUser(r), id, req.Username); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIRemoveGroupMemberHandler исключает пользователя из группы
+func APIRemoveGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupID, _ := strconv.Atoi(vars["id"])
+	userID, _ := strconv.Atoi(vars["user_id"])
+	if err := removeGroupMember(currentUser(r), groupID, userID); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
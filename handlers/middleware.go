@@ -1,29 +1,169 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"log"
 	"net/http"
+	"strings"
 )
 
-// AuthMiddleware проверяет, авторизован ли пользователь
+// contextKey тип ключей для значений, которые middleware кладет в контекст запроса
+type contextKey string
+
+const (
+	userContextKey  contextKey = "user"
+	tokenContextKey contextKey = "token"
+)
+
+// errInvalidToken возвращается, если заголовок Authorization содержит неверный токен
+var errInvalidToken = errors.New("invalid or expired API token")
+
+// AuthMiddleware проверяет, авторизован ли пользователь (cookie-сессия или Bearer-токен),
+// и кладет пользователя в контекст запроса
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, _ := store.Get(r, "session-name")
-		if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
+		user, token, err := authenticate(r)
+		if err == errInvalidToken {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="file-exchange"`)
+			http.Error(w, "Invalid API token", http.StatusUnauthorized)
+			return
+		}
+		if user == nil {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-		next.ServeHTTP(w, r)
+		if !profileRequest(r) {
+			if err := accountSetupError(user); err != nil {
+				if err == errPasswordChangeRequired || err == errTwoFactorRequired {
+					http.Redirect(w, r, profilePath, http.StatusSeeOther)
+					return
+				}
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+		}
+		next.ServeHTTP(w, withUser(r, user, token))
 	})
 }
 
 // AdminMiddleware проверяет, является ли пользователь администратором
 func AdminMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, _ := store.Get(r, "session-name")
-		if isAdmin, ok := session.Values["isAdmin"].(bool); !ok || !isAdmin {
+	return AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !currentUser(r).IsAdmin {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
 		next.ServeHTTP(w, r)
+	}))
+}
+
+// APIAuthMiddleware проверяет авторизацию для JSON API и кладет пользователя в контекст.
+// В отличие от AuthMiddleware не делает редирект, а отвечает 401 с JSON-ошибкой.
+func APIAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, token, err := authenticate(r)
+		if err == errInvalidToken {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="file-exchange", error="invalid_token"`)
+			writeJSONError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if user == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="file-exchange"`)
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		// Пароль и 2FA настраиваются только на странице профиля
+		if err := accountSetupError(user); err != nil {
+			if err == errPasswordChangeRequired || err == errTwoFactorRequired {
+				writeJSONError(w, http.StatusForbidden, err.Error())
+				return
+			}
+			writeJSONError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		next.ServeHTTP(w, withUser(r, user, token))
+	})
+}
+
+// APIAdminMiddleware пропускает к JSON API только администраторов.
+// Должен стоять после APIAuthMiddleware.
+func APIAdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := currentUser(r); user == nil || !user.IsAdmin {
+			writeJSONError(w, http.StatusForbidden, "admin privileges required")
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
+
+// authenticate определяет пользователя по заголовку Authorization: Bearer или по cookie-сессии.
+// Если заголовок передан, сессия не проверяется. Для сессии token равен nil.
+func authenticate(r *http.Request) (*models.User, *models.APIToken, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		plain, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return nil, nil, errInvalidToken
+		}
+		user, token, err := storage.TokenStoreInstance.ResolveToken(strings.TrimSpace(plain))
+		if err != nil {
+			if err != storage.ErrTokenInvalid {
+				log.Printf("Failed to resolve API token: %v", err)
+			}
+			return nil, nil, errInvalidToken
+		}
+		return user, token, nil
+	}
+
+	user, ok := sessionUser(r)
+	if !ok {
+		return nil, nil, nil
+	}
+	return user, nil, nil
+}
+
+// withUser возвращает запрос с пользователем и токеном в контексте
+func withUser(r *http.Request, user *models.User, token *models.APIToken) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	if token != nil {
+		ctx = context.WithValue(ctx, tokenContextKey, token)
+	}
+	return r.WithContext(ctx)
+}
+
+// sessionUser возвращает пользователя cookie-сессии. Права перечитываются из БД на каждый
+// запрос, чтобы смена роли, состава групп или блокировка действовали без повторного входа.
+func sessionUser(r *http.Request) (*models.User, bool) {
+	session, _ := store.Get(r, sessionCookieName)
+	if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
+		return nil, false
+	}
+
+	userID, _ := session.Values["userID"].(int)
+	user, err := storage.UserStoreInstance.GetUserByID(userID)
+	if err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			log.Printf("Failed to load session user: %v", err)
+		}
+		return nil, false
+	}
+	if user.Disabled {
+		return nil, false
+	}
+	return user, true
+}
+
+// currentUser возвращает пользователя, которого middleware положил в контекст
+func currentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userContextKey).(*models.User)
+	return user
+}
+
+// currentToken возвращает API-токен, которым авторизован запрос, или nil для сессии
+func currentToken(r *http.Request) *models.APIToken {
+	token, _ := r.Context().Value(tokenContextKey).(*models.APIToken)
+	return token
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"file-exchange-app/oidc"
+	"file-exchange-app/storage"
+	"log"
+	"net/http"
+)
+
+// Ключи сессии, в которых хранится незавершенный вход через OpenID Connect
+const (
+	oidcStateKey    = "oidcState"
+	oidcNonceKey    = "oidcNonce"
+	oidcVerifierKey = "oidcVerifier"
+)
+
+// OIDCLoginHandler отправляет пользователя на страницу входа провайдера
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	signIn := storage.OIDCSignInInstance
+	if signIn == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	values := make([]string, 3)
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := signIn.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("OIDC sign-in error: %v", err)
+		renderLoginPage(w, r, http.StatusServiceUnavailable, "Single sign-on is unavailable, try again later")
+		return
+	}
+
+	session, _ := store.Get(r, sessionCookieName)
+	session.Values[oidcStateKey] = state
+	session.Values[oidcNonceKey] = nonce
+	session.Values[oidcVerifierKey] = verifier
+	session.Save(r, w)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler принимает пользователя, вернувшегося от провайдера с кодом авторизации
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	signIn := storage.OIDCSignInInstance
+	if signIn == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Параметры входа одноразовые: удаляем их до любых проверок
+	session, _ := store.Get(r, sessionCookieName)
+	state, _ := session.Values[oidcStateKey].(string)
+	nonce, _ := session.Values[oidcNonceKey].(string)
+	verifier, _ := session.Values[oidcVerifierKey].(string)
+	delete(session.Values, oidcStateKey)
+	delete(session.Values, oidcNonceKey)
+	delete(session.Values, oidcVerifierKey)
+	session.Save(r, w)
+
+	query := r.URL.Query()
+	if state == "" || query.Get("state") != state {
+		renderLoginPage(w, r, http.StatusBadRequest, "Sign-in session expired, please try again")
+		return
+	}
+	if query.Get("error") != "" {
+		renderLoginPage(w, r, http.StatusUnauthorized, "Single sign-on failed: "+query.Get("error"))
+		return
+	}
+
+	user, err := signIn.Finish(r.Context(), query.Get("code"), verifier, nonce)
+	switch {
+	case errors.Is(err, storage.ErrUserDisabled):
+		renderLoginPage(w, r, http.StatusForbidden, "Account is disabled")
+		return
+	case errors.Is(err, storage.ErrNotPermitted):
+		renderLoginPage(w, r, http.StatusForbidden, "Account is not permitted to sign in")
+		return
+	case err != nil:
+		log.Printf("OIDC sign-in error: %v", err)
+		renderLoginPage(w, r, http.StatusBadGateway, "Single sign-on failed")
+		return
+	}
+
+	// Вторым фактором для пользователей единого входа тоже служит TOTP, если он включен
+	if user.TOTPEnabled {
+		startTwoFactorLogin(w, r, user)
+		return
+	}
+	recordLoginAttempt(r, user.Username, true, "single sign-on")
+	startSession(w, r, user)
+}
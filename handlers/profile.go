@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"file-exchange-app/totp"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// profilePath страница профиля; пока учетная запись не настроена, пускаем только на нее
+const profilePath = "/profile"
+
+// Причины, по которым пользователя не пускают дальше страницы профиля
+var (
+	errPasswordChangeRequired = errors.New("password change required")
+	errTwoFactorRequired      = errors.New("two-factor authentication must be enabled")
+)
+
+// profileRequest сообщает, относится ли запрос к странице профиля и ее формам
+func profileRequest(r *http.Request) bool {
+	return r.URL.Path == profilePath || strings.HasPrefix(r.URL.Path, profilePath+"/")
+}
+
+// accountSetupError возвращает errPasswordChangeRequired или errTwoFactorRequired, если
+// пользователь должен сначала сменить пароль или включить 2FA (когда она обязательна для администраторов)
+func accountSetupError(user *models.User) error {
+	if user.MustChangePassword {
+		return errPasswordChangeRequired
+	}
+	if user.IsAdmin && !user.TOTPEnabled {
+		required, err := storage.SettingsStoreInstance.GetBool(storage.SettingRequireAdmin2FA)
+		if err != nil {
+			return err
+		}
+		if required {
+			return errTwoFactorRequired
+		}
+	}
+	return nil
+}
+
+// ProfileHandler отображает профиль пользователя и форму смены пароля
+func ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	renderProfilePage(w, r, "", "")
+}
+
+// ChangePasswordHandler меняет пароль текущего пользователя после проверки текущего
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if currentToken(r) != nil {
+		http.Error(w, "Password cannot be changed with an API token", http.StatusForbidden)
+		return
+	}
+
+	user := currentUser(r)
+	if user.AuthSource != models.AuthSourceLocal {
+		renderProfilePage(w, r, "", errExternalAccount.Error())
+		return
+	}
+
+	r.ParseForm()
+	current := r.FormValue("current_password")
+	password := r.FormValue("new_password")
+
+	if password == "" {
+		renderProfilePage(w, r, "", "New password is required")
+		return
+	}
+	if password != r.FormValue("confirm_password") {
+		renderProfilePage(w, r, "", "Passwords do not match")
+		return
+	}
+	if password == current {
+		renderProfilePage(w, r, "", "New password must differ from the current one")
+		return
+	}
+	if _, err := storage.UserStoreInstance.VerifyUserCredentials(user.Username, current); err != nil {
+		renderProfilePage(w, r, "", "Current password is incorrect")
+		return
+	}
+
+	if err := storage.UserStoreInstance.SetPassword(user.ID, password, false); err != nil {
+		if errors.Is(err, storage.ErrWeakPassword) {
+			renderProfilePage(w, r, "", err.Error())
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionChangePass, "")
+
+	// Остальные сессии были открыты со старым паролем: завершаем их, текущую оставляем
+	if _, err := storage.SessionStoreInstance.RevokeUserSessions(user.ID, currentSessionID(r)); err != nil {
+		log.Printf("Failed to revoke sessions of %s: %v", user.Username, err)
+	}
+
+	// Флаг в пользователе из контекста уже неактуален
+	user.MustChangePassword = false
+	renderProfilePage(w, r, "Password changed.", "")
+}
+
+// twoFactorView состояние 2FA на странице профиля
+type twoFactorView struct {
+	Enabled       bool
+	Required      bool
+	Secret        string // секрет для ручного ввода, пока 2FA не включена
+	URI           string // otpauth:// адрес для QR-кода
+	RecoveryLeft  int
+	RecoveryCodes []string // новые коды восстановления, показываются один раз
+}
+
+// renderProfilePage выводит страницу профиля
+func renderProfilePage(w http.ResponseWriter, r *http.Request, message, errorMessage string) {
+	renderProfilePageWithCodes(w, r, message, errorMessage, nil)
+}
+
+// renderProfilePageWithCodes выводит страницу профиля с только что выпущенными кодами восстановления
+func renderProfilePageWithCodes(w http.ResponseWriter, r *http.Request, message, errorMessage string, codes []string) {
+	user := currentUser(r)
+
+	twoFactor := twoFactorView{Enabled: user.TOTPEnabled, RecoveryCodes: codes}
+	twoFactor.Required = errors.Is(accountSetupError(user), errTwoFactorRequired)
+	if user.TOTPEnabled {
+		left, err := storage.TwoFactorStoreInstance.RemainingRecoveryCodes(user.ID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		twoFactor.RecoveryLeft = left
+	} else {
+		secret, err := pendingTOTPSecret(w, r)
+		if err != nil {
+			http.Error(w, "Error generating secret", http.StatusInternalServerError)
+			return
+		}
+		twoFactor.Secret = secret
+		twoFactor.URI = totp.ProvisioningURI(totpIssuer, user.Username, secret)
+	}
+
+	data := struct {
+		Username  string
+		IsAdmin   bool
+		User      *models.User
+		TwoFactor twoFactorView
+		Message   string
+		Error     string
+		CSRFToken string
+	}{
+		Username:  user.Username,
+		IsAdmin:   user.IsAdmin,
+		User:      user,
+		TwoFactor: twoFactor,
+		Message:   message,
+		Error:     errorMessage,
+		CSRFToken: csrfToken(w, r),
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/profile.html"))
+	tmpl.Execute(w, data)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Ошибки управления ролями и группами
+var (
+	errNameRequired = errors.New("name is required")
+	errSelfDemote   = errors.New("you cannot remove your own admin role")
+)
+
+// adminErrorStatus переводит ошибки управления пользователями, ролями и группами
+// в HTTP-статус и сообщение
+func adminErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, errNameRequired), errors.Is(err, errSelfDemote):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, storage.ErrRoleNotFound), errors.Is(err, storage.ErrGroupNotFound), errors.Is(err, storage.ErrUserNotFound):
+		return http.StatusNotFound, err.Error()
+	case errors.Is(err, storage.ErrRoleExists), errors.Is(err, storage.ErrGroupExists), errors.Is(err, storage.ErrUserExists),
+		errors.Is(err, storage.ErrRoleBuiltin), errors.Is(err, storage.ErrRoleInUse):
+		return http.StatusConflict, err.Error()
+	}
+	return http.StatusInternalServerError, "database error"
+}
+
+// createRole создает роль и пишет запись в журнал
+func createRole(admin *models.User, name string, canUpload, canDownload, isAdmin bool) (*models.Role, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errNameRequired
+	}
+	role, err := storage.RoleStoreInstance.CreateRole(name, canUpload, canDownload, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionCreateRole, describeRole(role))
+	return role, nil
+}
+
+// updateRole меняет права роли и пишет запись в журнал
+func updateRole(admin *models.User, id int, canUpload, canDownload, isAdmin bool) (*models.Role, error) {
+	if err := storage.RoleStoreInstance.UpdateRole(id, canUpload, canDownload, isAdmin); err != nil {
+		return nil, err
+	}
+	role, err := storage.RoleStoreInstance.GetRoleByID(id)
+	if err != nil {
+		return nil, err
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionUpdateRole, describeRole(role))
+	return role, nil
+}
+
+// deleteRole удаляет роль и пишет запись в журнал
+func deleteRole(admin *models.User, id int) error {
+	role, err := storage.RoleStoreInstance.GetRoleByID(id)
+	if err != nil {
+		return err
+	}
+	if err := storage.RoleStoreInstance.DeleteRole(id); err != nil {
+		return err
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionDeleteRole, "Role: "+role.Name)
+	return nil
+}
+
+// setUserRole назначает пользователю роль по имени. Администратор не может снять
+// права администратора с самого себя, чтобы не остаться без доступа к админке.
+func setUserRole(admin *models.User, userID int, roleName string) (*models.User, error) {
+	role, err := storage.RoleStoreInstance.GetRoleByName(roleName)
+	if err != nil {
+		return nil, err
+	}
+	if userID == admin.ID && !role.IsAdmin {
+		return nil, errSelfDemote
+	}
+	if err := storage.UserStoreInstance.SetUserRole(userID, role.ID); err != nil {
+		return nil, err
+	}
+
+	user, err := storage.UserStoreInstance.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionUpdateUser,
+		fmt.Sprintf("User: %s role: %s", user.Username, role.Name))
+	return user, nil
+}
+
+// describeRole описание роли для журнала
+func describeRole(role *models.Role) string {
+	return fmt.Sprintf("Role: %s (upload: %t, download: %t, admin: %t)",
+		role.Name, role.CanUpload, role.CanDownload, role.IsAdmin)
+}
+
+// rolePermissionsFromForm читает флажки прав роли из формы
+func rolePermissionsFromForm(r *http.Request) (canUpload, canDownload, isAdmin bool) {
+	return r.FormValue("can_upload") != "", r.FormValue("can_download") != "", r.FormValue("is_admin") != ""
+}
+
+// adminFormError отвечает на ошибку формы админки текстом ошибки
+func adminFormError(w http.ResponseWriter, err error) {
+	status, message := adminErrorStatus(err)
+	http.Error(w, message, status)
+}
+
+// CreateRoleHandler создает роль из формы админки
+func CreateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	canUpload, canDownload, isAdmin := rolePermissionsFromForm(r)
+	if _, err := createRole(currentUser(r), r.FormValue("name"), canUpload, canDownload, isAdmin); err != nil {
+		adminFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// UpdateRoleHandler меняет права роли из формы админки
+func UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	r.ParseForm()
+	canUpload, canDownload, isAdmin := rolePermissionsFromForm(r)
+	if _, err := updateRole(currentUser(r), id, canUpload, canDownload, isAdmin); err != nil {
+		adminFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// DeleteRoleHandler удаляет роль
+func DeleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := deleteRole(currentUser(r), id); err != nil {
+		adminFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// SetUserRoleHandler назначает пользователю роль из формы админки
+func SetUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	r.ParseForm()
+	if _, err := setUserRole(currentUser(r), id, r.FormValue("role")); err != nil {
+		adminFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// roleRequest тело запроса на создание или изменение роли
+type roleRequest struct {
+	Name        string `json:"name"`
+	CanUpload   bool   `json:"can_upload"`
+	CanDownload bool   `json:"can_download"`
+	IsAdmin     bool   `json:"is_admin"`
+}
+
+// writeAdminError отвечает JSON-ошибкой для API управления пользователями, ролями и группами
+func writeAdminError(w http.ResponseWriter, err error) {
+	status, message := adminErrorStatus(err)
+	writeJSONError(w, status, message)
+}
+
+// APIListRolesHandler возвращает все роли
+func APIListRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := storage.RoleStoreInstance.ListRoles()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	if roles == nil {
+		roles = []models.Role{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"roles": roles})
+}
+
+// APICreateRoleHandler создает роль с набором прав
+func APICreateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var req roleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	role, err := createRole(currentUser(r), req.Name, req.CanUpload, req.CanDownload, req.IsAdmin)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, role)
+}
+
+// APIUpdateRoleHandler меняет права роли; имя в теле запроса игнорируется
+func APIUpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	var req roleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	role, err := updateRole(currentUser(r), id, req.CanUpload, req.CanDownload, req.IsAdmin)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, role)
+}
+
+// APIDeleteRoleHandler удаляет роль
+func APIDeleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := deleteRole(currentUser(r), id); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
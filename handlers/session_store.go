@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"file-exchange-app/storage"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// sessionCookieName имя cookie с идентификатором сессии
+const sessionCookieName = "session-name"
+
+// dbSessionStore хранилище gorilla-сессий в БД. В cookie лежит только случайный
+// идентификатор, поэтому сессию можно отозвать на сервере, а подделать значения нельзя.
+type dbSessionStore struct {
+	options sessions.Options
+}
+
+var store = &dbSessionStore{
+	options: sessions.Options{Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode},
+}
+
+// SetSecureCookies помечает cookie сессии как Secure. main вызывает ее до запуска
+// HTTPS-сервера: такую cookie браузер не должен отправлять по открытому HTTP.
+func SetSecureCookies(secure bool) {
+	store.options.Secure = secure
+}
+
+// Get возвращает сессию запроса; в пределах одного запроса это всегда один и тот же объект
+func (s *dbSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New загружает сессию по cookie или создает пустую, если сессии нет или она истекла
+func (s *dbSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := s.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return session, nil
+	}
+	record, err := storage.SessionStoreInstance.GetSession(cookie.Value)
+	if errors.Is(err, storage.ErrSessionNotFound) {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(record.Data)).Decode(&session.Values); err != nil {
+		return session, err
+	}
+	session.ID = cookie.Value
+	session.IsNew = false
+	return session, nil
+}
+
+// Save сохраняет значения сессии. Отрицательный MaxAge удаляет сессию вместе с cookie.
+func (s *dbSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := storage.SessionStoreInstance.DeleteSession(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+	// Владелец записывается только у авторизованной сессии: по нему строятся список
+	// сессий и выход на всех устройствах
+	userID := 0
+	if auth, _ := session.Values["authenticated"].(bool); auth {
+		userID, _ = session.Values["userID"].(int)
+	}
+
+	if session.ID != "" {
+		err := storage.SessionStoreInstance.UpdateSession(session.ID, userID, data.Bytes())
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			// Сессию отозвали, пока шел запрос: не создаем ее заново
+			session.Options.MaxAge = -1
+			http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+			return nil
+		}
+		return err
+	}
+
+	token, err := storage.SessionStoreInstance.CreateSession(userID, data.Bytes(), r.UserAgent(), clientIP(r))
+	if err != nil {
+		return err
+	}
+	session.ID = token
+	// Cookie живет не дольше самой сессии; idle-таймаут проверяется на сервере
+	session.Options.MaxAge = int(storage.SessionStoreInstance.AbsoluteTimeout().Seconds())
+	http.SetCookie(w, sessions.NewCookie(session.Name(), token, session.Options))
+	return nil
+}
+
+// RenewID удаляет сессию из БД, чтобы следующий Save выдал новый идентификатор.
+// Вызывается при входе: идентификатор, известный до входа, не должен стать авторизованным.
+func (s *dbSessionStore) RenewID(session *sessions.Session) error {
+	if session.ID == "" {
+		return nil
+	}
+	if err := storage.SessionStoreInstance.DeleteSession(session.ID); err != nil {
+		return err
+	}
+	session.ID = ""
+	return nil
+}
+
+// clientIP возвращает адрес клиента без порта
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
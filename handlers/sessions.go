@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// currentSessionID возвращает идентификатор cookie-сессии запроса или пустую строку
+func currentSessionID(r *http.Request) string {
+	session, _ := store.Get(r, sessionCookieName)
+	return session.ID
+}
+
+// SessionsHandler отображает активные сессии текущего пользователя
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	renderSessionsPage(w, r, "")
+}
+
+// RevokeSessionHandler завершает одну из сессий текущего пользователя
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if currentToken(r) != nil {
+		http.Error(w, "Sessions cannot be managed with an API token", http.StatusForbidden)
+		return
+	}
+
+	user := currentUser(r)
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := storage.SessionStoreInstance.RevokeSession(user.ID, id); err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionRevokeSession, fmt.Sprintf("Session ID: %d", id))
+
+	http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+}
+
+// RevokeOtherSessionsHandler завершает все сессии текущего пользователя, кроме этой
+func RevokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if currentToken(r) != nil {
+		http.Error(w, "Sessions cannot be managed with an API token", http.StatusForbidden)
+		return
+	}
+
+	user := currentUser(r)
+	revoked, err := storage.SessionStoreInstance.RevokeUserSessions(user.ID, currentSessionID(r))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionRevokeSession, fmt.Sprintf("Other sessions: %d", revoked))
+
+	renderSessionsPage(w, r, fmt.Sprintf("Signed out of %d other session(s).", revoked))
+}
+
+// renderSessionsPage выводит список активных сессий пользователя
+func renderSessionsPage(w http.ResponseWriter, r *http.Request, message string) {
+	user := currentUser(r)
+	sessions, err := storage.SessionStoreInstance.ListUserSessions(user.ID, currentSessionID(r))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Username  string
+		IsAdmin   bool
+		Sessions  []models.Session
+		Message   string
+		CSRFToken string
+	}{
+		Username:  user.Username,
+		IsAdmin:   user.IsAdmin,
+		Sessions:  sessions,
+		Message:   message,
+		CSRFToken: csrfToken(w, r),
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/sessions.html"))
+	tmpl.Execute(w, data)
+}
@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// errNoDownloadPermission возвращается, если пользователь без права скачивания пытается поделиться файлом
+var errNoDownloadPermission = errors.New("you don't have permission to share files")
+
+// shareURL строит публичный адрес ссылки по адресу текущего запроса
+func shareURL(r *http.Request, plain string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/s/" + plain
+}
+
+// shareLogName имя, от которого в журнал пишутся обращения по ссылке
+func shareLogName(share *models.Share) string {
+	return fmt.Sprintf("share:%d", share.ID)
+}
+
+// createShare создает ссылку на файл и пишет запись в журнал
+func createShare(user *models.User, filename, password string, expiresAt *time.Time, maxDownloads int) (string, *models.Share, error) {
+	// Ссылка отдает файл без входа, поэтому делиться может только тот, кто сам может скачать
+	if !user.CanDownload {
+		return "", nil, errNoDownloadPermission
+	}
+	if err := requireChange(user); err != nil {
+		return "", nil, err
+	}
+
+	file, err := readableFile(user, filename)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := requireAccess(user, filename, models.PermissionManage); err != nil {
+		return "", nil, err
+	}
+
+	plain, share, err := storage.ShareStoreInstance.CreateShare(file.ID, user.ID, password, expiresAt, maxDownloads)
+	if err != nil {
+		return "", nil, err
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionCreateShare, fmt.Sprintf("Share ID: %d for %s", share.ID, file.Name))
+	return plain, share, nil
+}
+
+// revokeShare отзывает ссылку; администратор может отозвать любую
+func revokeShare(user *models.User, id int) error {
+	if err := requireChange(user); err != nil {
+		return err
+	}
+	ownerID := user.ID
+	if user.IsAdmin {
+		ownerID = 0
+	}
+	if err := storage.ShareStoreInstance.RevokeShare(id, ownerID); err != nil {
+		return err
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionRevokeShare, fmt.Sprintf("Share ID: %d", id))
+	return nil
+}
+
+// listShares возвращает ссылки пользователя; администратор видит все
+func listShares(user *models.User) ([]models.Share, error) {
+	if user.IsAdmin {
+		return storage.ShareStoreInstance.ListShares(0)
+	}
+	return storage.ShareStoreInstance.ListShares(user.ID)
+}
+
+// SharesHandler отображает страницу публичных ссылок; ?file= подставляет файл в форму
+func SharesHandler(w http.ResponseWriter, r *http.Request) {
+	renderSharesPage(w, r, "", "")
+}
+
+// CreateShareHandler создает ссылку из формы на странице ссылок
+func CreateShareHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	filename := r.FormValue("file")
+	if filename == "" {
+		renderSharesPage(w, r, "", "File is required")
+		return
+	}
+
+	// Дата истечения необязательна; ссылка действует до конца указанного дня (UTC)
+	var expiresAt *time.Time
+	if value := r.FormValue("expires"); value != "" {
+		day, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			renderSharesPage(w, r, "", "Invalid expiry date")
+			return
+		}
+		end := day.Add(24 * time.Hour)
+		expiresAt = &end
+	}
+
+	maxDownloads := 0
+	if value := r.FormValue("max_downloads"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			renderSharesPage(w, r, "", "Invalid download limit")
+			return
+		}
+		maxDownloads = limit
+	}
+
+	plain, _, err := createShare(currentUser(r), filename, r.FormValue("password"), expiresAt, maxDownloads)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNoDownloadPermission), errors.Is(err, errAccessDenied), errors.Is(err, errTokenScope):
+			http.Error(w, "You don't have permission to share this file", http.StatusForbidden)
+		case errors.Is(err, storage.ErrFileNotFound):
+			renderSharesPage(w, r, "", "File not found")
+		default:
+			http.Error(w, "Error creating share", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	renderSharesPage(w, r, shareURL(r, plain), "")
+}
+
+// RevokeShareHandler отзывает ссылку
+func RevokeShareHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := revokeShare(currentUser(r), id); err != nil {
+		if errors.Is(err, storage.ErrShareNotFound) {
+			http.Error(w, "Share not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errTokenScope) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/shares", http.StatusSeeOther)
+}
+
+// renderSharesPage выводит список ссылок; newLink показывается один раз сразу после создания
+func renderSharesPage(w http.ResponseWriter, r *http.Request, newLink, errorMessage string) {
+	user := currentUser(r)
+	shares, err := listShares(user)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Username  string
+		IsAdmin   bool
+		File      string
+		Shares    []models.Share
+		NewLink   string
+		Error     string
+		Now       time.Time
+		CSRFToken string
+	}{
+		Username:  user.Username,
+		IsAdmin:   user.IsAdmin,
+		File:      r.FormValue("file"),
+		Shares:    shares,
+		NewLink:   newLink,
+		Error:     errorMessage,
+		Now:       time.Now().UTC(),
+		CSRFToken: csrfToken(w, r),
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/shares.html"))
+	tmpl.Execute(w, data)
+}
+
+// publicShare находит ссылку по токену из адреса и проверяет, что по ней еще можно скачать.
+// При ошибке ответ уже отправлен.
+func publicShare(w http.ResponseWriter, r *http.Request) (*models.Share, bool) {
+	share, err := storage.ShareStoreInstance.GetShareByToken(mux.Vars(r)["token"])
+	if err != nil {
+		if errors.Is(err, storage.ErrShareNotFound) {
+			renderSharePage(w, http.StatusNotFound, nil, "This link does not exist.")
+			return nil, false
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !share.Active(time.Now().UTC()) {
+		renderSharePage(w, http.StatusGone, nil, "This link has expired or was revoked.")
+		return nil, false
+	}
+	return share, true
+}
+
+// PublicShareHandler отображает страницу ссылки без входа в систему
+func PublicShareHandler(w http.ResponseWriter, r *http.Request) {
+	share, ok := publicShare(w, r)
+	if !ok {
+		return
+	}
+	renderSharePage(w, http.StatusOK, share, "")
+}
+
+// PublicShareDownloadHandler отдает файл по ссылке. Форма страницы ссылки отправляет
+// POST с полем password, ссылку без пароля можно скачать и GET на /s/{token}/download.
+// Скачиванием считается ответ, который записывается в журнал и при обычном скачивании
+// (см. countsAsDownload): HEAD, 304 и докачка с середины файла лимит не расходуют.
+func PublicShareDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	share, ok := publicShare(w, r)
+	if !ok {
+		return
+	}
+
+	if share.HasPassword && !checkSharePassword(w, r, share) {
+		return
+	}
+
+	file, err := storage.FileStoreInstance.GetFileByName(share.FileName)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			renderSharePage(w, http.StatusNotFound, nil, "This file no longer exists.")
+			return
+		}
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+
+	// Место в лимите занимается до отправки файла, чтобы параллельные запросы не превысили
+	// его, и возвращается, если ответ скачиванием не стал
+	reserved := share.MaxDownloads > 0 && mayCountAsDownload(r)
+	if reserved {
+		if err := storage.ShareStoreInstance.RecordDownload(share.ID); err != nil {
+			if errors.Is(err, storage.ErrShareInactive) {
+				renderSharePage(w, http.StatusGone, nil, "This link has expired or was revoked.")
+				return
+			}
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	status := serveFile(w, r, file)
+	counted := countsAsDownload(r, status)
+	switch {
+	case reserved && !counted:
+		if err := storage.ShareStoreInstance.ReleaseDownload(share.ID); err != nil {
+			log.Printf("Failed to release share download: %v", err)
+		}
+	case !reserved && counted:
+		// Ссылка без лимита могла истечь, пока файл отдавался; такое скачивание не учитываем
+		if err := storage.ShareStoreInstance.RecordDownload(share.ID); err != nil && !errors.Is(err, storage.ErrShareInactive) {
+			log.Printf("Failed to record share download: %v", err)
+		}
+	}
+	if !counted {
+		return
+	}
+
+	if err := storage.LogStoreInstance.AddLog(shareLogName(share), models.ActionShareDownload,
+		fmt.Sprintf("%s from %s", file.Name, r.RemoteAddr)); err != nil {
+		log.Printf("Failed to log share download: %v", err)
+	}
+}
+
+// checkSharePassword проверяет пароль ссылки из формы. Подбор ограничивается так же, как
+// подбор пароля при входе: по ссылке и по адресу клиента. При ошибке ответ уже отправлен.
+func checkSharePassword(w http.ResponseWriter, r *http.Request, share *models.Share) bool {
+	wait, err := storage.LoginThrottleInstance.AttemptShare(share.ID, clientIP(r), time.Now())
+	if errors.Is(err, storage.ErrLoginThrottled) || errors.Is(err, storage.ErrAccountLocked) {
+		seconds := int((wait + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		renderSharePage(w, http.StatusTooManyRequests, share,
+			fmt.Sprintf("Too many wrong passwords, try again in %s.", time.Duration(seconds)*time.Second))
+		return false
+	}
+	if err != nil {
+		log.Printf("Share throttle error for share %d: %v", share.ID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+
+	if !storage.CheckSharePassword(share, r.PostFormValue("password")) {
+		storage.LogStoreInstance.AddLog(shareLogName(share), models.ActionShareDenied,
+			fmt.Sprintf("%s: wrong password from %s", share.FileName, r.RemoteAddr))
+		renderSharePage(w, http.StatusForbidden, share, "Wrong password.")
+		return false
+	}
+	if err := storage.LoginThrottleInstance.ShareSucceeded(share.ID, clientIP(r)); err != nil {
+		log.Printf("Failed to reset password failures of share %d: %v", share.ID, err)
+	}
+	return true
+}
+
+// renderSharePage выводит публичную страницу ссылки; без share показывается только сообщение
+func renderSharePage(w http.ResponseWriter, status int, share *models.Share, message string) {
+	data := struct {
+		Share    *models.Share
+		FileName string
+		Message  string
+	}{
+		Share:   share,
+		Message: message,
+	}
+	if share != nil {
+		data.FileName = storage.BaseName(share.FileName)
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/share.html"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
+}
+
+// shareRequest тело запроса на создание ссылки через API
+type shareRequest struct {
+	File         string     `json:"file"`
+	Password     string     `json:"password"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	MaxDownloads int        `json:"max_downloads"`
+}
+
+// APIListSharesHandler возвращает ссылки пользователя; администратору - все
+func APIListSharesHandler(w http.ResponseWriter, r *http.Request) {
+	shares, err := listShares(currentUser(r))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if shares == nil {
+		shares = []models.Share{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"shares": shares})
+}
+
+// APICreateShareHandler создает ссылку; ее адрес возвращается только в этом ответе
+func APICreateShareHandler(w http.ResponseWriter, r *http.Request) {
+	var req shareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.File == "" || req.MaxDownloads < 0 {
+		writeJSONError(w, http.StatusBadRequest, "file is required and max_downloads must not be negative")
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		writeJSONError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	plain, share, err := createShare(currentUser(r), req.File, req.Password, req.ExpiresAt, req.MaxDownloads)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNoDownloadPermission), errors.Is(err, errAccessDenied), errors.Is(err, errTokenScope):
+			writeJSONError(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, storage.ErrFileNotFound):
+			writeJSONError(w, http.StatusNotFound, "file not found")
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "error creating share")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, struct {
+		*models.Share
+		URL string `json:"url"`
+	}{share, shareURL(r, plain)})
+}
+
+// APIRevokeShareHandler отзывает ссылку
+func APIRevokeShareHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := revokeShare(currentUser(r), id); err != nil {
+		if errors.Is(err, storage.ErrShareNotFound) {
+			writeJSONError(w, http.StatusNotFound, "share not found")
+			return
+		}
+		if errors.Is(err, errTokenScope) {
+			writeJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TokensHandler отображает страницу управления персональными API-токенами
+func TokensHandler(w http.ResponseWriter, r *http.Request) {
+	renderTokensPage(w, r, "", "")
+}
+
+// CreateTokenHandler выпускает новый токен из формы на странице токенов
+func CreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if currentToken(r) != nil {
+		http.Error(w, "Tokens cannot be managed with an API token", http.StatusForbidden)
+		return
+	}
+
+	r.ParseForm()
+	name := r.FormValue("name")
+	scope := r.FormValue("scope")
+
+	if name == "" || !models.ValidTokenScope(scope) {
+		renderTokensPage(w, r, "", "Name and a valid scope are required")
+		return
+	}
+
+	// Дата истечения необязательна; токен действует до конца указанного дня (UTC)
+	var expiresAt *time.Time
+	if value := r.FormValue("expires"); value != "" {
+		day, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			renderTokensPage(w, r, "", "Invalid expiry date")
+			return
+		}
+		end := day.Add(24 * time.Hour)
+		expiresAt = &end
+	}
+
+	user := currentUser(r)
+	plain, _, err := storage.TokenStoreInstance.CreateToken(user.ID, name, scope, expiresAt)
+	if err != nil {
+		http.Error(w, "Error creating token", http.StatusInternalServerError)
+		return
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionCreateToken, fmt.Sprintf("Token: %s (%s)", name, scope))
+
+	renderTokensPage(w, r, plain, "")
+}
+
+// RevokeTokenHandler отзывает токен текущего пользователя
+func RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if currentToken(r) != nil {
+		http.Error(w, "Tokens cannot be managed with an API token", http.StatusForbidden)
+		return
+	}
+
+	user := currentUser(r)
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := storage.TokenStoreInstance.RevokeToken(user.ID, id); err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionRevokeToken, fmt.Sprintf("Token ID: %d", id))
+
+	http.Redirect(w, r, "/tokens", http.StatusSeeOther)
+}
+
+// renderTokensPage выводит список токенов; newToken показывается один раз сразу после создания
+func renderTokensPage(w http.ResponseWriter, r *http.Request, newToken, errorMessage string) {
+	user := currentUser(r)
+	tokens, err := storage.TokenStoreInstance.ListTokens(user.ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Username  string
+		IsAdmin   bool
+		Tokens    []models.APIToken
+		NewToken  string
+		Error     string
+		CSRFToken string
+	}{
+		Username:  user.Username,
+		IsAdmin:   user.IsAdmin,
+		Tokens:    tokens,
+		NewToken:  newToken,
+		Error:     errorMessage,
+		CSRFToken: csrfToken(w, r),
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/tokens.html"))
+	tmpl.Execute(w, data)
+}
+
+// tokenRequest тело запроса на создание токена через API
+type tokenRequest struct {
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIListTokensHandler возвращает токены текущего пользователя
+func APIListTokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := storage.TokenStoreInstance.ListTokens(currentUser(r).ID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if tokens == nil {
+		tokens = []models.APIToken{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tokens": tokens})
+}
+
+// APICreateTokenHandler выпускает токен; его значение возвращается только в этом ответе
+func APICreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if currentToken(r) != nil {
+		writeJSONError(w, http.StatusForbidden, "tokens cannot be managed with an API token")
+		return
+	}
+
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = models.ScopeFull
+	}
+	if req.Name == "" || !models.ValidTokenScope(req.Scope) {
+		writeJSONError(w, http.StatusBadRequest, "name and a valid scope (full, upload, download) are required")
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		writeJSONError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	user := currentUser(r)
+	plain, token, err := storage.TokenStoreInstance.CreateToken(user.ID, req.Name, req.Scope, req.ExpiresAt)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "error creating token")
+		return
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionCreateToken, fmt.Sprintf("Token: %s (%s)", req.Name, req.Scope))
+
+	writeJSON(w, http.StatusCreated, struct {
+		*models.APIToken
+		Token string `json:"token"`
+	}{token, plain})
+}
+
+// APIRevokeTokenHandler отзывает токен текущего пользователя
+func APIRevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if currentToken(r) != nil {
+		writeJSONError(w, http.StatusForbidden, "tokens cannot be managed with an API token")
+		return
+	}
+
+	user := currentUser(r)
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := storage.TokenStoreInstance.RevokeToken(user.ID, id); err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			writeJSONError(w, http.StatusNotFound, "token not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionRevokeToken, fmt.Sprintf("Token ID: %d", id))
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Параметры протокола tus 1.0 (https://tus.io/protocols/resumable-upload)
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusExpiry     = 24 * time.Hour
+)
+
+// tusUpload описывает незавершенную загрузку. Хранится рядом с данными в файле <id>.info,
+// текущий offset равен размеру файла с данными.
+type tusUpload struct {
+	ID         string `json:"id"`
+	Length     int64  `json:"length"`
+	Filename   string `json:"filename"`
+	OnConflict string `json:"on_conflict,omitempty"`
+	UserID     int    `json:"user_id"`
+	Username   string `json:"username"`
+	// TokenScope область действия токена, которым создана загрузка (пустая для браузера)
+	TokenScope string    `json:"token_scope,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// tusLock блокировка одной загрузки; refs - сколько запросов держат ее или ждут
+type tusLock struct {
+	sync.Mutex
+	refs int
+}
+
+// tusLocks не дает двум PATCH-запросам одновременно писать в одну загрузку. Запись
+// удаляется из карты, когда ее отпускает последний запрос, поэтому ждущие запросы
+// всегда получают ту же блокировку, что и держащий ее.
+var tusLocks = struct {
+	sync.Mutex
+	m map[string]*tusLock
+}{m: make(map[string]*tusLock)}
+
+// lockTusUpload захватывает блокировку загрузки и возвращает функцию для ее освобождения
+func lockTusUpload(id string) func() {
+	tusLocks.Lock()
+	lock, ok := tusLocks.m[id]
+	if !ok {
+		lock = &tusLock{}
+		tusLocks.m[id] = lock
+	}
+	lock.refs++
+	tusLocks.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		tusLocks.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(tusLocks.m, id)
+		}
+		tusLocks.Unlock()
+	}
+}
+
+func (u *Uploads) tusDataPath(id string) string { return filepath.Join(u.tusDir, id) }
+func (u *Uploads) tusInfoPath(id string) string { return filepath.Join(u.tusDir, id+".info") }
+
+// expiresAt возвращает момент, после которого незавершенная загрузка будет удалена
+func (u *tusUpload) expiresAt() time.Time {
+	return u.CreatedAt.Add(tusExpiry)
+}
+
+// loadTusUpload читает описание загрузки и текущий offset
+func (u *Uploads) loadTusUpload(id string) (*tusUpload, int64, error) {
+	data, err := os.ReadFile(u.tusInfoPath(id))
+	if err != nil {
+		return nil, 0, err
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, 0, err
+	}
+	info, err := os.Stat(u.tusDataPath(id))
+	if err != nil {
+		return nil, 0, err
+	}
+	return &upload, info.Size(), nil
+}
+
+// removeTusUpload удаляет данные и описание загрузки
+func (u *Uploads) removeTusUpload(id string) {
+	os.Remove(u.tusDataPath(id))
+	os.Remove(u.tusInfoPath(id))
+}
+
+// RegisterTusRoutes регистрирует эндпоинты tus на переданном роутере
+func (u *Uploads) RegisterTusRoutes(r *mux.Router) {
+	r.Use(u.tusResumableMiddleware, APIAuthMiddleware)
+
+	r.HandleFunc("", u.TusOptionsHandler).Methods("OPTIONS")
+	r.HandleFunc("", u.TusCreateHandler).Methods("POST")
+	r.HandleFunc("/{id:[0-9a-f]+}", u.TusOptionsHandler).Methods("OPTIONS")
+	r.HandleFunc("/{id:[0-9a-f]+}", u.TusHeadHandler).Methods("HEAD")
+	r.HandleFunc("/{id:[0-9a-f]+}", u.TusPatchHandler).Methods("PATCH")
+	r.HandleFunc("/{id:[0-9a-f]+}", u.TusDeleteHandler).Methods("DELETE")
+}
+
+// tusResumableMiddleware добавляет Tus-Resumable в ответы и проверяет версию протокола клиента.
+// Запросы OPTIONS по протоколу обрабатываются без проверки версии и без авторизации.
+func (u *Uploads) tusResumableMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Method == http.MethodOptions {
+			u.TusOptionsHandler(w, r)
+			return
+		}
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TusOptionsHandler сообщает клиенту о возможностях сервера
+func (u *Uploads) TusOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(int64(u.maxSize), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TusCreateHandler создает новую загрузку (расширение creation)
+func (u *Uploads) TusCreateHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		http.Error(w, "You don't have permission to upload files", http.StatusForbidden)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > int64(u.maxSize) {
+		http.Error(w, "Upload exceeds Tus-Max-Size", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if metadata["filename"] == "" {
+		http.Error(w, "Upload-Metadata must contain filename", http.StatusBadRequest)
+		return
+	}
+	// filename может быть путем внутри загружаемой папки ("photos/a.jpg").
+	// Необязательные ключи: folder - папка назначения, on_conflict - что делать с существующим файлом
+	policy, err := parseConflictPolicy(metadata["on_conflict"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filename, err := uploadTarget(user, metadata["folder"], metadata["filename"], policy)
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		http.Error(w, "Error creating upload", http.StatusInternalServerError)
+		return
+	}
+
+	upload := tusUpload{
+		ID:         hex.EncodeToString(raw),
+		Length:     length,
+		Filename:   filename,
+		OnConflict: policy,
+		UserID:     user.ID,
+		Username:   user.Username,
+		TokenScope: user.TokenScope,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	if err := u.createTusUpload(&upload); err != nil {
+		log.Printf("Failed to create tus upload: %v", err)
+		http.Error(w, "Error creating upload", http.StatusInternalServerError)
+		return
+	}
+
+	// Пустой файл можно завершить сразу, PATCH для него не придет
+	if length == 0 {
+		if err := u.finishTusUpload(r.Context(), &upload); err != nil {
+			u.writeUploadError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+upload.ID)
+	w.Header().Set("Upload-Expires", upload.expiresAt().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// createTusUpload создает пустой файл данных и описание загрузки
+func (u *Uploads) createTusUpload(upload *tusUpload) error {
+	if err := os.MkdirAll(u.tusDir, 0755); err != nil {
+		return err
+	}
+	data, err := os.Create(u.tusDataPath(upload.ID))
+	if err != nil {
+		return err
+	}
+	data.Close()
+
+	info, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(u.tusInfoPath(upload.ID), info, 0644)
+}
+
+// TusHeadHandler возвращает текущий offset для продолжения загрузки
+func (u *Uploads) TusHeadHandler(w http.ResponseWriter, r *http.Request) {
+	upload, offset, ok := u.ownedTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.expiresAt().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// TusPatchHandler дописывает очередной кусок данных начиная с Upload-Offset
+func (u *Uploads) TusPatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	unlock := lockTusUpload(id)
+	defer unlock()
+
+	upload, offset, ok := u.ownedTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	clientOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	if clientOffset != offset {
+		http.Error(w, "Upload-Offset does not match current offset", http.StatusConflict)
+		return
+	}
+
+	data, err := os.OpenFile(u.tusDataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		http.Error(w, "Error opening upload", http.StatusInternalServerError)
+		return
+	}
+
+	// Не даем записать больше, чем было заявлено в Upload-Length. Если соединение
+	// оборвется, все уже записанные байты останутся и клиент продолжит с нового offset.
+	written, copyErr := io.Copy(data, io.LimitReader(r.Body, upload.Length-offset))
+	closeErr := data.Close()
+	offset += written
+
+	if copyErr != nil || closeErr != nil {
+		log.Printf("tus upload %s interrupted at offset %d: %v", id, offset, copyErr)
+		http.Error(w, "Error writing upload", http.StatusInternalServerError)
+		return
+	}
+
+	if offset == upload.Length {
+		if err := u.finishTusUpload(r.Context(), upload); err != nil {
+			log.Printf("Failed to finish tus upload %s: %v", id, err)
+			u.writeUploadError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Expires", upload.expiresAt().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TusDeleteHandler прерывает загрузку и удаляет ее данные (расширение termination)
+func (u *Uploads) TusDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	unlock := lockTusUpload(id)
+	defer unlock()
+
+	if _, _, ok := u.ownedTusUpload(w, r); !ok {
+		return
+	}
+
+	u.removeTusUpload(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownedTusUpload загружает загрузку из URL и проверяет, что она принадлежит текущему пользователю.
+// При ошибке сам пишет ответ и возвращает ok = false.
+func (u *Uploads) ownedTusUpload(w http.ResponseWriter, r *http.Request) (*tusUpload, int64, bool) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	upload, offset, err := u.loadTusUpload(mux.Vars(r)["id"])
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Error reading upload", http.StatusInternalServerError)
+		}
+		return nil, 0, false
+	}
+
+	// Чужие загрузки не раскрываем, отвечаем как на несуществующие
+	if upload.UserID != currentUser(r).ID {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, 0, false
+	}
+
+	if time.Now().After(upload.expiresAt()) {
+		u.removeTusUpload(upload.ID)
+		http.Error(w, "Upload expired", http.StatusGone)
+		return nil, 0, false
+	}
+
+	return upload, offset, true
+}
+
+// finishTusUpload переносит полностью загруженный файл в хранилище и пишет запись в журнал.
+// С создания загрузки могло пройти до суток, поэтому права проверяются заново: пользователя
+// могли заблокировать, лишить права загрузки или доступа к папке. Отказ окончательный,
+// и данные загрузки удаляются.
+func (u *Uploads) finishTusUpload(ctx context.Context, upload *tusUpload) error {
+	// Загрузки, созданные до появления on_conflict, перезаписывают файл, как раньше
+	policy, _ := parseConflictPolicy(upload.OnConflict)
+	if err := checkTusUploader(upload, policy); err != nil {
+		if errors.Is(err, errAccessDenied) || errors.Is(err, errTokenScope) || errors.Is(err, storage.ErrFileExists) {
+			u.removeTusUpload(upload.ID)
+		}
+		return err
+	}
+
+	data, err := os.Open(u.tusDataPath(upload.ID))
+	if err != nil {
+		return err
+	}
+	stored, err := storeFile(ctx, upload.UserID, upload.Filename, data, upload.Length, policy)
+	data.Close()
+	if err != nil {
+		return err
+	}
+
+	u.removeTusUpload(upload.ID)
+
+	if err := storage.LogStoreInstance.AddLog(upload.Username, models.ActionUpload, stored.Name); err != nil {
+		log.Printf("Failed to log upload action: %v", err)
+	}
+	return nil
+}
+
+// checkTusUploader перечитывает владельца загрузки и проверяет, что он все еще может
+// сохранить файл под upload.Filename
+func checkTusUploader(upload *tusUpload, policy string) error {
+	user, err := storage.UserStoreInstance.GetUserByID(upload.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return errAccessDenied
+		}
+		return err
+	}
+	// Загрузку, начатую токеном, завершаем с правами этого токена, а не владельца
+	models.RestrictToScope(user, upload.TokenScope)
+	if user.Disabled || !user.CanUpload {
+		return errAccessDenied
+	}
+	return checkUploadTarget(user, upload.Filename, policy)
+}
+
+// parseTusMetadata разбирает заголовок Upload-Metadata: "key base64value,key2 base64value2"
+func parseTusMetadata(header string) map[string]string {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		meta[key] = string(value)
+	}
+	return meta
+}
+
+// CleanupExpiredUploads удаляет незавершенные загрузки, срок которых истек
+func (u *Uploads) CleanupExpiredUploads() {
+	entries, err := os.ReadDir(u.tusDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading tus directory: %v", err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok {
+			continue
+		}
+		unlock := lockTusUpload(id)
+		upload, _, err := u.loadTusUpload(id)
+		if err != nil || time.Now().After(upload.expiresAt()) {
+			u.removeTusUpload(id)
+		}
+		unlock()
+	}
+}
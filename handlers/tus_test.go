@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+// tusLockRefs возвращает число запросов, держащих или ждущих блокировку загрузки
+func tusLockRefs(id string) int {
+	tusLocks.Lock()
+	defer tusLocks.Unlock()
+	if lock, ok := tusLocks.m[id]; ok {
+		return lock.refs
+	}
+	return 0
+}
+
+func TestTusLockWaiters(t *testing.T) {
+	unlock := lockTusUpload("a")
+
+	acquired := make(chan func())
+	for i := 0; i < 2; i++ {
+		go func() { acquired <- lockTusUpload("a") }()
+	}
+	for deadline := time.Now().Add(time.Second); tusLockRefs("a") != 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("waiters did not queue up, refs = %d", tusLockRefs("a"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held")
+	default:
+	}
+
+	// Каждый следующий запрос получает ту же блокировку, а не новую из карты
+	unlock()
+	next := <-acquired
+	select {
+	case <-acquired:
+		t.Fatal("two requests hold the lock at once")
+	case <-time.After(10 * time.Millisecond):
+	}
+	next()
+	(<-acquired)()
+
+	if refs := tusLockRefs("a"); refs != 0 {
+		t.Errorf("lock kept after the last holder released it, refs = %d", refs)
+	}
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"file-exchange-app/totp"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// totpIssuer название приложения в аутентификаторе
+const totpIssuer = "File Exchange"
+
+// Ключи сессии для входа с 2FA и для еще не подтвержденного секрета
+const (
+	pendingUserKey  = "pendingUserID"
+	pendingAtKey    = "pendingAt"
+	pendingTOTPKey  = "totpPending"
+	pendingLoginTTL = 5 * time.Minute
+)
+
+// errInvalidTwoFactorCode возвращается, если не подошел ни TOTP-код, ни код восстановления
+var errInvalidTwoFactorCode = errors.New("invalid two-factor code")
+
+// startTwoFactorLogin запоминает в сессии пользователя, прошедшего проверку пароля,
+// и отправляет его на второй шаг входа
+func startTwoFactorLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	session, _ := store.Get(r, sessionCookieName)
+	session.Values["authenticated"] = false
+	session.Values[pendingUserKey] = user.ID
+	session.Values[pendingAtKey] = time.Now().Unix()
+	session.Save(r, w)
+	http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+}
+
+// pendingLoginUser возвращает пользователя, ожидающего второго шага входа
+func pendingLoginUser(r *http.Request) (*models.User, bool) {
+	session, _ := store.Get(r, sessionCookieName)
+	userID, ok := session.Values[pendingUserKey].(int)
+	if !ok {
+		return nil, false
+	}
+	startedAt, _ := session.Values[pendingAtKey].(int64)
+	if time.Since(time.Unix(startedAt, 0)) > pendingLoginTTL {
+		return nil, false
+	}
+
+	user, err := storage.UserStoreInstance.GetUserByID(userID)
+	if err != nil || user.Disabled || !user.TOTPEnabled {
+		return nil, false
+	}
+	return user, true
+}
+
+// checkSecondFactor принимает TOTP-код или одноразовый код восстановления.
+// recovery сообщает, что был погашен код восстановления.
+func checkSecondFactor(user *models.User, code string) (recovery bool, err error) {
+	ok, err := storage.TwoFactorStoreInstance.VerifyCode(user.ID, code, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return false, nil
+	}
+
+	ok, err = storage.TwoFactorStoreInstance.UseRecoveryCode(user.ID, code)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, errInvalidTwoFactorCode
+	}
+	return true, nil
+}
+
+// TwoFactorLoginHandler второй шаг входа: код из аутентификатора или код восстановления
+func TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := pendingLoginUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == "POST" {
+		// Коды второго шага подбираются так же, как пароли, и учитываются в тех же счетчиках
+		if wait, err := storage.LoginThrottleInstance.Attempt(user.Username, clientIP(r), time.Now()); err != nil {
+			if !loginThrottled(w, wait, err) {
+				http.Error(w, "Database error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		r.ParseForm()
+		recovery, err := checkSecondFactor(user, r.FormValue("code"))
+		if err == nil {
+			method := "two-factor code"
+			if recovery {
+				method = "recovery code"
+			}
+			loginSucceeded(w, r, user, method)
+			return
+		}
+		if !errors.Is(err, errInvalidTwoFactorCode) {
+			storage.LoginThrottleInstance.Cancel(user.Username, clientIP(r))
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		recordLoginAttempt(r, user.Username, false, "invalid two-factor code")
+		renderTwoFactorLoginPage(w, r, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	renderTwoFactorLoginPage(w, r, http.StatusOK, "")
+}
+
+// renderTwoFactorLoginPage выводит форму второго шага входа
+func renderTwoFactorLoginPage(w http.ResponseWriter, r *http.Request, status int, errorMessage string) {
+	data := struct {
+		Error     string
+		CSRFToken string
+	}{errorMessage, csrfToken(w, r)}
+
+	tmpl := template.Must(template.ParseFiles("templates/login_2fa.html"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
+}
+
+// pendingTOTPSecret возвращает секрет, который пользователь добавляет в аутентификатор.
+// До подтверждения кодом секрет хранится только в сессии.
+func pendingTOTPSecret(w http.ResponseWriter, r *http.Request) (string, error) {
+	session, _ := store.Get(r, sessionCookieName)
+	if secret, ok := session.Values[pendingTOTPKey].(string); ok && secret != "" {
+		return secret, nil
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	session.Values[pendingTOTPKey] = secret
+	if err := session.Save(r, w); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// EnableTwoFactorHandler включает 2FA после проверки кода для секрета из сессии
+func EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if currentToken(r) != nil {
+		http.Error(w, "Two-factor authentication cannot be managed with an API token", http.StatusForbidden)
+		return
+	}
+
+	user := currentUser(r)
+	if user.TOTPEnabled {
+		http.Redirect(w, r, profilePath, http.StatusSeeOther)
+		return
+	}
+
+	session, _ := store.Get(r, sessionCookieName)
+	secret, _ := session.Values[pendingTOTPKey].(string)
+	r.ParseForm()
+	if _, ok := totp.Match(secret, r.FormValue("code"), time.Now(), 0); secret == "" || !ok {
+		renderProfilePage(w, r, "", "Invalid code, check the time on your device and try again")
+		return
+	}
+
+	codes, err := storage.TwoFactorStoreInstance.Enable(user.ID, secret)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	// Погашаем код, которым подтвердили секрет, чтобы им нельзя было войти
+	storage.TwoFactorStoreInstance.VerifyCode(user.ID, r.FormValue("code"), time.Now())
+
+	delete(session.Values, pendingTOTPKey)
+	session.Save(r, w)
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionEnable2FA, "")
+
+	user.TOTPEnabled = true
+	renderProfilePageWithCodes(w, r, "Two-factor authentication enabled.", "", codes)
+}
+
+// DisableTwoFactorHandler выключает 2FA; нужны пароль (если он есть) и код
+func DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if currentToken(r) != nil {
+		http.Error(w, "Two-factor authentication cannot be managed with an API token", http.StatusForbidden)
+		return
+	}
+
+	user := currentUser(r)
+	r.ParseForm()
+	// У пользователей единого входа пароля нет, им достаточно кода
+	if user.HasPassword() {
+		if _, err := storage.AuthenticatorInstance.Authenticate(user.Username, r.FormValue("password")); err != nil {
+			renderProfilePage(w, r, "", "Current password is incorrect")
+			return
+		}
+	}
+	if _, err := checkSecondFactor(user, r.FormValue("code")); err != nil {
+		if errors.Is(err, errInvalidTwoFactorCode) {
+			renderProfilePage(w, r, "", "Invalid code")
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := storage.TwoFactorStoreInstance.Disable(user.ID); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	storage.LogStoreInstance.AddLog(user.Username, models.ActionDisable2FA, "")
+
+	user.TOTPEnabled = false
+	renderProfilePage(w, r, "Two-factor authentication disabled.", "")
+}
+
+// RegenerateRecoveryCodesHandler выпускает новые коды восстановления взамен старых
+func RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if currentToken(r) != nil {
+		http.Error(w, "Two-factor authentication cannot be managed with an API token", http.StatusForbidden)
+		return
+	}
+
+	user := currentUser(r)
+	r.ParseForm()
+	if _, err := checkSecondFactor(user, r.FormValue("code")); err != nil {
+		if errors.Is(err, errInvalidTwoFactorCode) {
+			renderProfilePage(w, r, "", "Invalid code")
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := storage.TwoFactorStoreInstance.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	renderProfilePageWithCodes(w, r, "New recovery codes generated.", "", codes)
+}
+
+// ResetTwoFactorHandler выключает 2FA пользователя, потерявшего устройство
+func ResetTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	user, err := adminUser(id)
+	if err != nil {
+		userFormError(w, err)
+		return
+	}
+	if err := storage.TwoFactorStoreInstance.Disable(id); err != nil {
+		userFormError(w, err)
+		return
+	}
+	storage.LogStoreInstance.AddLog(currentUser(r).Username, models.ActionDisable2FA, "User: "+user.Username)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// UpdateSettingsHandler сохраняет настройки из админки
+func UpdateSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	requireAdmin2FA := r.FormValue(storage.SettingRequireAdmin2FA) != ""
+	if err := storage.SettingsStoreInstance.SetBool(storage.SettingRequireAdmin2FA, requireAdmin2FA); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	storage.LogStoreInstance.AddLog(currentUser(r).Username, models.ActionSettings,
+		fmt.Sprintf("%s: %t", storage.SettingRequireAdmin2FA, requireAdmin2FA))
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
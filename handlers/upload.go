@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"file-exchange-app/config"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+)
+
+const (
+	// uploadFormOverhead запас сверх размера файла на заголовки частей и текстовые поля формы
+	uploadFormOverhead = 1 << 20
+	// uploadFieldMaxLength предельная длина текстового поля формы загрузки
+	uploadFieldMaxLength = 4096
+)
+
+// Политики на случай, когда файл с таким именем уже есть. Задаются полем формы
+// on_conflict (или ключом on_conflict в метаданных tus).
+const (
+	conflictOverwrite = "overwrite" // добавить существующему файлу новую версию (по умолчанию)
+	conflictRename    = "rename"    // сохранить под свободным именем "name (1).ext"
+	conflictReject    = "reject"    // отказать с кодом 409
+)
+
+// maxRenameAttempts сколько номеров перебирать при conflictRename
+const maxRenameAttempts = 1000
+
+// Ошибки разбора формы загрузки
+var (
+	errUploadTooLarge  = errors.New("upload is too large")
+	errMissingFile     = errors.New(`multipart field "file" is required`)
+	errMalformedUpload = errors.New("malformed upload form")
+	errInvalidConflict = errors.New(`on_conflict must be "overwrite", "rename" or "reject"`)
+)
+
+// Uploads обработчики загрузки файлов: HTML-форма, JSON API и tus. Ограничения и папку
+// незавершенных загрузок они берут из конфигурации, переданной в NewUploads.
+type Uploads struct {
+	maxSize  config.Size
+	maxFiles int
+	tusDir   string // папка для недокачанных tus-загрузок
+}
+
+// NewUploads создает обработчики загрузки с параметрами из cfg
+func NewUploads(cfg *config.Config) *Uploads {
+	return &Uploads{
+		maxSize:  cfg.Uploads.MaxSize,
+		maxFiles: cfg.Uploads.MaxFiles,
+		// Недокачанные файлы лежат внутри папки локального хранилища
+		tusDir: filepath.Join(cfg.Storage.LocalDir, ".tus"),
+	}
+}
+
+// parseConflictPolicy проверяет значение on_conflict; пустое значение означает conflictOverwrite
+func parseConflictPolicy(value string) (string, error) {
+	switch value {
+	case "":
+		return conflictOverwrite, nil
+	case conflictOverwrite, conflictRename, conflictReject:
+		return value, nil
+	}
+	return "", errInvalidConflict
+}
+
+// createRenamedFile создает файл, а если имя занято - перебирает "name (1).ext", "name (2).ext"...
+func createRenamedFile(file *models.File) error {
+	name := file.Name
+	for n := 1; ; n++ {
+		err := storage.FileStoreInstance.CreateFile(file)
+		if !errors.Is(err, storage.ErrFileExists) && !errors.Is(err, storage.ErrPathConflict) {
+			return err
+		}
+		if n > maxRenameAttempts {
+			return storage.ErrFileExists
+		}
+		file.Name = storage.NumberedName(name, n)
+	}
+}
+
+// uploadResult итог загрузки одного файла формы
+type uploadResult struct {
+	Name   string       `json:"name"`   // имя или относительный путь из формы
+	Status int          `json:"status"` // код ответа, как если бы файл загружался отдельно
+	Error  string       `json:"error,omitempty"`
+	File   *models.File `json:"file,omitempty"`
+
+	err error
+}
+
+// pendingUpload файл формы, содержимое которого уже в хранилище, но еще не записано в БД
+type pendingUpload struct {
+	filename  string
+	key       string
+	inspector *storage.ContentInspector
+	err       error // файл отклонен до приема содержимого
+}
+
+// streamUpload читает multipart-форму по частям и пишет файлы из полей "file" прямо в
+// хранилище за один проход: без буферизации формы, с подсчетом SHA-256 по пути. Форма целиком
+// ограничена uploads.max_size, число файлов - uploads.max_files. Имя файла может быть
+// относительным путем ("photos/2024/a.jpg" при загрузке папки): недостающие папки создаются.
+// Поля "folder" и "on_conflict" могут стоять и до, и после файлов; если они пришли раньше,
+// имена и права проверяются еще до приема содержимого.
+//
+// Ошибка означает, что форму нельзя принять целиком, и тогда не сохраняется ни один файл.
+// Отказы по отдельным файлам (имя, права, конфликт) попадают в их uploadResult.
+func (u *Uploads) streamUpload(w http.ResponseWriter, r *http.Request, user *models.User) ([]uploadResult, error) {
+	maxSize := int64(u.maxSize)
+	if r.ContentLength > maxSize+uploadFormOverhead {
+		return nil, errUploadTooLarge
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+uploadFormOverhead)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errMalformedUpload, err)
+	}
+
+	ctx := r.Context()
+	fields := make(map[string]string)
+	var pending []*pendingUpload
+	defer func() {
+		// Содержимое, которое так и не попало в БД: форма оказалась неверной или файл отклонен
+		var keys []string
+		for _, p := range pending {
+			if p.key != "" {
+				keys = append(keys, p.key)
+			}
+		}
+		removeBlobs(context.Background(), keys)
+	}()
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, uploadReadError(err)
+		}
+
+		if part.FormName() != "file" {
+			value, err := io.ReadAll(io.LimitReader(part, uploadFieldMaxLength+1))
+			if err != nil {
+				return nil, uploadReadError(err)
+			}
+			if len(value) > uploadFieldMaxLength {
+				return nil, fmt.Errorf("%w: field %q is too long", errMalformedUpload, part.FormName())
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		filename := partFileName(part)
+		if filename == "" {
+			// Так браузер отправляет поле, в котором ничего не выбрано
+			continue
+		}
+		if len(pending) >= u.maxFiles {
+			return nil, fmt.Errorf("%w: at most %d files per request", errMalformedUpload, u.maxFiles)
+		}
+		upload := &pendingUpload{filename: filename}
+		pending = append(pending, upload)
+		if folder, ok := fields["folder"]; ok {
+			policy, err := parseConflictPolicy(fields["on_conflict"])
+			if err != nil {
+				return nil, err
+			}
+			if _, err := uploadTarget(user, folder, upload.filename, policy); err != nil {
+				// Содержимое этого файла пропускаем, остальные файлы формы принимаем
+				upload.err = err
+				continue
+			}
+		}
+		// Лимит на файл - остаток лимита формы, так что превышение видно сразу
+		content := &sizeLimitReader{r: part, remaining: maxSize}
+		upload.key, upload.inspector, err = putContent(ctx, content, -1)
+		maxSize = content.remaining
+		if content.err != nil {
+			return nil, uploadReadError(content.err)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(pending) == 0 {
+		return nil, errMissingFile
+	}
+
+	policy, err := parseConflictPolicy(fields["on_conflict"])
+	if err != nil {
+		return nil, err
+	}
+	results := make([]uploadResult, len(pending))
+	for i, upload := range pending {
+		results[i] = u.saveUpload(ctx, user, fields["folder"], policy, upload)
+	}
+	return results, nil
+}
+
+// saveUpload записывает в БД один файл формы. Если файл сохранить не удалось, его
+// содержимое остается в pending и удаляется вместе с формой.
+func (u *Uploads) saveUpload(ctx context.Context, user *models.User, folder, policy string, upload *pendingUpload) uploadResult {
+	result := uploadResult{Name: upload.filename, err: upload.err}
+	if result.err == nil {
+		var name string
+		if name, result.err = uploadTarget(user, folder, upload.filename, policy); result.err == nil {
+			result.File, result.err = saveContent(ctx, user.ID, name, upload.key, upload.inspector, policy)
+		}
+	}
+	if result.err != nil {
+		result.Status, result.Error = u.uploadErrorStatus(result.err)
+		return result
+	}
+	upload.key = ""
+	result.Status = http.StatusCreated
+	result.File.OwnerName = user.Username
+	return result
+}
+
+// partFileName возвращает имя файла части формы вместе с относительным путем. Part.FileName
+// отбрасывает каталоги, а при загрузке папки браузер передает путь внутри нее.
+func partFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// uploadsStatus общий код ответа на форму: 201, если сохранены все файлы; код отказа, если
+// все файлы отклонены по одной причине; иначе 207 Multi-Status
+func uploadsStatus(results []uploadResult) int {
+	status := results[0].Status
+	for _, result := range results[1:] {
+		if result.Status != status {
+			return http.StatusMultiStatus
+		}
+	}
+	return status
+}
+
+// logUploads пишет в журнал сохраненные файлы формы
+func logUploads(user *models.User, results []uploadResult) {
+	for _, result := range results {
+		if result.File == nil {
+			continue
+		}
+		if err := storage.LogStoreInstance.AddLog(user.Username, models.ActionUpload, result.File.Name); err != nil {
+			log.Printf("Failed to log upload action: %v", err)
+		}
+	}
+}
+
+// uploadTarget нормализует имя загружаемого файла и проверяет права (см. checkUploadTarget).
+// Имя может содержать относительный путь внутри folder.
+func uploadTarget(user *models.User, folder, filename, policy string) (string, error) {
+	folder, err := storage.CleanPath(folder)
+	if err != nil {
+		return "", err
+	}
+	relative, err := storage.NormalizePath(filename)
+	if err != nil {
+		return "", err
+	}
+	if relative == "" {
+		return "", fmt.Errorf("%w: name is empty", storage.ErrInvalidPath)
+	}
+	name := storage.JoinPath(folder, relative)
+	if len(name) > storage.MaxPathLength {
+		return "", fmt.Errorf("%w: path is longer than %d bytes", storage.ErrInvalidPath, storage.MaxPathLength)
+	}
+	if err := checkUploadTarget(user, name, policy); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// checkUploadTarget проверяет права на загрузку в name: для conflictOverwrite - на запись
+// в существующий файл, иначе - на создание файла в папке. При conflictReject занятое имя
+// отклоняется сразу, чтобы не принимать содержимое зря; окончательно это проверяет
+// CreateFile при сохранении.
+func checkUploadTarget(user *models.User, name, policy string) error {
+	var err error
+	if policy == conflictOverwrite {
+		err = requireUpload(user, name)
+	} else {
+		err = requireWriteInto(user, storage.ParentPath(name))
+	}
+	if err != nil {
+		return err
+	}
+	if policy == conflictReject {
+		if _, err := storage.FileStoreInstance.GetFileByName(name); err == nil {
+			return storage.ErrFileExists
+		} else if !errors.Is(err, storage.ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadReadError переводит ошибку чтения тела запроса в ошибку клиента:
+// превышение лимита или оборванную либо испорченную форму
+func uploadReadError(err error) error {
+	var maxBytes *http.MaxBytesError
+	if errors.Is(err, errUploadTooLarge) || errors.As(err, &maxBytes) {
+		return errUploadTooLarge
+	}
+	return fmt.Errorf("%w: %v", errMalformedUpload, err)
+}
+
+// sizeLimitReader возвращает errUploadTooLarge, как только прочитано больше remaining байт.
+// Ошибку чтения он запоминает, чтобы отличить проблемы запроса от сбоя хранилища.
+type sizeLimitReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		err = errUploadTooLarge
+	}
+	if err != nil && err != io.EOF {
+		l.err = err
+	}
+	return n, err
+}
+
+// uploadErrorStatus подбирает код ответа для ошибки загрузки
+func (u *Uploads) uploadErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, errUploadTooLarge):
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("upload exceeds the limit of %s", u.maxSize)
+	case errors.Is(err, errMissingFile), errors.Is(err, errMalformedUpload), errors.Is(err, errInvalidConflict):
+		return http.StatusBadRequest, err.Error()
+	}
+	status, message := pathErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("Upload failed: %v", err)
+		message = "error saving file"
+	}
+	return status, message
+}
+
+// writeUploadError отвечает текстовой ошибкой для HTML-формы
+func (u *Uploads) writeUploadError(w http.ResponseWriter, err error) {
+	status, message := u.uploadErrorStatus(err)
+	http.Error(w, message, status)
+}
+
+// writeAPIUploadError отвечает JSON-ошибкой для API
+func (u *Uploads) writeAPIUploadError(w http.ResponseWriter, err error) {
+	status, message := u.uploadErrorStatus(err)
+	writeJSONError(w, status, message)
+}
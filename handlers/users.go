@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Ошибки управления учетными записями
+var (
+	errPasswordRequired = errors.New("password is required")
+	errSelfLockout      = errors.New("you cannot disable or delete yourself")
+	errInvalidReassign  = errors.New("files must be reassigned to another existing user")
+	errExternalAccount  = errors.New("the password of this account is managed by an external provider")
+)
+
+// adminUser возвращает пользователя, которым управляет администратор
+func adminUser(id int) (*models.User, error) {
+	return storage.UserStoreInstance.GetUserByID(id)
+}
+
+// updateUserPermissions задает пользователю собственные флаги прав вместо роли
+func updateUserPermissions(admin *models.User, id int, canUpload, canDownload, isAdmin bool) (*models.User, error) {
+	if id == admin.ID && !isAdmin {
+		return nil, errSelfDemote
+	}
+	if err := storage.UserStoreInstance.UpdateUserPermissions(id, canUpload, canDownload, isAdmin); err != nil {
+		return nil, err
+	}
+
+	user, err := adminUser(id)
+	if err != nil {
+		return nil, err
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionUpdateUser,
+		fmt.Sprintf("User: %s (upload: %t, download: %t, admin: %t)", user.Username, canUpload, canDownload, isAdmin))
+	return user, nil
+}
+
+// resetPassword задает пользователю новый пароль, который тот должен сменить при входе
+func resetPassword(admin *models.User, id int, password string) error {
+	if password == "" {
+		return errPasswordRequired
+	}
+	user, err := adminUser(id)
+	if err != nil {
+		return err
+	}
+	if user.AuthSource != models.AuthSourceLocal {
+		return errExternalAccount
+	}
+	if err := storage.UserStoreInstance.SetPassword(id, password, true); err != nil {
+		return err
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionResetPass, "User: "+user.Username)
+
+	// Старый пароль мог быть скомпрометирован, поэтому его сессии больше не действуют
+	if _, err := storage.SessionStoreInstance.RevokeUserSessions(id, ""); err != nil {
+		return err
+	}
+	return nil
+}
+
+// revokeUserSessions завершает все сессии пользователя ("выйти на всех устройствах")
+func revokeUserSessions(admin *models.User, id int) (int, error) {
+	user, err := adminUser(id)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := storage.SessionStoreInstance.RevokeUserSessions(id, "")
+	if err != nil {
+		return 0, err
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionRevokeSession,
+		fmt.Sprintf("User: %s, sessions: %d", user.Username, revoked))
+	return revoked, nil
+}
+
+// setUserDisabled блокирует или разблокирует пользователя. Заблокировать себя нельзя.
+func setUserDisabled(admin *models.User, id int, disabled bool) (*models.User, error) {
+	if id == admin.ID && disabled {
+		return nil, errSelfLockout
+	}
+	if err := storage.UserStoreInstance.SetDisabled(id, disabled); err != nil {
+		return nil, err
+	}
+	if disabled {
+		// Заблокированного пользователя и так не пустят, но его сессии больше не нужны
+		if _, err := storage.SessionStoreInstance.RevokeUserSessions(id, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := adminUser(id)
+	if err != nil {
+		return nil, err
+	}
+	action := models.ActionEnableUser
+	if disabled {
+		action = models.ActionDisableUser
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, action, "User: "+user.Username)
+	return user, nil
+}
+
+// unlockUser снимает блокировку входа, наложенную после неудачных попыток
+func unlockUser(admin *models.User, id int) error {
+	user, err := adminUser(id)
+	if err != nil {
+		return err
+	}
+	if err := storage.LoginThrottleInstance.Unlock(user.Username); err != nil {
+		return err
+	}
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionUnlockUser, "User: "+user.Username)
+	return nil
+}
+
+// deleteUser удаляет пользователя. Его файлы и папки переходят к пользователю reassignTo;
+// при reassignTo == 0 файлы удаляются вместе с содержимым.
+func deleteUser(ctx context.Context, admin *models.User, id, reassignTo int) error {
+	if id == admin.ID {
+		return errSelfLockout
+	}
+	if reassignTo == id {
+		return errInvalidReassign
+	}
+	user, err := adminUser(id)
+	if err != nil {
+		return err
+	}
+
+	details := "User: " + user.Username + ", files removed"
+	if reassignTo != 0 {
+		heir, err := adminUser(reassignTo)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				return errInvalidReassign
+			}
+			return err
+		}
+		details = "User: " + user.Username + ", files reassigned to " + heir.Username
+	}
+
+	keys, err := storage.UserStoreInstance.DeleteUser(id, reassignTo)
+	if err != nil {
+		return err
+	}
+	removeBlobs(ctx, keys)
+	storage.LogStoreInstance.AddLog(admin.Username, models.ActionDeleteUser, details)
+	return nil
+}
+
+// userErrorStatus переводит ошибки управления учетными записями в HTTP-статус и сообщение
+func userErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, errPasswordRequired), errors.Is(err, errSelfLockout), errors.Is(err, errInvalidReassign),
+		errors.Is(err, errExternalAccount), errors.Is(err, storage.ErrWeakPassword):
+		return http.StatusBadRequest, err.Error()
+	}
+	return adminErrorStatus(err)
+}
+
+// userFormError отвечает на ошибку формы управления пользователем текстом ошибки
+func userFormError(w http.ResponseWriter, err error) {
+	status, message := userErrorStatus(err)
+	http.Error(w, message, status)
+}
+
+// UpdateUserPermissionsHandler задает пользователю собственные права из формы админки
+func UpdateUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	r.ParseForm()
+	canUpload, canDownload, isAdmin := rolePermissionsFromForm(r)
+	if _, err := updateUserPermissions(currentUser(r), id, canUpload, canDownload, isAdmin); err != nil {
+		userFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// ResetPasswordHandler задает пользователю новый пароль из формы админки
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	r.ParseForm()
+	if err := resetPassword(currentUser(r), id, r.FormValue("password")); err != nil {
+		userFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// RevokeUserSessionsHandler завершает все сессии пользователя из админки
+func RevokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if _, err := revokeUserSessions(currentUser(r), id); err != nil {
+		userFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// DisableUserHandler блокирует пользователя
+func DisableUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if _, err := setUserDisabled(currentUser(r), id, true); err != nil {
+		userFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// EnableUserHandler разблокирует пользователя
+func EnableUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if _, err := setUserDisabled(currentUser(r), id, false); err != nil {
+		userFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// UnlockUserHandler снимает с пользователя блокировку входа
+func UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := unlockUser(currentUser(r), id); err != nil {
+		userFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// DeleteUserHandler удаляет пользователя. Поле reassign_to - ID нового владельца файлов,
+// пустое значение удаляет файлы пользователя.
+func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	r.ParseForm()
+	reassignTo := 0
+	if value := r.FormValue("reassign_to"); value != "" {
+		var err error
+		if reassignTo, err = strconv.Atoi(value); err != nil {
+			userFormError(w, errInvalidReassign)
+			return
+		}
+	}
+
+	if err := deleteUser(r.Context(), currentUser(r), id, reassignTo); err != nil {
+		userFormError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"file-exchange-app/models"
+	"file-exchange-app/storage"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// errInvalidVersion возвращается, если параметр version не является положительным числом
+var errInvalidVersion = errors.New("invalid version")
+
+// fileAtVersion возвращает файл с содержимым версии из параметра ?version=N.
+// Без параметра возвращается текущая версия.
+func fileAtVersion(r *http.Request, file *models.File) (*models.File, error) {
+	value := r.URL.Query().Get("version")
+	if value == "" {
+		return file, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		return nil, errInvalidVersion
+	}
+
+	version, err := storage.FileStoreInstance.GetVersion(file.ID, number)
+	if err != nil {
+		return nil, err
+	}
+
+	result := *file
+	result.StoredKey = version.StoredKey
+	result.Size = version.Size
+	result.SHA256 = version.SHA256
+	result.MimeType = version.MimeType
+	result.Version = version.Version
+	result.UpdatedAt = version.CreatedAt
+	return &result, nil
+}
+
+// downloadLogName описание скачанного файла для журнала: для старых версий с номером версии
+func downloadLogName(requested, current *models.File) string {
+	if requested.Version == current.Version {
+		return requested.Name
+	}
+	return fmt.Sprintf("%s (version %d)", requested.Name, requested.Version)
+}
+
+// HistoryHandler отображает историю версий файла
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	filename := mux.Vars(r)["filename"]
+
+	file, err := readableFile(user, filename)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	versions, err := storage.FileStoreInstance.ListVersions(file.ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Username    string
+		IsAdmin     bool
+		CanUpload   bool
+		CanDownload bool
+		File        *models.File
+		Versions    []models.FileVersion
+		CSRFToken   string
+	}{
+		Username:    user.Username,
+		IsAdmin:     user.IsAdmin,
+		CanUpload:   user.CanUpload,
+		CanDownload: user.CanDownload,
+		File:        file,
+		Versions:    versions,
+		CSRFToken:   csrfToken(w, r),
+	}
+
+	tmpl := template.Must(template.New("history.html").
+		Funcs(template.FuncMap{"escapePath": escapePath}).
+		ParseFiles("templates/history.html"))
+	tmpl.Execute(w, data)
+}
+
+// RestoreVersionHandler делает выбранную версию текущей
+func RestoreVersionHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		http.Error(w, "You don't have permission to restore versions", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	version, _ := strconv.Atoi(vars["version"])
+	if _, err := restoreVersion(r.Context(), user, vars["filename"], version); err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) || errors.Is(err, storage.ErrVersionNotFound) {
+			http.Error(w, "Version not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errAccessDenied) || errors.Is(err, errTokenScope) {
+			http.Error(w, "You don't have permission to restore versions of this file", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/history/"+escapePath(vars["filename"]), http.StatusSeeOther)
+}
+
+// restoreVersion восстанавливает версию, удаляет вытесненное лимитом содержимое и пишет журнал
+func restoreVersion(ctx context.Context, user *models.User, filename string, version int) (*models.File, error) {
+	if err := requireChange(user); err != nil {
+		return nil, err
+	}
+	file, err := readableFile(user, filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireAccess(user, filename, models.PermissionWrite); err != nil {
+		return nil, err
+	}
+
+	restored, orphans, err := storage.FileStoreInstance.RestoreVersion(file.ID, version, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	removeBlobs(ctx, orphans)
+
+	details := fmt.Sprintf("%s: version %d restored as version %d", filename, version, restored.Version)
+	if err := storage.LogStoreInstance.AddLog(user.Username, models.ActionRestoreFile, details); err != nil {
+		log.Printf("Failed to log restore action: %v", err)
+	}
+	return restored, nil
+}
+
+// APIListVersionsHandler возвращает историю версий файла
+func APIListVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	file, err := readableFile(currentUser(r), mux.Vars(r)["filename"])
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			writeJSONError(w, http.StatusNotFound, "file not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	versions, err := storage.FileStoreInstance.ListVersions(file.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"versions": versions})
+}
+
+// APIRestoreVersionHandler делает выбранную версию текущей
+func APIRestoreVersionHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !user.CanUpload {
+		writeJSONError(w, http.StatusForbidden, "you don't have permission to restore versions")
+		return
+	}
+
+	vars := mux.Vars(r)
+	version, _ := strconv.Atoi(vars["version"])
+	file, err := restoreVersion(r.Context(), user, vars["filename"], version)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) || errors.Is(err, storage.ErrVersionNotFound) {
+			writeJSONError(w, http.StatusNotFound, "version not found")
+			return
+		}
+		if errors.Is(err, errAccessDenied) || errors.Is(err, errTokenScope) {
+			writeAPIPathError(w, err)
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, file)
+}
@@ -0,0 +1,147 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Классы (кроме универсального, равного нулю) и флаг составного типа в теге BER
+const (
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// Универсальные теги, которые встречаются в сообщениях LDAP
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = constructed | 0x10
+	tagSet         = constructed | 0x11
+)
+
+// maxMessageSize ограничивает размер одного ответа сервера
+const maxMessageSize = 16 << 20
+
+var errMalformed = errors.New("ldap: malformed BER data")
+
+// element кодирует TLV-элемент с однобайтовым тегом
+func element(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	n := len(content)
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xff:
+		out = append(out, 0x81, byte(n))
+	case n <= 0xffff:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(out, content...)
+}
+
+// sequence кодирует составной элемент из уже закодированных частей
+func sequence(tag byte, parts ...[]byte) []byte {
+	var content []byte
+	for _, part := range parts {
+		content = append(content, part...)
+	}
+	return element(tag, content)
+}
+
+func octetString(s string) []byte {
+	return element(tagOctetString, []byte(s))
+}
+
+func boolean(b bool) []byte {
+	if b {
+		return element(tagBoolean, []byte{0xff})
+	}
+	return element(tagBoolean, []byte{0x00})
+}
+
+// integer кодирует неотрицательное целое (INTEGER или ENUMERATED в зависимости от tag)
+func integer(tag byte, v int64) []byte {
+	content := []byte{byte(v)}
+	for v > 0x7f || v < -0x80 {
+		v >>= 8
+		content = append([]byte{byte(v)}, content...)
+	}
+	return element(tag, content)
+}
+
+// parseElement разбирает первый элемент в data и возвращает тег, содержимое и остаток
+func parseElement(data []byte) (tag byte, content, rest []byte, err error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errMalformed
+	}
+	tag = data[0]
+	length, header := int(data[1]), 2
+	if length&0x80 != 0 {
+		octets := length & 0x7f
+		if octets == 0 || octets > 4 || len(data) < 2+octets {
+			return 0, nil, nil, errMalformed
+		}
+		length = 0
+		for _, b := range data[2 : 2+octets] {
+			length = length<<8 | int(b)
+		}
+		header += octets
+	}
+	if length < 0 || len(data)-header < length {
+		return 0, nil, nil, errMalformed
+	}
+	return tag, data[header : header+length], data[header+length:], nil
+}
+
+// parseInteger читает содержимое INTEGER или ENUMERATED
+func parseInteger(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, errMalformed
+	}
+	v := int64(int8(content[0]))
+	for _, b := range content[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v, nil
+}
+
+// readMessage читает из потока один элемент верхнего уровня целиком
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		octets := length & 0x7f
+		if octets == 0 || octets > 4 {
+			return nil, errMalformed
+		}
+		extra := make([]byte, octets)
+		if _, err := io.ReadFull(r, extra); err != nil {
+			return nil, err
+		}
+		header = append(header, extra...)
+		length = 0
+		for _, b := range extra {
+			length = length<<8 | int(b)
+		}
+	}
+	if length < 0 || length > maxMessageSize {
+		return nil, fmt.Errorf("ldap: message of %d bytes is too large", length)
+	}
+
+	message := make([]byte, len(header)+length)
+	copy(message, header)
+	if _, err := io.ReadFull(r, message[len(header):]); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
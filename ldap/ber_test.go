@@ -0,0 +1,109 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestElementLength(t *testing.T) {
+	tests := []struct {
+		size   int
+		header []byte
+	}{
+		{0, []byte{tagOctetString, 0x00}},
+		{0x7f, []byte{tagOctetString, 0x7f}},
+		{0x80, []byte{tagOctetString, 0x81, 0x80}},
+		{0xff, []byte{tagOctetString, 0x81, 0xff}},
+		{0x100, []byte{tagOctetString, 0x82, 0x01, 0x00}},
+		{0x10000, []byte{tagOctetString, 0x84, 0x00, 0x01, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		content := bytes.Repeat([]byte{'x'}, tt.size)
+		encoded := element(tagOctetString, content)
+		if !bytes.HasPrefix(encoded, tt.header) || len(encoded) != len(tt.header)+tt.size {
+			t.Errorf("element of %d bytes: header %x, want %x", tt.size, encoded[:len(tt.header)], tt.header)
+			continue
+		}
+
+		tag, parsed, rest, err := parseElement(append(encoded, 0x01))
+		if err != nil || tag != tagOctetString || !bytes.Equal(parsed, content) || !bytes.Equal(rest, []byte{0x01}) {
+			t.Errorf("parseElement of %d bytes: tag %x, %d bytes, rest %x, err %v", tt.size, tag, len(parsed), rest, err)
+		}
+	}
+}
+
+func TestInteger(t *testing.T) {
+	tests := []struct {
+		value   int64
+		content []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x01}},
+		{0x7f, []byte{0x7f}},
+		{0x80, []byte{0x00, 0x80}},
+		{0x100, []byte{0x01, 0x00}},
+		{0x7fffffff, []byte{0x7f, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		encoded := integer(tagInteger, tt.value)
+		if want := element(tagInteger, tt.content); !bytes.Equal(encoded, want) {
+			t.Errorf("integer(%d) = %x, want %x", tt.value, encoded, want)
+		}
+		if got, err := parseInteger(tt.content); err != nil || got != tt.value {
+			t.Errorf("parseInteger(%x) = %d, %v, want %d", tt.content, got, err, tt.value)
+		}
+	}
+
+	if got, err := parseInteger([]byte{0xff}); err != nil || got != -1 {
+		t.Errorf("parseInteger(ff) = %d, %v, want -1", got, err)
+	}
+	for _, content := range [][]byte{nil, make([]byte, 9)} {
+		if _, err := parseInteger(content); err == nil {
+			t.Errorf("parseInteger(%x) accepted", content)
+		}
+	}
+}
+
+func TestParseElementMalformed(t *testing.T) {
+	inputs := [][]byte{
+		nil,
+		{tagOctetString},
+		{tagOctetString, 0x02, 'a'},
+		{tagOctetString, 0x80},
+		{tagOctetString, 0x85, 0, 0, 0, 0, 1},
+		{tagOctetString, 0x82, 0x01},
+		{tagOctetString, 0x81, 0x05, 'a'},
+	}
+	for _, input := range inputs {
+		if _, _, _, err := parseElement(input); err == nil {
+			t.Errorf("parseElement(%x) accepted", input)
+		}
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	first := sequence(tagSequence, integer(tagInteger, 1), octetString("hello"))
+	second := sequence(tagSequence, octetString(string(bytes.Repeat([]byte{'y'}, 300))))
+	r := bufio.NewReader(bytes.NewReader(append(append([]byte{}, first...), second...)))
+
+	for i, want := range [][]byte{first, second} {
+		got, err := readMessage(r)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("message %d: got %x, %v, want %x", i, got, err, want)
+		}
+	}
+	if _, err := readMessage(r); err == nil {
+		t.Error("readMessage after the last message succeeded")
+	}
+
+	truncated := bufio.NewReader(bytes.NewReader(first[:len(first)-1]))
+	if _, err := readMessage(truncated); err == nil {
+		t.Error("readMessage of a truncated message succeeded")
+	}
+
+	huge := bufio.NewReader(bytes.NewReader([]byte{tagSequence, 0x84, 0x7f, 0xff, 0xff, 0xff}))
+	if _, err := readMessage(huge); err == nil {
+		t.Error("readMessage accepted a message larger than maxMessageSize")
+	}
+}
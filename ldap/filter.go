@@ -0,0 +1,195 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Теги вариантов Filter (RFC 4511, раздел 4.5.1)
+const (
+	filterAnd            = classContext | constructed | 0
+	filterOr             = classContext | constructed | 1
+	filterNot            = classContext | constructed | 2
+	filterEqualityMatch  = classContext | constructed | 3
+	filterSubstrings     = classContext | constructed | 4
+	filterGreaterOrEqual = classContext | constructed | 5
+	filterLessOrEqual    = classContext | constructed | 6
+	filterPresent        = classContext | 7
+	filterApproxMatch    = classContext | constructed | 8
+
+	substringInitial = classContext | 0
+	substringAny     = classContext | 1
+	substringFinal   = classContext | 2
+)
+
+// EscapeFilter экранирует значение для подстановки в строку фильтра (RFC 4515),
+// чтобы введенное пользователем имя не могло изменить сам фильтр
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter переводит строковый фильтр вида (&(objectClass=person)(uid=alice)) в BER.
+// Поддерживаются &, |, !, =, ~=, >=, <=, проверка наличия (attr=*) и подстроки;
+// extensible match (attr:rule:=value) не поддерживается.
+func compileFilter(filter string) ([]byte, error) {
+	filter = strings.TrimSpace(filter)
+	encoded, pos, err := parseFilter(filter, 0)
+	if err != nil {
+		return nil, err
+	}
+	if pos != len(filter) {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", filter[pos:])
+	}
+	return encoded, nil
+}
+
+// parseFilter разбирает фильтр в скобках, начинающийся с позиции pos
+func parseFilter(filter string, pos int) ([]byte, int, error) {
+	if pos >= len(filter) || filter[pos] != '(' {
+		return nil, pos, fmt.Errorf("ldap: filter must start with '(' at %d", pos)
+	}
+	pos++
+	if pos >= len(filter) {
+		return nil, pos, fmt.Errorf("ldap: unterminated filter")
+	}
+
+	switch filter[pos] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if filter[pos] == '|' {
+			tag = filterOr
+		}
+		pos++
+		var parts [][]byte
+		for pos < len(filter) && filter[pos] == '(' {
+			part, next, err := parseFilter(filter, pos)
+			if err != nil {
+				return nil, next, err
+			}
+			parts = append(parts, part)
+			pos = next
+		}
+		if len(parts) == 0 {
+			return nil, pos, fmt.Errorf("ldap: empty filter list")
+		}
+		if pos >= len(filter) || filter[pos] != ')' {
+			return nil, pos, fmt.Errorf("ldap: unterminated filter list")
+		}
+		return sequence(tag, parts...), pos + 1, nil
+	case '!':
+		part, next, err := parseFilter(filter, pos+1)
+		if err != nil {
+			return nil, next, err
+		}
+		if next >= len(filter) || filter[next] != ')' {
+			return nil, next, fmt.Errorf("ldap: unterminated negation")
+		}
+		return sequence(filterNot, part), next + 1, nil
+	}
+
+	end := strings.IndexByte(filter[pos:], ')')
+	if end < 0 {
+		return nil, pos, fmt.Errorf("ldap: unterminated filter item")
+	}
+	item, err := parseItem(filter[pos : pos+end])
+	if err != nil {
+		return nil, pos, err
+	}
+	return item, pos + end + 1, nil
+}
+
+// parseItem разбирает простое условие без скобок
+func parseItem(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+
+	attr, value := item[:eq], item[eq+1:]
+	tag := byte(filterEqualityMatch)
+	switch attr[len(attr)-1] {
+	case '~':
+		tag = filterApproxMatch
+	case '>':
+		tag = filterGreaterOrEqual
+	case '<':
+		tag = filterLessOrEqual
+	}
+	if tag != filterEqualityMatch {
+		attr = attr[:len(attr)-1]
+	}
+	if attr == "" || strings.ContainsAny(attr, ":()*\\ ") {
+		return nil, fmt.Errorf("ldap: unsupported attribute in filter item %q", item)
+	}
+
+	if tag == filterEqualityMatch && value == "*" {
+		return element(filterPresent, []byte(attr)), nil
+	}
+	if tag == filterEqualityMatch && strings.Contains(value, "*") {
+		return parseSubstrings(attr, value)
+	}
+
+	unescaped, err := unescapeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return sequence(tag, octetString(attr), octetString(unescaped)), nil
+}
+
+// parseSubstrings кодирует условие с подстановочными знаками вида cn=ad*min*
+func parseSubstrings(attr, value string) ([]byte, error) {
+	pieces := strings.Split(value, "*")
+	var parts [][]byte
+	for i, piece := range pieces {
+		if piece == "" {
+			continue
+		}
+		unescaped, err := unescapeValue(piece)
+		if err != nil {
+			return nil, err
+		}
+		tag := byte(substringAny)
+		switch i {
+		case 0:
+			tag = substringInitial
+		case len(pieces) - 1:
+			tag = substringFinal
+		}
+		parts = append(parts, element(tag, []byte(unescaped)))
+	}
+	return sequence(filterSubstrings, octetString(attr), sequence(tagSequence, parts...)), nil
+}
+
+// unescapeValue раскрывает последовательности \XX в значении фильтра
+func unescapeValue(value string) (string, error) {
+	if !strings.Contains(value, "\\") {
+		return value, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("ldap: invalid escape in %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in %q", value)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,115 @@
+package ldap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEscapeFilter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"alice", "alice"},
+		{"*", `\2a`},
+		{"admin)(uid=*", `admin\29\28uid=\2a`},
+		{"*)(|(objectClass=*", `\2a\29\28|\28objectClass=\2a`},
+		{`back\slash`, `back\5cslash`},
+		{"nul\x00byte", `nul\00byte`},
+		{"Иван", "Иван"},
+	}
+	for _, tt := range tests {
+		if got := EscapeFilter(tt.value); got != tt.want {
+			t.Errorf("EscapeFilter(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+// Введенное имя с метасимволами фильтра после экранирования остается одним значением
+// в условии равенства и не добавляет условий и подстановочных знаков
+func TestEscapedFilterInjection(t *testing.T) {
+	inputs := []string{
+		"*",
+		"admin)(uid=*",
+		"*)(|(objectClass=*",
+		"x)(!(uid=x",
+		`a\29b`,
+		"nul\x00byte",
+	}
+	for _, input := range inputs {
+		filter := strings.ReplaceAll("(&(objectClass=person)(uid={username}))", "{username}", EscapeFilter(input))
+		got, err := compileFilter(filter)
+		if err != nil {
+			t.Errorf("input %q: compileFilter(%q): %v", input, filter, err)
+			continue
+		}
+		want := sequence(filterAnd,
+			sequence(filterEqualityMatch, octetString("objectClass"), octetString("person")),
+			sequence(filterEqualityMatch, octetString("uid"), octetString(input)),
+		)
+		if !bytes.Equal(got, want) {
+			t.Errorf("input %q: filter %q compiled to %x, want %x", input, filter, got, want)
+		}
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []byte
+	}{
+		{"(uid=alice)", sequence(filterEqualityMatch, octetString("uid"), octetString("alice"))},
+		{" (uid=alice) ", sequence(filterEqualityMatch, octetString("uid"), octetString("alice"))},
+		{"(cn=*)", element(filterPresent, []byte("cn"))},
+		{"(cn~=al)", sequence(filterApproxMatch, octetString("cn"), octetString("al"))},
+		{"(age>=18)", sequence(filterGreaterOrEqual, octetString("age"), octetString("18"))},
+		{"(age<=65)", sequence(filterLessOrEqual, octetString("age"), octetString("65"))},
+		{"(cn=a\\2ab)", sequence(filterEqualityMatch, octetString("cn"), octetString("a*b"))},
+		{"(cn=ad*mi*n)", sequence(filterSubstrings, octetString("cn"), sequence(tagSequence,
+			element(substringInitial, []byte("ad")),
+			element(substringAny, []byte("mi")),
+			element(substringFinal, []byte("n")),
+		))},
+		{"(cn=*min)", sequence(filterSubstrings, octetString("cn"), sequence(tagSequence,
+			element(substringFinal, []byte("min")),
+		))},
+		{"(|(uid=a)(!(uid=b)))", sequence(filterOr,
+			sequence(filterEqualityMatch, octetString("uid"), octetString("a")),
+			sequence(filterNot, sequence(filterEqualityMatch, octetString("uid"), octetString("b"))),
+		)},
+	}
+	for _, tt := range tests {
+		got, err := compileFilter(tt.filter)
+		if err != nil {
+			t.Errorf("compileFilter(%q): %v", tt.filter, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("compileFilter(%q) = %x, want %x", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	filters := []string{
+		"",
+		"uid=alice",
+		"(uid=alice",
+		"(uid=alice))",
+		"(uid=alice)(cn=bob)",
+		"(&)",
+		"(&(uid=a)",
+		"(!(uid=a)",
+		"(=alice)",
+		"(uid)",
+		"(cn:dn:=x)",
+		`(cn=a\2)`,
+		`(cn=a\zz)`,
+	}
+	for _, filter := range filters {
+		if encoded, err := compileFilter(filter); err == nil {
+			t.Errorf("compileFilter(%q) = %x, want error", filter, encoded)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+// Package ldap реализует минимальный клиент LDAPv3 (RFC 4511), которого достаточно для
+// входа через каталог: простой bind, поиск, StartTLS. Запросы выполняются по одному.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Теги операций протокола
+const (
+	opBindRequest           = classApplication | constructed | 0
+	opBindResponse          = classApplication | constructed | 1
+	opUnbindRequest         = classApplication | 2
+	opSearchRequest         = classApplication | constructed | 3
+	opSearchEntry           = classApplication | constructed | 4
+	opSearchDone            = classApplication | constructed | 5
+	opSearchReference       = classApplication | constructed | 19
+	opExtendedRequest       = classApplication | constructed | 23
+	opExtendedResponse      = classApplication | constructed | 24
+	tagSimpleAuth           = classContext | 0
+	tagExtendedName         = classContext | 0
+	startTLSOID             = "1.3.6.1.4.1.1466.20037"
+	defaultPortPlain        = "389"
+	defaultPortTLS          = "636"
+	protocolVersion         = 3
+	derefAliasesNever       = 0
+	resultSuccess           = 0
+	resultSizeLimitExceeded = 4
+)
+
+// Коды результатов, которые различает вызывающий код
+const (
+	ResultInvalidCredentials = 49
+	ResultNoSuchObject       = 32
+)
+
+// Scope область поиска
+type Scope int
+
+const (
+	ScopeBaseObject   Scope = 0
+	ScopeSingleLevel  Scope = 1
+	ScopeWholeSubtree Scope = 2
+)
+
+// ErrInvalidCredentials сервер отклонил bind: неверный DN или пароль
+var ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+
+// Error ответ сервера с кодом, отличным от успеха
+type Error struct {
+	ResultCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.ResultCode)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.ResultCode, e.Message)
+}
+
+// Entry найденная запись каталога. Имена атрибутов приведены к нижнему регистру.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Values возвращает значения атрибута без учета регистра имени
+func (e *Entry) Values(attr string) []string {
+	return e.Attributes[strings.ToLower(attr)]
+}
+
+// SearchRequest параметры поиска
+type SearchRequest struct {
+	BaseDN     string
+	Scope      Scope
+	Filter     string
+	Attributes []string
+	// SizeLimit ограничивает число записей в ответе; 0 - без ограничения
+	SizeLimit int
+}
+
+// Conn соединение с сервером LDAP
+type Conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	host      string
+	timeout   time.Duration
+	messageID int64
+}
+
+// Dial подключается к серверу по адресу ldap://host[:port] или ldaps://host[:port].
+// timeout ограничивает и подключение, и каждую операцию.
+func Dial(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid URL %q: %w", rawURL, err)
+	}
+
+	host, port := u.Hostname(), u.Port()
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if port == "" {
+			port = defaultPortPlain
+		}
+		conn, err = dialer.Dial("tcp", net.JoinHostPort(host, port))
+	case "ldaps":
+		if port == "" {
+			port = defaultPortTLS
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), withServerName(tlsConfig, host))
+	default:
+		return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap: %w", err)
+	}
+
+	return &Conn{conn: conn, reader: bufio.NewReader(conn), host: host, timeout: timeout}, nil
+}
+
+// withServerName копирует настройки TLS и подставляет имя сервера для проверки сертификата
+func withServerName(cfg *tls.Config, host string) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg = cfg.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	return cfg
+}
+
+// Close отправляет unbind и закрывает соединение
+func (c *Conn) Close() error {
+	c.send(element(opUnbindRequest, nil))
+	return c.conn.Close()
+}
+
+// StartTLS переводит открытое соединение ldap:// на TLS
+func (c *Conn) StartTLS(tlsConfig *tls.Config) error {
+	id, err := c.send(sequence(opExtendedRequest, element(tagExtendedName, []byte(startTLSOID))))
+	if err != nil {
+		return err
+	}
+	op, content, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op != opExtendedResponse {
+		return fmt.Errorf("ldap: unexpected response 0x%02x to StartTLS", op)
+	}
+	if err := parseResult(content); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Client(c.conn, withServerName(tlsConfig, c.host))
+	tlsConn.SetDeadline(time.Now().Add(c.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("ldap: TLS handshake: %w", err)
+	}
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind выполняет простую аутентификацию. Пустой пароль отклоняется сразу: сервер принял бы
+// его как анонимный вход (RFC 4513, раздел 5.1.2), что для проверки пароля недопустимо.
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+
+	id, err := c.send(sequence(opBindRequest,
+		integer(tagInteger, protocolVersion),
+		octetString(dn),
+		element(tagSimpleAuth, []byte(password)),
+	))
+	if err != nil {
+		return err
+	}
+	op, content, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op != opBindResponse {
+		return fmt.Errorf("ldap: unexpected response 0x%02x to bind", op)
+	}
+
+	err = parseResult(content)
+	var ldapErr *Error
+	if errors.As(err, &ldapErr) && ldapErr.ResultCode == ResultInvalidCredentials {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// Search выполняет поиск и возвращает найденные записи. Ссылки на другие серверы пропускаются.
+func (c *Conn) Search(req SearchRequest) ([]Entry, error) {
+	filter, err := compileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := make([][]byte, 0, len(req.Attributes))
+	for _, attr := range req.Attributes {
+		attributes = append(attributes, octetString(attr))
+	}
+
+	id, err := c.send(sequence(opSearchRequest,
+		octetString(req.BaseDN),
+		integer(tagEnumerated, int64(req.Scope)),
+		integer(tagEnumerated, derefAliasesNever),
+		integer(tagInteger, int64(req.SizeLimit)),
+		integer(tagInteger, int64(c.timeout/time.Second)),
+		boolean(false),
+		filter,
+		sequence(tagSequence, attributes...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for {
+		op, content, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opSearchEntry:
+			entry, err := parseEntry(content)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case opSearchReference:
+			continue
+		case opSearchDone:
+			err := parseResult(content)
+			var ldapErr *Error
+			if errors.As(err, &ldapErr) && ldapErr.ResultCode == resultSizeLimitExceeded {
+				// Записи до лимита уже получены, решение о лишних принимает вызывающий код
+				return entries, nil
+			}
+			return entries, err
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response 0x%02x to search", op)
+		}
+	}
+}
+
+// send отправляет операцию и возвращает номер сообщения
+func (c *Conn) send(op []byte) (int64, error) {
+	c.messageID++
+	message := sequence(tagSequence, integer(tagInteger, c.messageID), op)
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(message); err != nil {
+		return 0, fmt.Errorf("ldap: %w", err)
+	}
+	return c.messageID, nil
+}
+
+// receive читает ответ на сообщение id и возвращает тег операции и ее содержимое
+func (c *Conn) receive(id int64) (byte, []byte, error) {
+	for {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+		message, err := readMessage(c.reader)
+		if err != nil {
+			return 0, nil, fmt.Errorf("ldap: %w", err)
+		}
+
+		tag, content, _, err := parseElement(message)
+		if err != nil || tag != tagSequence {
+			return 0, nil, errMalformed
+		}
+		tag, idContent, rest, err := parseElement(content)
+		if err != nil || tag != tagInteger {
+			return 0, nil, errMalformed
+		}
+		messageID, err := parseInteger(idContent)
+		if err != nil {
+			return 0, nil, err
+		}
+		op, opContent, _, err := parseElement(rest)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// Сообщение 0 - уведомление сервера, например о разрыве соединения
+		if messageID == 0 {
+			return 0, nil, fmt.Errorf("ldap: connection closed by server: %w", parseResult(opContent))
+		}
+		if messageID == id {
+			return op, opContent, nil
+		}
+	}
+}
+
+// parseResult разбирает LDAPResult и возвращает *Error для неуспешного кода
+func parseResult(content []byte) error {
+	tag, codeContent, rest, err := parseElement(content)
+	if err != nil || tag != tagEnumerated {
+		return errMalformed
+	}
+	code, err := parseInteger(codeContent)
+	if err != nil {
+		return err
+	}
+	if code == resultSuccess {
+		return nil
+	}
+
+	// matchedDN и diagnosticMessage
+	_, _, rest, err = parseElement(rest)
+	if err != nil {
+		return &Error{ResultCode: int(code)}
+	}
+	_, message, _, err := parseElement(rest)
+	if err != nil {
+		return &Error{ResultCode: int(code)}
+	}
+	return &Error{ResultCode: int(code), Message: string(message)}
+}
+
+// parseEntry разбирает SearchResultEntry
+func parseEntry(content []byte) (Entry, error) {
+	tag, dn, rest, err := parseElement(content)
+	if err != nil || tag != tagOctetString {
+		return Entry{}, errMalformed
+	}
+	tag, attributes, _, err := parseElement(rest)
+	if err != nil || tag != tagSequence {
+		return Entry{}, errMalformed
+	}
+
+	entry := Entry{DN: string(dn), Attributes: make(map[string][]string)}
+	for len(attributes) > 0 {
+		var attribute []byte
+		tag, attribute, attributes, err = parseElement(attributes)
+		if err != nil || tag != tagSequence {
+			return Entry{}, errMalformed
+		}
+		tag, name, values, err := parseElement(attribute)
+		if err != nil || tag != tagOctetString {
+			return Entry{}, errMalformed
+		}
+		tag, values, _, err = parseElement(values)
+		if err != nil || tag != tagSet {
+			return Entry{}, errMalformed
+		}
+
+		key := strings.ToLower(string(name))
+		for len(values) > 0 {
+			var value []byte
+			tag, value, values, err = parseElement(values)
+			if err != nil || tag != tagOctetString {
+				return Entry{}, errMalformed
+			}
+			entry.Attributes[key] = append(entry.Attributes[key], string(value))
+		}
+	}
+	return entry, nil
+}
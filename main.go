@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"file-exchange-app/config"
 	"file-exchange-app/handlers"
 	"file-exchange-app/storage"
+	"flag"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -17,7 +20,7 @@ import (
 
 // Объявляем метрики как глобальные переменные
 var (
-	// Счетчик попыток авторизации с меткой status (success/failure)
+	// Счетчик попыток авторизации с меткой status (success/failure/blocked)
 	loginAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "file_exchange_login_attempts_total",
 		Help: "Total number of login attempts",
@@ -32,80 +35,194 @@ var (
 	// Gauge для отслеживания использования дискового пространства
 	diskUsageBytes = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "file_exchange_disk_usage_bytes",
-		Help: "Current disk usage of uploads directory in bytes",
+		Help: "Current disk usage of file storage in bytes",
 	})
 
 	// Счетчик для отслеживания количества файлов
 	fileCount = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "file_exchange_file_count",
-		Help: "Current number of files in uploads directory",
+		Help: "Current number of files in file storage",
 	})
 )
 
-// Функция для расчета размера директории
-func getDirSize(path string) (int64, int, error) {
+// Функция для расчета объема хранилища файлов
+func getStorageSize(ctx context.Context) (int64, int, error) {
+	blobs, err := storage.BlobStoreInstance.List(ctx, "")
+	if err != nil {
+		return 0, 0, err
+	}
 	var size int64
-	var count int
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			size += info.Size()
-			count++
-		}
-		return nil
-	})
-	return size, count, err
+	for _, blob := range blobs {
+		size += blob.Size
+	}
+	return size, len(blobs), nil
 }
 
 // Функция для периодического обновления метрик диска
-func updateDiskMetrics() {
+func updateDiskMetrics(interval time.Duration, uploads *handlers.Uploads) {
 	for {
-		size, count, err := getDirSize("./uploads")
+		size, count, err := getStorageSize(context.Background())
 		if err != nil {
-			if os.IsNotExist(err) {
-				// Папка не существует, устанавливаем 0
-				diskUsageBytes.Set(0)
-				fileCount.Set(0)
-			} else {
-				log.Printf("Error getting directory size: %v", err)
-			}
+			log.Printf("Error getting storage size: %v", err)
 		} else {
 			diskUsageBytes.Set(float64(size))
 			fileCount.Set(float64(count))
 		}
-		time.Sleep(30 * time.Second) // Обновляем каждые 30 секунд
+
+		// Заодно удаляем брошенные возобновляемые загрузки
+		uploads.CleanupExpiredUploads()
+
+		// и истекшие сессии входа
+		if _, err := storage.SessionStoreInstance.DeleteExpiredSessions(); err != nil {
+			log.Printf("Error deleting expired sessions: %v", err)
+		}
+		if err := storage.LoginThrottleInstance.DeleteStale(time.Now()); err != nil {
+			log.Printf("Error deleting stale login failures: %v", err)
+		}
+
+		time.Sleep(interval)
 	}
 }
 
+// Функция для импорта файлов из хранилища, о которых нет записей в БД
+func reconcileFiles() {
+	imported, err := storage.ReconcileFiles(context.Background(), storage.BlobStoreInstance, storage.FileStoreInstance)
+	if err != nil {
+		log.Fatal("Could not reconcile files:", err)
+	}
+	log.Printf("Reconcile: imported %d file(s)", imported)
+}
+
 func main() {
+	// Собираем конфигурацию из файла, переменных окружения и флагов
+	cfg, opts, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Could not load configuration: ", err)
+	}
+	// --print-config показывает итоговые значения даже при ошибках в них
+	if opts.PrintConfig {
+		cfg.Write(os.Stdout)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+	if opts.PrintConfig {
+		return
+	}
+	handlers.SetSecureCookies(cfg.TLS.Enabled())
+
 	// Инициализируем БД
-	err := storage.InitDB()
+	err = storage.InitDB(cfg)
 	if err != nil {
 		log.Fatal("Could not initialize database:", err)
 	}
 
+	// Настраиваем провайдеры входа: локальные учетные записи, каталог LDAP и единый вход OIDC
+	err = storage.InitAuthProviders(cfg.LDAP, cfg.OIDC)
+	if err != nil {
+		log.Fatal("Could not initialize authentication:", err)
+	}
+
+	// Подключаем хранилище файлов (локальный диск или S3, см. storage.backend)
+	err = storage.InitBlobStore(cfg.Storage)
+	if err != nil {
+		log.Fatal("Could not initialize file storage:", err)
+	}
+
+	// Команда reconcile импортирует в таблицу files уже лежащие в хранилище файлы
+	if len(opts.Args) > 0 && opts.Args[0] == "reconcile" {
+		reconcileFiles()
+		return
+	}
+
+	// При первом запуске после обновления таблица файлов пуста - импортируем автоматически
+	if files, err := storage.FileStoreInstance.ListFiles(); err == nil && len(files) == 0 {
+		reconcileFiles()
+	}
+
+	// Обработчики загрузки получают лимиты и папку tus из конфигурации
+	uploads := handlers.NewUploads(cfg)
+
 	// Запускаем горутину для обновления метрик диска
-	go updateDiskMetrics()
+	go updateDiskMetrics(cfg.Metrics.Interval, uploads)
 
 	r := mux.NewRouter()
+	// Все меняющие состояние запросы из браузера должны нести CSRF-токен
+	r.Use(handlers.CSRFMiddleware)
 
 	// Публичные маршруты
 	r.HandleFunc("/login", handlers.LoginHandler).Methods("GET", "POST")
-	r.HandleFunc("/logout", handlers.LogoutHandler).Methods("GET")
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
+	r.HandleFunc("/login/2fa", handlers.TwoFactorLoginHandler).Methods("GET", "POST")
+	r.HandleFunc("/login/oidc", handlers.OIDCLoginHandler).Methods("GET")
+	r.HandleFunc("/login/oidc/callback", handlers.OIDCCallbackHandler).Methods("GET")
+	r.HandleFunc("/logout", handlers.LogoutHandler).Methods("POST")
+	r.HandleFunc("/s/{token}", handlers.PublicShareHandler).Methods("GET")
+	r.HandleFunc("/s/{token}", handlers.PublicShareDownloadHandler).Methods("POST")
+	r.HandleFunc("/s/{token}/download", handlers.PublicShareDownloadHandler).Methods("GET")
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(cfg.Server.StaticDir))))
 
 	// Защищенные маршруты (требуют авторизации) - ИСПРАВЛЕНО
 	r.Handle("/dashboard", handlers.AuthMiddleware(http.HandlerFunc(handlers.DashboardHandler))).Methods("GET")
-	r.Handle("/upload", handlers.AuthMiddleware(http.HandlerFunc(handlers.UploadHandler))).Methods("POST")
-	r.Handle("/download/{filename}", handlers.AuthMiddleware(http.HandlerFunc(handlers.DownloadHandler))).Methods("GET")
+	r.Handle("/upload", handlers.AuthMiddleware(http.HandlerFunc(uploads.UploadHandler))).Methods("POST")
+	r.Handle("/download/{filename:.+}", handlers.AuthMiddleware(http.HandlerFunc(handlers.DownloadHandler))).Methods("GET", "HEAD")
+	r.Handle("/archive", handlers.AuthMiddleware(http.HandlerFunc(handlers.ArchiveHandler))).Methods("GET", "POST")
+	r.Handle("/history/{filename:.+}", handlers.AuthMiddleware(http.HandlerFunc(handlers.HistoryHandler))).Methods("GET")
+	r.Handle("/restore/{version:[0-9]+}/{filename:.+}", handlers.AuthMiddleware(http.HandlerFunc(handlers.RestoreVersionHandler))).Methods("POST")
+	r.Handle("/files/move", handlers.AuthMiddleware(http.HandlerFunc(handlers.MoveFileHandler))).Methods("POST")
+	r.Handle("/folders/create", handlers.AuthMiddleware(http.HandlerFunc(handlers.CreateFolderHandler))).Methods("POST")
+	r.Handle("/folders/move", handlers.AuthMiddleware(http.HandlerFunc(handlers.MoveFolderHandler))).Methods("POST")
+	r.Handle("/folders/delete", handlers.AuthMiddleware(http.HandlerFunc(handlers.DeleteFolderHandler))).Methods("POST")
+	r.Handle("/access", handlers.AuthMiddleware(http.HandlerFunc(handlers.AccessHandler))).Methods("GET")
+	r.Handle("/access/grant", handlers.AuthMiddleware(http.HandlerFunc(handlers.GrantAccessHandler))).Methods("POST")
+	r.Handle("/access/{id:[0-9]+}/revoke", handlers.AuthMiddleware(http.HandlerFunc(handlers.RevokeAccessHandler))).Methods("POST")
+	r.Handle("/shares", handlers.AuthMiddleware(http.HandlerFunc(handlers.SharesHandler))).Methods("GET")
+	r.Handle("/shares/create", handlers.AuthMiddleware(http.HandlerFunc(handlers.CreateShareHandler))).Methods("POST")
+	r.Handle("/shares/{id:[0-9]+}/revoke", handlers.AuthMiddleware(http.HandlerFunc(handlers.RevokeShareHandler))).Methods("POST")
+	r.Handle("/profile", handlers.AuthMiddleware(http.HandlerFunc(handlers.ProfileHandler))).Methods("GET")
+	r.Handle("/profile/password", handlers.AuthMiddleware(http.HandlerFunc(handlers.ChangePasswordHandler))).Methods("POST")
+	r.Handle("/profile/2fa/enable", handlers.AuthMiddleware(http.HandlerFunc(handlers.EnableTwoFactorHandler))).Methods("POST")
+	r.Handle("/profile/2fa/disable", handlers.AuthMiddleware(http.HandlerFunc(handlers.DisableTwoFactorHandler))).Methods("POST")
+	r.Handle("/profile/2fa/recovery", handlers.AuthMiddleware(http.HandlerFunc(handlers.RegenerateRecoveryCodesHandler))).Methods("POST")
+	r.Handle("/tokens", handlers.AuthMiddleware(http.HandlerFunc(handlers.TokensHandler))).Methods("GET")
+	r.Handle("/tokens/create", handlers.AuthMiddleware(http.HandlerFunc(handlers.CreateTokenHandler))).Methods("POST")
+	r.Handle("/tokens/{id:[0-9]+}/revoke", handlers.AuthMiddleware(http.HandlerFunc(handlers.RevokeTokenHandler))).Methods("POST")
+	r.Handle("/sessions", handlers.AuthMiddleware(http.HandlerFunc(handlers.SessionsHandler))).Methods("GET")
+	r.Handle("/sessions/{id:[0-9]+}/revoke", handlers.AuthMiddleware(http.HandlerFunc(handlers.RevokeSessionHandler))).Methods("POST")
+	r.Handle("/sessions/revoke-others", handlers.AuthMiddleware(http.HandlerFunc(handlers.RevokeOtherSessionsHandler))).Methods("POST")
 
 	// Админские маршруты (требуют прав администратора)
 	adminRouter := r.PathPrefix("/admin").Subrouter()
 	adminRouter.Use(handlers.AdminMiddleware)
 	adminRouter.HandleFunc("", handlers.AdminHandler).Methods("GET")
 	adminRouter.HandleFunc("/create-user", handlers.CreateUserHandler).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/role", handlers.SetUserRoleHandler).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/permissions", handlers.UpdateUserPermissionsHandler).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/password", handlers.ResetPasswordHandler).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/disable", handlers.DisableUserHandler).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/enable", handlers.EnableUserHandler).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/unlock", handlers.UnlockUserHandler).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/delete", handlers.DeleteUserHandler).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/2fa/reset", handlers.ResetTwoFactorHandler).Methods("POST")
+	adminRouter.HandleFunc("/users/{id:[0-9]+}/sessions/revoke", handlers.RevokeUserSessionsHandler).Methods("POST")
+	adminRouter.HandleFunc("/settings", handlers.UpdateSettingsHandler).Methods("POST")
+	adminRouter.HandleFunc("/roles/create", handlers.CreateRoleHandler).Methods("POST")
+	adminRouter.HandleFunc("/roles/{id:[0-9]+}/update", handlers.UpdateRoleHandler).Methods("POST")
+	adminRouter.HandleFunc("/roles/{id:[0-9]+}/delete", handlers.DeleteRoleHandler).Methods("POST")
+	adminRouter.HandleFunc("/groups/create", handlers.CreateGroupHandler).Methods("POST")
+	adminRouter.HandleFunc("/groups/{id:[0-9]+}/role", handlers.SetGroupRoleHandler).Methods("POST")
+	adminRouter.HandleFunc("/groups/{id:[0-9]+}/delete", handlers.DeleteGroupHandler).Methods("POST")
+	adminRouter.HandleFunc("/groups/{id:[0-9]+}/members/add", handlers.AddGroupMemberHandler).Methods("POST")
+	adminRouter.HandleFunc("/groups/{id:[0-9]+}/members/{user_id:[0-9]+}/remove", handlers.RemoveGroupMemberHandler).Methods("POST")
+
+	// Возобновляемые загрузки по протоколу tus. Регистрируем до общего API,
+	// чтобы у tus были свои ответы об ошибках, а не JSON.
+	uploads.RegisterTusRoutes(r.PathPrefix("/api/v1/tus").Subrouter())
+
+	// JSON API для скриптов и внешних клиентов
+	handlers.RegisterAPIRoutes(r.PathPrefix("/api/v1").Subrouter(), uploads)
 
 	// Маршрут для метрик Prometheus
 	r.Handle("/metrics", promhttp.Handler())
@@ -117,9 +234,12 @@ func main() {
 	})
 
 	// Устанавливаем метрики в handlers
-	//handlers.LoginAttemptsCounter = loginAttempts
+	handlers.LoginAttemptsCounter = loginAttempts
 	//handlers.FileOperationsCounter = fileOperations
 
-	log.Println("Server starting on :8080...")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	if err := serve(cfg, r); err != nil {
+		log.Fatal("Server error: ", err)
+	}
+	storage.DB.Close()
+	log.Println("Server stopped")
 }
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Уровни доступа к файлу или папке. Каждый следующий включает предыдущие.
+const (
+	PermissionRead   = "read"   // Просмотр и скачивание
+	PermissionWrite  = "write"  // Загрузка новых версий и файлов в папку
+	PermissionManage = "manage" // Перемещение, удаление, публичные ссылки и управление доступом
+)
+
+// Кому выдано право
+const (
+	PrincipalUser  = "user"
+	PrincipalGroup = "group"
+)
+
+// На что выдано право
+const (
+	ResourceFile   = "file"
+	ResourceFolder = "folder"
+)
+
+// PermissionLevel переводит уровень доступа в число для сравнения; 0 - неизвестный уровень
+func PermissionLevel(permission string) int {
+	switch permission {
+	case PermissionRead:
+		return 1
+	case PermissionWrite:
+		return 2
+	case PermissionManage:
+		return 3
+	}
+	return 0
+}
+
+// ACLEntry одна запись списка доступа. Права на папку действуют на все ее содержимое.
+// Запись на папку с ResourceID 0 относится к корню хранилища.
+type ACLEntry struct {
+	ID            int       `json:"id"`
+	ResourceType  string    `json:"resource_type"`
+	ResourceID    int       `json:"resource_id"`
+	Path          string    `json:"path"`
+	PrincipalType string    `json:"principal_type"`
+	PrincipalID   int       `json:"principal_id"`
+	PrincipalName string    `json:"principal"`
+	Permission    string    `json:"permission"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// PathGrant право пользователя на путь, уже сведенное из записей ACL, групп и владения
+type PathGrant struct {
+	Path   string
+	Folder bool
+	Level  int
+}
+
+// Access права одного пользователя на все пути хранилища
+type Access struct {
+	All    bool // Администратор: полный доступ ко всему
+	Grants []PathGrant
+}
+
+// covers сообщает, действует ли право на путь
+func (g PathGrant) covers(path string) bool {
+	if g.Path == path {
+		return true
+	}
+	return g.Folder && (g.Path == "" || strings.HasPrefix(path, g.Path+"/"))
+}
+
+// Level возвращает наибольший уровень доступа к пути
+func (a *Access) Level(path string) int {
+	if a.All {
+		return PermissionLevel(PermissionManage)
+	}
+	level := 0
+	for _, grant := range a.Grants {
+		if grant.Level > level && grant.covers(path) {
+			level = grant.Level
+		}
+	}
+	return level
+}
+
+// Allows проверяет, что уровень доступа к пути не ниже permission
+func (a *Access) Allows(path, permission string) bool {
+	return a.Level(path) >= PermissionLevel(permission)
+}
+
+// CanSee сообщает, показывать ли папку: она доступна сама или внутри есть что-то доступное
+func (a *Access) CanSee(folder string) bool {
+	if folder == "" || a.Allows(folder, PermissionRead) {
+		return true
+	}
+	for _, grant := range a.Grants {
+		if strings.HasPrefix(grant.Path, folder+"/") {
+			return true
+		}
+	}
+	return false
+}
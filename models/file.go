@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// File представляет запись о загруженном файле. Содержимое лежит в хранилище
+// под ключом StoredKey, а Name - имя, под которым файл видят пользователи.
+type File struct {
+	ID        int       `json:"id"`
+	OwnerID   int       `json:"owner_id,omitempty"` // 0, если владелец неизвестен (файл импортирован)
+	OwnerName string    `json:"owner,omitempty"`
+	Name      string    `json:"name"`
+	StoredKey string    `json:"-"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	MimeType  string    `json:"mime_type"`
+	Version   int       `json:"version"` // Номер текущей версии
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FileVersion представляет одну версию содержимого файла.
+// Каждая загрузка файла с тем же именем создает новую версию.
+type FileVersion struct {
+	ID           int       `json:"id"`
+	FileID       int       `json:"file_id"`
+	Version      int       `json:"version"`
+	StoredKey    string    `json:"-"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	MimeType     string    `json:"mime_type"`
+	UploaderID   int       `json:"uploader_id,omitempty"`
+	UploaderName string    `json:"uploader,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	Current      bool      `json:"current"`
+}
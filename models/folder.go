@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Folder представляет папку. Path - полный путь от корня ("docs/reports"),
+// файлы внутри папки имеют имена вида "docs/reports/file.txt".
+type Folder struct {
+	ID        int       `json:"id"`
+	Path      string    `json:"path"`
+	Name      string    `json:"name"`
+	OwnerID   int       `json:"owner_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Group представляет группу пользователей, которой можно выдавать права на файлы и папки.
+// Права роли группы добавляются к правам каждого ее участника.
+type Group struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	RoleID    int       `json:"role_id,omitempty"` // 0 - группа без роли
+	RoleName  string    `json:"role,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
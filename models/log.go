@@ -13,10 +13,41 @@ type LogEntry struct {
 
 // LogAction типы действий для логирования
 const (
-	ActionLoginSuccess = "login_success"
-	ActionLoginFailed  = "login_failed"
-	ActionUpload       = "upload"
-	ActionDownload     = "download"
-	ActionCreateUser   = "create_user"
-	ActionDeleteFile   = "delete_file"
+	ActionLoginSuccess  = "login_success"
+	ActionLoginFailed   = "login_failed"
+	ActionUpload        = "upload"
+	ActionDownload      = "download"
+	ActionCreateUser    = "create_user"
+	ActionUpdateUser    = "update_user"
+	ActionDeleteUser    = "delete_user"
+	ActionResetPass     = "reset_password"
+	ActionChangePass    = "change_password"
+	ActionEnable2FA     = "enable_2fa"
+	ActionDisable2FA    = "disable_2fa"
+	ActionSettings      = "update_settings"
+	ActionDisableUser   = "disable_user"
+	ActionEnableUser    = "enable_user"
+	ActionUnlockUser    = "unlock_user"
+	ActionCreateRole    = "create_role"
+	ActionUpdateRole    = "update_role"
+	ActionDeleteRole    = "delete_role"
+	ActionCreateGroup   = "create_group"
+	ActionUpdateGroup   = "update_group"
+	ActionDeleteGroup   = "delete_group"
+	ActionDeleteFile    = "delete_file"
+	ActionRestoreFile   = "restore_version"
+	ActionMoveFile      = "move_file"
+	ActionCreateFolder  = "create_folder"
+	ActionMoveFolder    = "move_folder"
+	ActionDeleteFolder  = "delete_folder"
+	ActionCreateToken   = "create_token"
+	ActionRevokeToken   = "revoke_token"
+	ActionRevokeSession = "revoke_session"
+	ActionCreateShare   = "create_share"
+	ActionRevokeShare   = "revoke_share"
+	ActionGrantAccess   = "grant_access"
+	ActionRevokeAccess  = "revoke_access"
+	// Обращения по публичной ссылке пишутся от имени "share:<id>"
+	ActionShareDownload = "share_download"
+	ActionShareDenied   = "share_denied"
 )
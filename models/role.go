@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Role представляет именованный набор прав. Встроенные роли (downloader, uploader,
+// admin) создаются при миграции и не меняются; остальные администратор заводит сам.
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	CanUpload   bool      `json:"can_upload"`
+	CanDownload bool      `json:"can_download"`
+	IsAdmin     bool      `json:"is_admin"`
+	Builtin     bool      `json:"builtin"`
+	CreatedAt   time.Time `json:"created_at"`
+}
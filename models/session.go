@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session сессия входа в веб-интерфейс. Идентификатор из cookie в БД не хранится,
+// только его SHA-256 хэш.
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	Data       []byte    `json:"-"` // значения gorilla-сессии в кодировке gob
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"` // сессия, из которой сделан запрос
+}
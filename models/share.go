@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Share представляет публичную ссылку на файл для людей без учетной записи.
+// Сам токен ссылки в БД не хранится, только его SHA-256 хэш.
+type Share struct {
+	ID            int        `json:"id"`
+	FileID        int        `json:"file_id"`
+	FileName      string     `json:"file"`
+	OwnerID       int        `json:"owner_id"`
+	OwnerName     string     `json:"owner"`
+	OwnerDisabled bool       `json:"owner_disabled"` // владелец заблокирован или удален, ссылка не работает
+	PasswordHash  string     `json:"-"`
+	HasPassword   bool       `json:"has_password"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads  int        `json:"max_downloads"` // 0 - без ограничения
+	DownloadCount int        `json:"download_count"`
+	CreatedAt     time.Time  `json:"created_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Expired сообщает, истек ли срок действия ссылки
+func (s *Share) Expired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
+}
+
+// Exhausted сообщает, исчерпан ли лимит скачиваний
+func (s *Share) Exhausted() bool {
+	return s.MaxDownloads > 0 && s.DownloadCount >= s.MaxDownloads
+}
+
+// DownloadsLeft возвращает, сколько скачиваний осталось до лимита
+func (s *Share) DownloadsLeft() int {
+	if s.DownloadCount >= s.MaxDownloads {
+		return 0
+	}
+	return s.MaxDownloads - s.DownloadCount
+}
+
+// Active сообщает, можно ли сейчас скачать файл по ссылке
+func (s *Share) Active(now time.Time) bool {
+	return s.RevokedAt == nil && !s.OwnerDisabled && !s.Expired(now) && !s.Exhausted()
+}
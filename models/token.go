@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// APIToken представляет персональный токен доступа для скриптов и CI.
+// Сам токен в БД не хранится, только его SHA-256 хэш.
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Области действия токена
+const (
+	ScopeFull     = "full"     // Те же права, что и у владельца
+	ScopeUpload   = "upload"   // Только загрузка новых файлов и создание папок
+	ScopeDownload = "download" // Только просмотр и скачивание
+)
+
+// ValidTokenScope проверяет, что scope входит в список известных областей
+func ValidTokenScope(scope string) bool {
+	switch scope {
+	case ScopeFull, ScopeUpload, ScopeDownload:
+		return true
+	}
+	return false
+}
+
+// Expired сообщает, истек ли срок действия токена
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// RestrictToScope урезает права пользователя до области действия токена scope; пустая
+// область (вход через браузер) права не меняет. Токен никогда не дает больше прав, чем
+// есть у владельца.
+func RestrictToScope(user *User, scope string) {
+	user.TokenScope = scope
+	switch scope {
+	case ScopeUpload:
+		user.CanDownload = false
+		user.IsAdmin = false
+	case ScopeDownload:
+		user.CanUpload = false
+		user.IsAdmin = false
+	}
+}
@@ -1,15 +1,49 @@
 package models
 
+// User представляет пользователя. CanUpload, CanDownload и IsAdmin - итоговые права:
+// права роли пользователя, объединенные с правами ролей его групп.
 type User struct {
 	ID           int    `json:"id"`
 	Username     string `json:"username"`
-	PasswordHash string `json:"password_hash"` // Мы будем хранить хэш пароля, а не сам пароль!
+	PasswordHash string `json:"-"` // Мы будем хранить хэш пароля, а не сам пароль! В JSON не отдаем
 	CanUpload    bool   `json:"can_upload"`
 	CanDownload  bool   `json:"can_download"`
 	IsAdmin      bool   `json:"is_admin"`
+	RoleID       int    `json:"role_id,omitempty"` // 0 - роль не назначена, действуют собственные флаги
+	Role         string `json:"role,omitempty"`
+	Disabled     bool   `json:"disabled"` // заблокированный пользователь не может войти
+	// MustChangePassword требует сменить пароль, прежде чем работать дальше
+	MustChangePassword bool `json:"must_change_password"`
+	TOTPEnabled        bool `json:"totp_enabled"` // включена двухфакторная аутентификация
+	// AuthSource откуда пользователь: local - пароль хранится у нас, иначе внешний провайдер
+	AuthSource string `json:"auth_source"`
+	// TokenScope область действия API-токена, с которым пришел запрос; пустая при входе
+	// через браузер (см. APIToken.Restrict)
+	TokenScope string `json:"-"`
 }
 
-// Роли для упрощения (можно использовать вместо флагов)
+// CanChange сообщает, можно ли менять то, что уже есть: удалять и переносить файлы и папки,
+// заменять файлы новыми версиями, восстанавливать версии, выдавать доступ и управлять
+// ссылками. Токенам upload и download это запрещено: утекший токен CI не должен позволять
+// стереть или раздать чужие файлы.
+func (u *User) CanChange() bool {
+	return u.TokenScope == "" || u.TokenScope == ScopeFull
+}
+
+// Источники учетных записей
+const (
+	AuthSourceLocal = "local" // Пароль проверяется по хэшу в таблице users
+	AuthSourceLDAP  = "ldap"  // Пароль проверяет каталог LDAP/Active Directory
+	AuthSourceOIDC  = "oidc"  // Вход через провайдера OpenID Connect, пароля у нас нет
+)
+
+// HasPassword сообщает, входит ли пользователь с паролем. У пользователей единого входа
+// пароля нет, и подтверждать действия паролем они не могут.
+func (u *User) HasPassword() bool {
+	return u.AuthSource != AuthSourceOIDC
+}
+
+// Встроенные роли, создаются при первом запуске
 const (
 	RoleDownloader = "downloader" // Может только скачивать
 	RoleUploader   = "uploader"   // Может и скачивать, и загружать
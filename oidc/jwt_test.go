@@ -0,0 +1,212 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer = "https://issuer.example"
+	testClient = "file-exchange"
+	testNonce  = "nonce-1"
+)
+
+// testKeys ключи подписи тестового провайдера
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rsaKey, ec: ecKey}
+}
+
+// provider создает провайдер с уже загруженными ключами, чтобы проверка не ходила в сеть
+func (k testKeys) provider() *Provider {
+	p := NewProvider(Config{Issuer: testIssuer, ClientID: testClient}, nil)
+	p.keys = keySet{"rsa": &k.rsa.PublicKey, "ec": &k.ec.PublicKey}
+	p.keysAt = time.Now()
+	return p
+}
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign собирает JWT с заголовком header и подписывает его по header["alg"]
+func (k testKeys) sign(t *testing.T, header map[string]string, claims map[string]interface{}) string {
+	t.Helper()
+	signed := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	switch header["alg"] {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+	case "PS256":
+		signature, err = rsa.SignPSS(rand.Reader, k.rsa, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256":
+		r, s, signErr := ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		err = signErr
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case "HS256":
+		// Открытый ключ RSA в роли секрета HMAC - классическая подмена алгоритма
+		mac := hmac.New(sha256.New, k.rsa.PublicKey.N.Bytes())
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   testIssuer,
+		"aud":   testClient,
+		"sub":   "user-1",
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"iat":   now.Unix(),
+		"nonce": testNonce,
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	keys := newTestKeys(t)
+	p := keys.provider()
+	now := time.Now()
+	rs256 := map[string]string{"alg": "RS256", "kid": "rsa"}
+
+	with := func(changes map[string]interface{}) map[string]interface{} {
+		claims := validClaims(now)
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		nonce  string
+		wantOK bool
+	}{
+		{"RS256", keys.sign(t, rs256, validClaims(now)), testNonce, true},
+		{"PS256", keys.sign(t, map[string]string{"alg": "PS256", "kid": "rsa"}, validClaims(now)), testNonce, true},
+		{"ES256", keys.sign(t, map[string]string{"alg": "ES256", "kid": "ec"}, validClaims(now)), testNonce, true},
+		{"issuer with trailing slash", keys.sign(t, rs256, with(map[string]interface{}{"iss": testIssuer + "/"})), testNonce, true},
+		{"audience list", keys.sign(t, rs256, with(map[string]interface{}{"aud": []string{"other", testClient}})), testNonce, true},
+		{"expired within clock skew", keys.sign(t, rs256, with(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), testNonce, true},
+
+		{"alg none", encodeSegment(t, map[string]string{"alg": "none", "kid": "rsa"}) + "." + encodeSegment(t, validClaims(now)) + ".", testNonce, false},
+		{"alg HS256", keys.sign(t, map[string]string{"alg": "HS256", "kid": "rsa"}, validClaims(now)), testNonce, false},
+		{"alg does not match key", keys.sign(t, map[string]string{"alg": "RS256", "kid": "ec"}, validClaims(now)), testNonce, false},
+		{"unknown kid", keys.sign(t, map[string]string{"alg": "RS256", "kid": "rotated"}, validClaims(now)), testNonce, false},
+		{"wrong issuer", keys.sign(t, rs256, with(map[string]interface{}{"iss": "https://evil.example"})), testNonce, false},
+		{"missing issuer", keys.sign(t, rs256, with(map[string]interface{}{"iss": nil})), testNonce, false},
+		{"wrong audience", keys.sign(t, rs256, with(map[string]interface{}{"aud": "other"})), testNonce, false},
+		{"authorized for another party", keys.sign(t, rs256, with(map[string]interface{}{"aud": []string{testClient, "other"}, "azp": "other"})), testNonce, false},
+		{"expired", keys.sign(t, rs256, with(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), testNonce, false},
+		{"missing exp", keys.sign(t, rs256, with(map[string]interface{}{"exp": nil})), testNonce, false},
+		{"wrong nonce", keys.sign(t, rs256, validClaims(now)), "nonce-2", false},
+		{"missing nonce", keys.sign(t, rs256, with(map[string]interface{}{"nonce": nil})), testNonce, false},
+		{"missing subject", keys.sign(t, rs256, with(map[string]interface{}{"sub": nil})), testNonce, false},
+		{"malformed", "not-a-jwt", testNonce, false},
+	}
+	for _, tt := range tests {
+		claims, err := p.verifyIDToken(context.Background(), tt.token, tt.nonce, now)
+		switch {
+		case tt.wantOK && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.wantOK && claims.String("sub") != "user-1":
+			t.Errorf("%s: sub = %q", tt.name, claims.String("sub"))
+		case !tt.wantOK && !errors.Is(err, ErrInvalidToken):
+			t.Errorf("%s: error %v, want ErrInvalidToken", tt.name, err)
+		}
+	}
+}
+
+func TestVerifyIDTokenTampered(t *testing.T) {
+	keys := newTestKeys(t)
+	p := keys.provider()
+	now := time.Now()
+	token := keys.sign(t, map[string]string{"alg": "RS256", "kid": "rsa"}, validClaims(now))
+
+	parts := strings.Split(token, ".")
+	claims := validClaims(now)
+	claims["sub"] = "admin"
+	parts[1] = encodeSegment(t, claims)
+	if _, err := p.verifyIDToken(context.Background(), strings.Join(parts, "."), testNonce, now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token with changed claims: error %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestClaimsLookup(t *testing.T) {
+	var claims Claims
+	if err := json.Unmarshal([]byte(`{
+		"preferred_username": "alice",
+		"groups": ["admins", 7, "staff"],
+		"role": "uploader",
+		"realm_access": {"roles": ["file-exchange-admin"]},
+		"dotted.name": "direct"
+	}`), &claims); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"groups", []string{"admins", "staff"}},
+		{"role", []string{"uploader"}},
+		{"realm_access.roles", []string{"file-exchange-admin"}},
+		{"dotted.name", []string{"direct"}},
+		{"missing", nil},
+		{"realm_access.missing.roles", nil},
+	}
+	for _, tt := range tests {
+		if got := claims.Strings(tt.name); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("Strings(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := claims.String("preferred_username"); got != "alice" {
+		t.Errorf("String(preferred_username) = %q", got)
+	}
+	if got := claims.String("groups"); got != "" {
+		t.Errorf("String(groups) = %q, want empty for a list", got)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"file-exchange-app/config"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certReloader хранит текущий TLS-сертификат. Новые соединения получают сертификат,
+// перечитанный по SIGHUP, уже открытые продолжают работать со старым.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// newCertReloader загружает сертификат; ошибка при старте не дает запустить сервер
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	certs := &certReloader{certFile: certFile, keyFile: keyFile}
+	return certs, certs.reload()
+}
+
+// reload перечитывает сертификат и ключ; при ошибке остается прежний сертификат
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load TLS certificate: %w", err)
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+// getCertificate используется как tls.Config.GetCertificate
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// httpsRedirect перенаправляет запросы на тот же адрес по HTTPS на порт основного сервера
+func httpsRedirect(tlsListen string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsListen)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		// 308 сохраняет метод и тело, так что формы тоже уходят на HTTPS
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// serve запускает HTTP(S)-сервер и работает до SIGINT или SIGTERM. После сигнала новые
+// соединения не принимаются, а активные запросы (в том числе загрузки) получают
+// server.shutdown_timeout на завершение. SIGHUP перечитывает TLS-сертификат.
+func serve(cfg *config.Config, handler http.Handler) error {
+	server := &http.Server{
+		Addr:              cfg.Server.Listen,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	servers := []*http.Server{server}
+
+	var certs *certReloader
+	if cfg.TLS.Enabled() {
+		var err error
+		certs, err = newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certs.getCertificate}
+	}
+	if cfg.TLS.RedirectListen != "" {
+		servers = append(servers, &http.Server{
+			Addr:              cfg.TLS.RedirectListen,
+			Handler:           httpsRedirect(cfg.Server.Listen),
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+			IdleTimeout:       cfg.Server.IdleTimeout,
+		})
+	}
+
+	// Подписываемся на сигналы до старта, чтобы не потерять ранний SIGTERM
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		srv := srv
+		go func() {
+			var err error
+			if srv.TLSConfig != nil {
+				log.Printf("Server starting on %s (HTTPS)...", srv.Addr)
+				err = srv.ListenAndServeTLS("", "")
+			} else if srv == server {
+				log.Printf("Server starting on %s...", srv.Addr)
+				err = srv.ListenAndServe()
+			} else {
+				log.Printf("Redirecting HTTP on %s to HTTPS", srv.Addr)
+				err = srv.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+	}
+
+	for {
+		select {
+		case err := <-errs:
+			shutdown(servers, 0)
+			return err
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				log.Printf("Received %s, finishing active requests (up to %s)...", sig, cfg.Server.ShutdownTimeout)
+				shutdown(servers, cfg.Server.ShutdownTimeout)
+				return nil
+			}
+			if certs == nil {
+				log.Println("Received SIGHUP, but TLS is not enabled")
+				continue
+			}
+			if err := certs.reload(); err != nil {
+				log.Printf("Keeping the current TLS certificate: %v", err)
+				continue
+			}
+			log.Println("TLS certificate reloaded")
+		}
+	}
+}
+
+// shutdown останавливает серверы, дожидаясь активных запросов не дольше timeout.
+// Не успевшие завершиться соединения закрываются, и это штатная остановка, а не ошибка:
+// tus-загрузки потом можно продолжить.
+func shutdown(servers []*http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+				log.Printf("%s: closed active connections that did not finish in time: %v", srv.Addr, err)
+			}
+		}(srv)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,17 @@
+// Выбор файлов для скачивания архивом: флажок в заголовке таблицы отмечает все файлы папки
+document.addEventListener('DOMContentLoaded', function() {
+    const selectAll = document.querySelector('.select-all');
+    if (!selectAll) {
+        return;
+    }
+    const boxes = () => document.querySelectorAll('input[name="file"][form="archive-form"]');
+
+    selectAll.addEventListener('change', function() {
+        boxes().forEach(box => { box.checked = selectAll.checked; });
+    });
+    boxes().forEach(function(box) {
+        box.addEventListener('change', function() {
+            selectAll.checked = Array.from(boxes()).every(b => b.checked);
+        });
+    });
+});
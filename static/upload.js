@@ -1,92 +1,328 @@
-document.addEventListener('DOMContentLoaded', function() {
-    const uploadForm = document.querySelector('form[enctype="multipart/form-data"]');
-    
-    if (uploadForm) {
-        uploadForm.addEventListener('submit', function(e) {
-            e.preventDefault();
-            
-            const fileInput = this.querySelector('input[type="file"]');
-            const button = this.querySelector('button[type="submit"]');
-            const originalButtonText = button.textContent;
-            
-            if (!fileInput.files.length) {
-                alert('Please select a file to upload');
-                return;
+// Загрузка файлов по протоколу tus 1.0: файл отправляется кусками,
+// а после обрыва связи загрузка продолжается с последнего подтвержденного байта.
+const TUS_ENDPOINT = '/api/v1/tus';
+const TUS_VERSION = '1.0.0';
+const CHUNK_SIZE = 8 * 1024 * 1024; // 8MB за один PATCH
+const RETRY_DELAYS = [1000, 3000, 5000, 10000, 20000, 30000]; // Паузы между попытками, мс
+const PARALLEL_UPLOADS = 3; // Сколько файлов очереди загружается одновременно
+
+// Ключ, под которым адрес незавершенной загрузки хранится в localStorage
+function uploadFingerprint(file, name, folder) {
+    return 'tus::' + folder + '::' + name + '::' + file.size + '::' + file.lastModified;
+}
+
+// Кодирует строку UTF-8 в base64 для заголовка Upload-Metadata
+function encodeMetadata(value) {
+    const bytes = new TextEncoder().encode(value);
+    let binary = '';
+    bytes.forEach(b => { binary += String.fromCharCode(b); });
+    return btoa(binary);
+}
+
+// CSRF-токен страницы: сервер требует его у всех запросов, меняющих состояние
+function csrfToken() {
+    const input = document.querySelector('input[name="csrf_token"]');
+    return input ? input.value : '';
+}
+
+// Выполняет XHR-запрос к tus-серверу и возвращает Promise с объектом XHR
+function tusRequest(method, url, headers, body, onProgress) {
+    return new Promise(function(resolve, reject) {
+        const xhr = new XMLHttpRequest();
+        xhr.open(method, url);
+        xhr.setRequestHeader('Tus-Resumable', TUS_VERSION);
+        xhr.setRequestHeader('X-CSRF-Token', csrfToken());
+        Object.keys(headers).forEach(name => xhr.setRequestHeader(name, headers[name]));
+
+        if (onProgress) {
+            xhr.upload.addEventListener('progress', e => onProgress(e.loaded));
+        }
+        xhr.addEventListener('load', () => resolve(xhr));
+        xhr.addEventListener('error', () => reject(new Error('Network error')));
+        xhr.addEventListener('abort', () => reject(new Error('Upload aborted')));
+        xhr.send(body);
+    });
+}
+
+// Создает новую загрузку на сервере и возвращает ее адрес. name - имя файла или путь
+// внутри загружаемой папки. onConflict - что делать, если файл уже есть: overwrite, rename или reject.
+async function createUpload(file, name, folder, onConflict) {
+    let metadata = 'filename ' + encodeMetadata(name);
+    if (folder) {
+        metadata += ',folder ' + encodeMetadata(folder);
+    }
+    if (onConflict) {
+        metadata += ',on_conflict ' + encodeMetadata(onConflict);
+    }
+    const xhr = await tusRequest('POST', TUS_ENDPOINT, {
+        'Upload-Length': String(file.size),
+        'Upload-Metadata': metadata
+    }, null);
+
+    if (xhr.status !== 201) {
+        throw new Error(xhr.responseText || xhr.statusText);
+    }
+    return xhr.getResponseHeader('Location');
+}
+
+// Узнает у сервера, сколько байт уже принято. Возвращает null, если загрузки больше нет.
+async function fetchOffset(url) {
+    const xhr = await tusRequest('HEAD', url, {}, null);
+    if (xhr.status === 404 || xhr.status === 410) {
+        return null;
+    }
+    if (xhr.status !== 200) {
+        throw new Error(xhr.statusText);
+    }
+    return parseInt(xhr.getResponseHeader('Upload-Offset'), 10);
+}
+
+// Загружает файл целиком под именем name в папку folder, продолжая ранее прерванную
+// загрузку, если она есть
+async function tusUpload(file, name, folder, onConflict, onProgress) {
+    const key = uploadFingerprint(file, name, folder);
+    let url = localStorage.getItem(key);
+    let offset = null;
+
+    if (url) {
+        offset = await fetchOffset(url);
+    }
+    if (offset === null) {
+        url = await createUpload(file, name, folder, onConflict);
+        localStorage.setItem(key, url);
+        offset = 0;
+    }
+    onProgress(offset);
+
+    let attempt = 0;
+    while (offset < file.size) {
+        const chunk = file.slice(offset, offset + CHUNK_SIZE);
+        try {
+            const xhr = await tusRequest('PATCH', url, {
+                'Content-Type': 'application/offset+octet-stream',
+                'Upload-Offset': String(offset)
+            }, chunk, loaded => onProgress(offset + loaded));
+
+            if (xhr.status === 204) {
+                offset = parseInt(xhr.getResponseHeader('Upload-Offset'), 10);
+                attempt = 0;
+                onProgress(offset);
+                continue;
             }
-            
-            const formData = new FormData();
-            formData.append('file', fileInput.files[0]);
-            
-            // Показываем индикатор загрузки
-            button.disabled = true;
-            button.textContent = 'Uploading...';
-            
-            // Создаем прогресс-бар если его нет
-            let progressBar = this.querySelector('.upload-progress');
-            if (!progressBar) {
-                progressBar = document.createElement('div');
-                progressBar.className = 'upload-progress';
-                progressBar.innerHTML = `
-                    <div class="progress-container">
-                        <div class="progress-bar"></div>
-                        <div class="progress-text">0%</div>
-                    </div>
-                `;
-                this.appendChild(progressBar);
+            if (xhr.status === 409) {
+                // Сервер принял не столько, сколько мы думали: спрашиваем актуальный offset
+                offset = await fetchOffset(url);
+                continue;
             }
-            
-            const xhr = new XMLHttpRequest();
-            
-            // Отслеживаем прогресс загрузки
-            xhr.upload.addEventListener('progress', function(e) {
-                if (e.lengthComputable) {
-                    const percentComplete = (e.loaded / e.total) * 100;
-                    const progressBarElement = progressBar.querySelector('.progress-bar');
-                    const progressText = progressBar.querySelector('.progress-text');
-                    
-                    progressBarElement.style.width = percentComplete + '%';
-                    progressText.textContent = Math.round(percentComplete) + '%';
-                }
-            });
-            
-            xhr.addEventListener('load', function() {
-                if (xhr.status === 200) {
-                    // Успешная загрузка
-                    progressBar.querySelector('.progress-text').textContent = 'Upload complete!';
-                    setTimeout(() => {
-                        // Перезагружаем страницу чтобы показать новый файл
-                        window.location.reload();
-                    }, 1000);
-                } else {
-                    // Ошибка загрузки
-                    alert('Upload failed: ' + xhr.statusText);
-                    button.disabled = false;
-                    button.textContent = originalButtonText;
-                    progressBar.style.display = 'none';
+            if (xhr.status < 500) {
+                localStorage.removeItem(key);
+                throw new Error(xhr.responseText || xhr.statusText);
+            }
+        } catch (err) {
+            if (err.message !== 'Network error') {
+                throw err;
+            }
+        }
+
+        if (attempt >= RETRY_DELAYS.length) {
+            throw new Error('Upload interrupted, please try again to resume');
+        }
+
+        // Сетевой сбой или ошибка сервера: ждем и продолжаем с подтвержденного offset
+        await new Promise(resolve => setTimeout(resolve, RETRY_DELAYS[attempt]));
+        attempt++;
+        const serverOffset = await fetchOffset(url).catch(() => offset);
+        if (serverOffset === null) {
+            localStorage.removeItem(key);
+            throw new Error('Upload expired on the server, please start again');
+        }
+        offset = serverOffset;
+    }
+
+    localStorage.removeItem(key);
+}
+
+// Читает все записи каталога: readEntries отдает их порциями, пока не вернет пустой список
+function readAllEntries(reader) {
+    return new Promise(function(resolve, reject) {
+        const entries = [];
+        (function next() {
+            reader.readEntries(function(batch) {
+                if (!batch.length) {
+                    resolve(entries);
+                    return;
                 }
-            });
-            
-            xhr.addEventListener('error', function() {
-                alert('Upload failed. Please try again.');
-                button.disabled = false;
-                button.textContent = originalButtonText;
-                progressBar.style.display = 'none';
-            });
-            
-            xhr.open('POST', this.action);
-            xhr.send(formData);
-        });
+                entries.push(...batch);
+                next();
+            }, reject);
+        })();
+    });
+}
+
+// Собирает файлы из перетащенной записи; для папки - рекурсивно, с путем внутри нее
+async function collectEntry(entry, files) {
+    if (entry.isFile) {
+        const file = await new Promise((resolve, reject) => entry.file(resolve, reject));
+        files.push({file: file, name: entry.fullPath.replace(/^\//, '')});
+        return;
+    }
+    if (entry.isDirectory) {
+        const children = await readAllEntries(entry.createReader());
+        for (const child of children) {
+            await collectEntry(child, files);
+        }
+    }
+}
+
+// Возвращает файлы, перетащенные на страницу, вместе с содержимым папок
+async function droppedFiles(dataTransfer) {
+    const files = [];
+    // Записи нужно получить сразу: после первого await список items уже недоступен
+    const entries = Array.from(dataTransfer.items || [])
+        .map(item => item.webkitGetAsEntry ? item.webkitGetAsEntry() : null);
+    if (!entries.some(entry => entry)) {
+        Array.from(dataTransfer.files).forEach(file => files.push({file: file, name: file.name}));
+        return files;
+    }
+    for (const entry of entries) {
+        if (entry) {
+            await collectEntry(entry, files);
+        }
     }
-    
-    // Добавляем валидацию размера файла
-    const fileInputs = document.querySelectorAll('input[type="file"]');
-    fileInputs.forEach(input => {
-        input.addEventListener('change', function() {
-            const maxSize = 100 * 1024 * 1024; // 100MB в байтах
-            if (this.files[0] && this.files[0].size > maxSize) {
-                alert('File size exceeds the maximum limit of 100MB');
-                this.value = '';
+    return files;
+}
+
+// Добавляет в форму строку очереди с именем файла и прогресс-баром
+function addQueueItem(list, name) {
+    const item = document.createElement('div');
+    item.className = 'upload-item';
+    item.innerHTML = `
+        <div class="upload-item-name"></div>
+        <div class="progress-container">
+            <div class="progress-bar"></div>
+            <div class="progress-text">waiting</div>
+        </div>
+    `;
+    item.querySelector('.upload-item-name').textContent = name;
+    list.appendChild(item);
+    return {
+        bar: item.querySelector('.progress-bar'),
+        text: item.querySelector('.progress-text'),
+        fail: function(message) {
+            item.classList.add('failed');
+            this.bar.style.width = '100%';
+            this.text.textContent = message;
+        }
+    };
+}
+
+// Загружает файлы очередью, не больше PARALLEL_UPLOADS одновременно, показывая прогресс
+// каждого. Возвращает число файлов, которые не удалось загрузить.
+async function uploadQueue(form, files) {
+    const folderInput = form.querySelector('input[name="folder"]');
+    const folder = folderInput ? folderInput.value : '';
+    const conflictInput = form.querySelector('select[name="on_conflict"]');
+    const onConflict = conflictInput ? conflictInput.value : '';
+
+    let list = form.querySelector('.upload-progress');
+    if (!list) {
+        list = document.createElement('div');
+        list.className = 'upload-progress';
+        form.appendChild(list);
+    }
+    list.innerHTML = '';
+
+    const queue = files.map(entry => Object.assign({row: addQueueItem(list, entry.name)}, entry));
+    let failed = 0;
+
+    async function worker() {
+        while (queue.length) {
+            const {file, name, row} = queue.shift();
+            // Отслеживаем прогресс загрузки
+            const updateProgress = function(sent) {
+                const percentComplete = file.size ? (sent / file.size) * 100 : 100;
+                row.bar.style.width = percentComplete + '%';
+                row.text.textContent = Math.round(percentComplete) + '%';
+            };
+            try {
+                await tusUpload(file, name, folder, onConflict, updateProgress);
+                row.text.textContent = 'done';
+            } catch (err) {
+                // Незавершенная загрузка сохранена: повторный выбор того же файла
+                // продолжит ее с места обрыва
+                failed++;
+                row.fail(err.message);
             }
+        }
+    }
+
+    const workers = [];
+    for (let i = 0; i < Math.min(PARALLEL_UPLOADS, queue.length); i++) {
+        workers.push(worker());
+    }
+    await Promise.all(workers);
+    return failed;
+}
+
+document.addEventListener('DOMContentLoaded', function() {
+    const uploadForm = document.querySelector('form[enctype="multipart/form-data"]');
+    if (!uploadForm) {
+        return;
+    }
+
+    const button = uploadForm.querySelector('button[type="submit"]');
+    const originalButtonText = button.textContent;
+    let busy = false;
+
+    // Загружает выбранные или перетащенные файлы; если все прошло успешно,
+    // перезагружает страницу, чтобы показать новые файлы
+    async function start(files) {
+        if (busy) {
+            return;
+        }
+        if (!files.length) {
+            alert('Please select files to upload');
+            return;
+        }
+        busy = true;
+        button.disabled = true;
+        button.textContent = 'Uploading...';
+
+        const failed = await uploadQueue(uploadForm, files);
+        if (!failed) {
+            setTimeout(() => window.location.reload(), 1000);
+            return;
+        }
+        alert(`${failed} of ${files.length} file(s) failed to upload`);
+        busy = false;
+        button.disabled = false;
+        button.textContent = originalButtonText;
+    }
+
+    uploadForm.addEventListener('submit', function(e) {
+        e.preventDefault();
+        const files = [];
+        this.querySelectorAll('input[type="file"]').forEach(function(input) {
+            // У файлов из выбранной папки webkitRelativePath - путь внутри нее
+            Array.from(input.files).forEach(file => files.push({file: file, name: file.webkitRelativePath || file.name}));
         });
+        start(files);
+    });
+
+    // Файлы и папки можно перетащить в блок загрузки
+    const dropZone = uploadForm.closest('.drop-zone') || uploadForm;
+    dropZone.addEventListener('dragover', function(e) {
+        e.preventDefault();
+        dropZone.classList.add('drag-over');
+    });
+    dropZone.addEventListener('dragleave', function(e) {
+        if (!dropZone.contains(e.relatedTarget)) {
+            dropZone.classList.remove('drag-over');
+        }
+    });
+    dropZone.addEventListener('drop', function(e) {
+        e.preventDefault();
+        dropZone.classList.remove('drag-over');
+        droppedFiles(e.dataTransfer).then(start).catch(err => alert('Could not read dropped files: ' + err.message));
     });
-});
\ No newline at end of file
+});
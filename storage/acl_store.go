@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"file-exchange-app/models"
+	"fmt"
+	"time"
+)
+
+// ErrACLEntryNotFound возвращается, если записи списка доступа нет
+var ErrACLEntryNotFound = errors.New("access entry not found")
+
+// ACLStore представляет интерфейс для работы со списками доступа к файлам и папкам
+type ACLStore interface {
+	// Grant выдает право; если у субъекта уже есть запись на этот ресурс, уровень заменяется
+	Grant(resourceType string, resourceID int, principalType string, principalID int, permission string) (*models.ACLEntry, error)
+	GetEntry(entryID int) (*models.ACLEntry, error)
+	ListEntries(resourceType string, resourceID int) ([]models.ACLEntry, error)
+	Revoke(entryID int) error
+	// AccessFor сводит права пользователя: собственные записи, записи его групп и владение
+	AccessFor(user *models.User) (*models.Access, error)
+}
+
+// SQLiteACLStore реализация ACLStore для SQLite
+type SQLiteACLStore struct {
+	db *sql.DB
+}
+
+// NewACLStore создает новый экземпляр ACLStore
+func NewACLStore(db *sql.DB) ACLStore {
+	return &SQLiteACLStore{db: db}
+}
+
+// aclColumns столбцы для scanACLEntry. Путь и имя субъекта берутся из связанных таблиц,
+// поэтому записи переживают переименование файлов и папок.
+const aclColumns = `a.id, a.resource_type, a.resource_id,
+	CASE a.resource_type WHEN 'file' THEN COALESCE(f.original_name, '') ELSE COALESCE(d.path, '') END,
+	a.principal_type, a.principal_id,
+	CASE a.principal_type WHEN 'user' THEN COALESCE(u.username, '') ELSE COALESCE(g.name, '') END,
+	a.permission, a.created_at`
+
+// aclJoins соединения для aclColumns
+const aclJoins = ` FROM acl_entries a
+	LEFT JOIN files f ON a.resource_type = 'file' AND f.id = a.resource_id
+	LEFT JOIN folders d ON a.resource_type = 'folder' AND d.id = a.resource_id
+	LEFT JOIN users u ON a.principal_type = 'user' AND u.id = a.principal_id
+	LEFT JOIN groups g ON a.principal_type = 'group' AND g.id = a.principal_id`
+
+// scanACLEntry читает строку со столбцами aclColumns
+func scanACLEntry(row interface{ Scan(...interface{}) error }) (*models.ACLEntry, error) {
+	var entry models.ACLEntry
+	err := row.Scan(&entry.ID, &entry.ResourceType, &entry.ResourceID, &entry.Path,
+		&entry.PrincipalType, &entry.PrincipalID, &entry.PrincipalName, &entry.Permission, &entry.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Grant выдает или меняет право субъекта на ресурс
+func (s *SQLiteACLStore) Grant(resourceType string, resourceID int, principalType string, principalID int, permission string) (*models.ACLEntry, error) {
+	if models.PermissionLevel(permission) == 0 {
+		return nil, fmt.Errorf("invalid permission: %s", permission)
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO acl_entries (resource_type, resource_id, principal_type, principal_id, permission, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (resource_type, resource_id, principal_type, principal_id) DO UPDATE SET permission = excluded.permission`,
+		resourceType, resourceID, principalType, principalID, permission, time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	entry, err := scanACLEntry(s.db.QueryRow(
+		"SELECT "+aclColumns+aclJoins+" WHERE a.resource_type = ? AND a.resource_id = ? AND a.principal_type = ? AND a.principal_id = ?",
+		resourceType, resourceID, principalType, principalID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return entry, nil
+}
+
+// GetEntry возвращает запись по ID
+func (s *SQLiteACLStore) GetEntry(entryID int) (*models.ACLEntry, error) {
+	entry, err := scanACLEntry(s.db.QueryRow("SELECT "+aclColumns+aclJoins+" WHERE a.id = ?", entryID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrACLEntryNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return entry, nil
+}
+
+// ListEntries возвращает записи, выданные непосредственно на ресурс (без унаследованных)
+func (s *SQLiteACLStore) ListEntries(resourceType string, resourceID int) ([]models.ACLEntry, error) {
+	rows, err := s.db.Query(
+		"SELECT "+aclColumns+aclJoins+" WHERE a.resource_type = ? AND a.resource_id = ? ORDER BY a.principal_type, a.id",
+		resourceType, resourceID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.ACLEntry
+	for rows.Next() {
+		entry, err := scanACLEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan access entry: %w", err)
+		}
+		entries = append(entries, *entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Revoke удаляет запись
+func (s *SQLiteACLStore) Revoke(entryID int) error {
+	result, err := s.db.Exec("DELETE FROM acl_entries WHERE id = ?", entryID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrACLEntryNotFound)
+}
+
+// AccessFor собирает все права пользователя одним запросом
+func (s *SQLiteACLStore) AccessFor(user *models.User) (*models.Access, error) {
+	if user.IsAdmin {
+		return &models.Access{All: true}, nil
+	}
+
+	// Записи на пользователя и на его группы; корень (папка с ID 0) соединять не с чем
+	principal := `(a.principal_type = 'user' AND a.principal_id = ?)
+		OR (a.principal_type = 'group' AND a.principal_id IN (SELECT group_id FROM group_members WHERE user_id = ?))`
+
+	rows, err := s.db.Query(`
+		SELECT f.original_name, 0, a.permission FROM acl_entries a
+		JOIN files f ON a.resource_type = 'file' AND f.id = a.resource_id
+		WHERE `+principal+`
+		UNION ALL
+		SELECT d.path, 1, a.permission FROM acl_entries a
+		JOIN folders d ON a.resource_type = 'folder' AND d.id = a.resource_id
+		WHERE `+principal+`
+		UNION ALL
+		SELECT '', 1, a.permission FROM acl_entries a
+		WHERE a.resource_type = 'folder' AND a.resource_id = 0 AND (`+principal+`)
+		UNION ALL
+		SELECT original_name, 0, 'manage' FROM files WHERE owner_id = ?
+		UNION ALL
+		SELECT path, 1, 'manage' FROM folders WHERE owner_id = ?`,
+		user.ID, user.ID, user.ID, user.ID, user.ID, user.ID, user.ID, user.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	access := &models.Access{}
+	for rows.Next() {
+		var grant models.PathGrant
+		var permission string
+		if err := rows.Scan(&grant.Path, &grant.Folder, &permission); err != nil {
+			return nil, fmt.Errorf("failed to scan access entry: %w", err)
+		}
+		grant.Level = models.PermissionLevel(permission)
+		access.Grants = append(access.Grants, grant)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return access, nil
+}
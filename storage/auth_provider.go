@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"errors"
+	"file-exchange-app/config"
+	"file-exchange-app/models"
+	"log"
+)
+
+// ErrNotPermitted внешний провайдер подтвердил учетные данные, но вход этому пользователю не разрешен
+var ErrNotPermitted = errors.New("account is not permitted to sign in")
+
+// AuthProvider проверяет логин и пароль. Провайдеры, кроме локального, при успешном входе
+// создают или обновляют пользователя в таблице users.
+type AuthProvider interface {
+	// Name источник учетных записей, который провайдер записывает в users.auth_source
+	Name() string
+	// Authenticate возвращает пользователя либо ErrInvalidCredentials, ErrUserDisabled или ErrNotPermitted
+	Authenticate(username, password string) (*models.User, error)
+}
+
+// AuthenticatorInstance проверяет учетные данные при входе, настраивается в InitAuthProviders
+var AuthenticatorInstance *Authenticator
+
+// localAuthProvider проверяет пароль по хэшу в таблице users
+type localAuthProvider struct {
+	users UserStore
+}
+
+// NewLocalAuthProvider создает провайдер для локальных учетных записей
+func NewLocalAuthProvider(users UserStore) AuthProvider {
+	return &localAuthProvider{users: users}
+}
+
+func (p *localAuthProvider) Name() string {
+	return models.AuthSourceLocal
+}
+
+func (p *localAuthProvider) Authenticate(username, password string) (*models.User, error) {
+	return p.users.VerifyUserCredentials(username, password)
+}
+
+// Authenticator выбирает провайдер для входа. Известного пользователя проверяет провайдер,
+// из которого он пришел, поэтому запись каталога не может войти под именем локального
+// пользователя и наоборот. Неизвестного пользователя по очереди пробуют внешние провайдеры.
+type Authenticator struct {
+	users     UserStore
+	providers []AuthProvider
+}
+
+// NewAuthenticator создает Authenticator с локальным провайдером и внешними провайдерами external
+func NewAuthenticator(users UserStore, external ...AuthProvider) *Authenticator {
+	providers := append([]AuthProvider{NewLocalAuthProvider(users)}, external...)
+	return &Authenticator{users: users, providers: providers}
+}
+
+// provider возвращает провайдер по источнику учетной записи
+func (a *Authenticator) provider(source string) AuthProvider {
+	for _, provider := range a.providers {
+		if provider.Name() == source {
+			return provider
+		}
+	}
+	return nil
+}
+
+// Authenticate проверяет логин и пароль
+func (a *Authenticator) Authenticate(username, password string) (*models.User, error) {
+	user, err := a.users.GetUserByUsername(username)
+	if err == nil {
+		provider := a.provider(user.AuthSource)
+		if provider == nil {
+			// Провайдер, создавший пользователя, отключен в настройках
+			return nil, ErrInvalidCredentials
+		}
+		return provider.Authenticate(username, password)
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+
+	for _, provider := range a.providers {
+		if provider.Name() == models.AuthSourceLocal {
+			continue
+		}
+		user, err := provider.Authenticate(username, password)
+		if errors.Is(err, ErrInvalidCredentials) {
+			continue
+		}
+		return user, err
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// InitAuthProviders настраивает провайдеры входа. Локальные учетные записи работают всегда;
+// вход через каталог включается параметром ldap.url, единый вход через OpenID Connect -
+// параметром oidc.issuer.
+func InitAuthProviders(ldapCfg config.LDAPConfig, oidcCfg config.OIDCConfig) error {
+	var external []AuthProvider
+
+	if ldapCfg.Enabled() {
+		ldapConfig, err := newLDAPConfig(ldapCfg)
+		if err != nil {
+			return err
+		}
+		if err := ldapConfig.checkRoles(RoleStoreInstance); err != nil {
+			return err
+		}
+		external = append(external, NewLDAPAuthProvider(ldapConfig, UserStoreInstance, RoleStoreInstance))
+		log.Printf("LDAP authentication enabled: %s", ldapConfig.URL)
+	}
+
+	AuthenticatorInstance = NewAuthenticator(UserStoreInstance, external...)
+
+	if oidcCfg.Enabled() {
+		oidcConfig := newOIDCConfig(oidcCfg)
+		OIDCSignInInstance = NewOIDCSignIn(oidcConfig, UserStoreInstance)
+		log.Printf("OIDC single sign-on enabled: %s", oidcConfig.Issuer)
+	}
+	return nil
+}
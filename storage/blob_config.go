@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"file-exchange-app/config"
+	"fmt"
+)
+
+// BlobStoreInstance хранилище содержимого файлов, выбранное при старте
+var BlobStoreInstance BlobStore
+
+// InitBlobStore выбирает хранилище файлов: "local" хранит файлы в cfg.LocalDir,
+// "s3" - в S3-совместимом хранилище с параметрами cfg.S3
+func InitBlobStore(cfg config.StorageConfig) error {
+	var err error
+	switch cfg.Backend {
+	case "local":
+		BlobStoreInstance, err = NewLocalBlobStore(cfg.LocalDir)
+	case "s3":
+		BlobStoreInstance, err = NewS3BlobStore(S3Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Bucket:    cfg.S3.Bucket,
+			Region:    cfg.S3.Region,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			UseSSL:    cfg.S3.UseSSL,
+			Prefix:    cfg.S3.Prefix,
+		})
+	default:
+		return fmt.Errorf("unknown storage backend: %s", cfg.Backend)
+	}
+	return err
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrBlobNotFound возвращается, если объекта с таким ключом нет в хранилище
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobInfo описывает объект в хранилище файлов
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStore представляет интерфейс хранилища содержимого файлов.
+// Ключи - относительные пути с разделителем "/".
+type BlobStore interface {
+	// Put сохраняет объект; size равен -1, если размер заранее неизвестен
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get открывает объект начиная с offset; length равен -1, чтобы читать до конца
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*BlobInfo, error)
+	// List возвращает все объекты, ключи которых начинаются с prefix
+	List(ctx context.Context, prefix string) ([]BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalBlobStore реализация BlobStore поверх папки на локальном диске
+type LocalBlobStore struct {
+	root string
+}
+
+// NewLocalBlobStore создает хранилище в папке root
+func NewLocalBlobStore(root string) (BlobStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &LocalBlobStore{root: root}, nil
+}
+
+// path переводит ключ в путь на диске, не выпуская его за пределы root
+func (s *LocalBlobStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + filepath.FromSlash(key))
+	if clean == string(filepath.Separator) {
+		return "", fmt.Errorf("invalid key: %q", key)
+	}
+	return filepath.Join(s.root, clean), nil
+}
+
+// Put записывает объект во временный файл и атомарно переименовывает его,
+// чтобы читатели никогда не видели наполовину записанный файл
+func (s *LocalBlobStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// CreateTemp создает файл с правами 0600, возвращаем обычные права как у os.Create
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get открывает файл и при необходимости ограничивает чтение диапазоном
+func (s *LocalBlobStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+// Stat возвращает размер и время изменения файла
+func (s *LocalBlobStore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, ErrBlobNotFound
+	}
+	return &BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// List обходит папку рекурсивно. Служебные файлы и папки, начинающиеся с точки, пропускаются.
+func (s *LocalBlobStore) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	var blobs []BlobInfo
+	err := filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == s.root {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			blobs = append(blobs, BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// Delete удаляет файл
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrBlobNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// BlobReadSeeker дает доступ к объекту BlobStore как к io.ReadSeeker, чтобы
+// http.ServeContent мог обрабатывать Range-запросы. Каждый Seek сбрасывает
+// открытый поток, следующий Read открывает объект с нового смещения.
+type BlobReadSeeker struct {
+	ctx    context.Context
+	store  BlobStore
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+// NewBlobReadSeeker создает BlobReadSeeker для объекта известного размера
+func NewBlobReadSeeker(ctx context.Context, store BlobStore, key string, size int64) *BlobReadSeeker {
+	return &BlobReadSeeker{ctx: ctx, store: store, key: key, size: size}
+}
+
+// Read читает объект с текущего смещения
+func (b *BlobReadSeeker) Read(p []byte) (int, error) {
+	if b.offset >= b.size {
+		return 0, io.EOF
+	}
+	if b.body == nil {
+		body, err := b.store.Get(b.ctx, b.key, b.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		b.body = body
+	}
+	n, err := b.body.Read(p)
+	b.offset += int64(n)
+	return n, err
+}
+
+// Seek меняет смещение, не обращаясь к хранилищу
+func (b *BlobReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = b.offset + offset
+	case io.SeekEnd:
+		next = b.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if next < 0 {
+		return 0, errors.New("negative position")
+	}
+	if next != b.offset {
+		b.Close()
+		b.offset = next
+	}
+	return next, nil
+}
+
+// Close закрывает открытый поток, если он есть
+func (b *BlobReadSeeker) Close() error {
+	if b.body == nil {
+		return nil
+	}
+	err := b.body.Close()
+	b.body = nil
+	return err
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+)
+
+// sniffLen сколько первых байт нужно http.DetectContentType
+const sniffLen = 512
+
+// ContentInspector пропускает через себя поток и по пути считает SHA-256,
+// размер и запоминает начало файла для определения MIME-типа
+type ContentInspector struct {
+	r    io.Reader
+	hash hash.Hash
+	head []byte
+	size int64
+}
+
+// NewContentInspector оборачивает r
+func NewContentInspector(r io.Reader) *ContentInspector {
+	return &ContentInspector{r: r, hash: sha256.New()}
+}
+
+// Read читает из исходного потока и обновляет хэш
+func (c *ContentInspector) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.hash.Write(p[:n])
+		c.size += int64(n)
+		if missing := sniffLen - len(c.head); missing > 0 {
+			if missing > n {
+				missing = n
+			}
+			c.head = append(c.head, p[:missing]...)
+		}
+	}
+	return n, err
+}
+
+// SHA256 возвращает хэш прочитанных данных в hex
+func (c *ContentInspector) SHA256() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// Size возвращает количество прочитанных байт
+func (c *ContentInspector) Size() int64 {
+	return c.size
+}
+
+// MimeType определяет тип по расширению имени, а если оно неизвестно - по содержимому
+func (c *ContentInspector) MimeType(name string) string {
+	if byExt := mime.TypeByExtension(path.Ext(name)); byExt != "" {
+		return byExt
+	}
+	return http.DetectContentType(c.head)
+}
+
+// NewBlobKey генерирует ключ для нового объекта в хранилище. Первые два символа
+// вынесены в отдельную папку, чтобы не держать все файлы в одном каталоге.
+func NewBlobKey() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(raw)
+	return key[:2] + "/" + key, nil
+}
@@ -4,18 +4,45 @@ import (
 	_ "github.com/mattn/go-sqlite3" // Импорт драйвера SQLite3
 
 	"database/sql"
+	"file-exchange-app/config"
 	"log"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 var DB *sql.DB
 var UserStoreInstance UserStore
+var LogStoreInstance LogStore
+var TokenStoreInstance TokenStore
+var FileStoreInstance FileStore
+var FolderStoreInstance FolderStore
+var ShareStoreInstance ShareStore
+var GroupStoreInstance GroupStore
+var RoleStoreInstance RoleStore
+var TwoFactorStoreInstance TwoFactorStore
+var SettingsStoreInstance SettingsStore
+var ACLStoreInstance ACLStore
+var SessionStoreInstance SessionStore
+var LoginThrottleInstance LoginThrottle
 
-func InitDB() error {
+// sqliteDSN добавляет к пути базы параметры соединения. WAL позволяет читать во время
+// записи, а транзакции сразу берут блокировку записи (_txlock=immediate): иначе две
+// параллельные загрузки, начавшие транзакцию чтением, ловят SQLITE_BUSY при попытке
+// записать. busy_timeout дает подождать, пока запишет другой запрос.
+func sqliteDSN(path string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + "_txlock=immediate&_journal_mode=WAL&_busy_timeout=5000"
+}
+
+// InitDB открывает базу данных, создает недостающие таблицы и хранилища с параметрами из cfg
+func InitDB(cfg *config.Config) error {
 	var err error
-	// Открываем соединение с БД. Файл `data.db` будет создан в корне проекта.
-	DB, err = sql.Open("sqlite3", "./data.db")
+	// Открываем соединение с БД. Файл будет создан, если его нет.
+	DB, err = sql.Open("sqlite3", sqliteDSN(cfg.Database.Path))
 	if err != nil {
 		return err
 	}
@@ -51,6 +78,291 @@ func InitDB() error {
 		return err
 	}
 
+	// Создаем таблицу API-токенов, если ее нет. Храним только хэш токена.
+	createTokenTable := `
+    CREATE TABLE IF NOT EXISTS api_tokens (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        token_hash TEXT UNIQUE NOT NULL,
+        scope TEXT NOT NULL DEFAULT 'full',
+        expires_at DATETIME,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        last_used_at DATETIME
+    );
+    `
+	_, err = DB.Exec(createTokenTable)
+	if err != nil {
+		return err
+	}
+
+	// Создаем таблицу метаданных файлов, если ее нет. original_name - имя, под которым
+	// файл видят пользователи, stored_key - ключ содержимого в BlobStore.
+	createFileTable := `
+    CREATE TABLE IF NOT EXISTS files (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        owner_id INTEGER,
+        original_name TEXT UNIQUE NOT NULL,
+        stored_key TEXT UNIQUE NOT NULL,
+        size INTEGER NOT NULL,
+        sha256 TEXT NOT NULL,
+        mime_type TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+    `
+	_, err = DB.Exec(createFileTable)
+	if err != nil {
+		return err
+	}
+
+	// Номер текущей версии появился вместе с историей версий
+	err = addColumnIfMissing("files", "current_version", "INTEGER NOT NULL DEFAULT 1")
+	if err != nil {
+		return err
+	}
+
+	// Создаем таблицу версий файлов, если ее нет. Несколько версий могут ссылаться
+	// на один stored_key (например, после восстановления старой версии).
+	createVersionTable := `
+    CREATE TABLE IF NOT EXISTS file_versions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        file_id INTEGER NOT NULL,
+        version INTEGER NOT NULL,
+        stored_key TEXT NOT NULL,
+        size INTEGER NOT NULL,
+        sha256 TEXT NOT NULL,
+        mime_type TEXT NOT NULL,
+        uploader_id INTEGER,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE (file_id, version)
+    );
+    CREATE INDEX IF NOT EXISTS idx_file_versions_key ON file_versions (stored_key);
+    `
+	_, err = DB.Exec(createVersionTable)
+	if err != nil {
+		return err
+	}
+
+	// Файлам, загруженным до появления версий, заводим версию из текущего содержимого
+	_, err = DB.Exec(`
+    INSERT INTO file_versions (file_id, version, stored_key, size, sha256, mime_type, uploader_id, created_at)
+    SELECT id, current_version, stored_key, size, sha256, mime_type, owner_id, updated_at
+    FROM files WHERE id NOT IN (SELECT file_id FROM file_versions)
+    `)
+	if err != nil {
+		return err
+	}
+
+	// Создаем таблицу папок, если ее нет. Папки хранятся полными путями ("docs/reports"),
+	// а файлы внутри них - именами с тем же префиксом, поэтому таблица нужна в основном
+	// для пустых папок и владельцев.
+	createFolderTable := `
+    CREATE TABLE IF NOT EXISTS folders (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        path TEXT UNIQUE NOT NULL,
+        owner_id INTEGER,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+    `
+	_, err = DB.Exec(createFolderTable)
+	if err != nil {
+		return err
+	}
+
+	// Создаем таблицу публичных ссылок, если ее нет. Как и у API-токенов, храним
+	// только хэш токена ссылки; пароль ссылки хранится bcrypt-хэшем.
+	createShareTable := `
+    CREATE TABLE IF NOT EXISTS shares (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        file_id INTEGER NOT NULL,
+        owner_id INTEGER NOT NULL,
+        token_hash TEXT UNIQUE NOT NULL,
+        password_hash TEXT,
+        expires_at DATETIME,
+        max_downloads INTEGER NOT NULL DEFAULT 0,
+        download_count INTEGER NOT NULL DEFAULT 0,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        revoked_at DATETIME
+    );
+    `
+	_, err = DB.Exec(createShareTable)
+	if err != nil {
+		return err
+	}
+
+	// Создаем таблицы групп пользователей, если их нет
+	createGroupTables := `
+    CREATE TABLE IF NOT EXISTS groups (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT UNIQUE NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE TABLE IF NOT EXISTS group_members (
+        group_id INTEGER NOT NULL,
+        user_id INTEGER NOT NULL,
+        PRIMARY KEY (group_id, user_id)
+    );
+    `
+	_, err = DB.Exec(createGroupTables)
+	if err != nil {
+		return err
+	}
+
+	// Создаем таблицу ролей, если ее нет, и встроенные роли, которые раньше
+	// существовали только как константы в коде
+	createRoleTable := `
+    CREATE TABLE IF NOT EXISTS roles (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT UNIQUE NOT NULL,
+        can_upload BOOLEAN NOT NULL DEFAULT FALSE,
+        can_download BOOLEAN NOT NULL DEFAULT FALSE,
+        is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+        builtin BOOLEAN NOT NULL DEFAULT FALSE,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+    INSERT OR IGNORE INTO roles (name, can_upload, can_download, is_admin, builtin) VALUES
+        ('downloader', FALSE, TRUE, FALSE, TRUE),
+        ('uploader', TRUE, TRUE, FALSE, TRUE),
+        ('admin', TRUE, TRUE, TRUE, TRUE);
+    `
+	_, err = DB.Exec(createRoleTable)
+	if err != nil {
+		return err
+	}
+
+	// Роль пользователя и группы. У пользователя без роли действуют собственные флаги.
+	err = addColumnIfMissing("users", "role_id", "INTEGER")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing("groups", "role_id", "INTEGER")
+	if err != nil {
+		return err
+	}
+
+	// Заблокированные пользователи не могут войти ни паролем, ни API-токеном
+	err = addColumnIfMissing("users", "disabled", "BOOLEAN NOT NULL DEFAULT FALSE")
+	if err != nil {
+		return err
+	}
+
+	// Пароль, заданный администратором или при установке, пользователь должен сменить
+	err = addColumnIfMissing("users", "must_change_password", "BOOLEAN NOT NULL DEFAULT FALSE")
+	if err != nil {
+		return err
+	}
+
+	// Двухфакторная аутентификация: секрет TOTP (NULL - выключена) и последний принятый
+	// шаг, чтобы один код нельзя было использовать дважды
+	err = addColumnIfMissing("users", "totp_secret", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing("users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	// Источник учетной записи: local или внешний провайдер, создавший пользователя при входе
+	err = addColumnIfMissing("users", "auth_source", "TEXT NOT NULL DEFAULT 'local'")
+	if err != nil {
+		return err
+	}
+	// Постоянный идентификатор пользователя у внешнего провайдера (sub в OpenID Connect)
+	err = addColumnIfMissing("users", "external_id", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	// Создаем таблицы кодов восстановления (храним только хэши) и настроек, если их нет
+	createTwoFactorTables := `
+    CREATE TABLE IF NOT EXISTS recovery_codes (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        code_hash TEXT NOT NULL,
+        used_at DATETIME
+    );
+    CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes (user_id);
+    CREATE TABLE IF NOT EXISTS settings (
+        key TEXT PRIMARY KEY,
+        value TEXT NOT NULL
+    );
+    `
+	_, err = DB.Exec(createTwoFactorTables)
+	if err != nil {
+		return err
+	}
+
+	// Создаем таблицу сессий входа, если ее нет. Как и у API-токенов, храним только хэш
+	// идентификатора из cookie; user_id заполнен, когда сессия авторизована.
+	createSessionTable := `
+    CREATE TABLE IF NOT EXISTS sessions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        token_hash TEXT UNIQUE NOT NULL,
+        user_id INTEGER,
+        data BLOB,
+        user_agent TEXT NOT NULL DEFAULT '',
+        ip_address TEXT NOT NULL DEFAULT '',
+        created_at DATETIME NOT NULL,
+        last_seen_at DATETIME NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (user_id);
+    `
+	_, err = DB.Exec(createSessionTable)
+	if err != nil {
+		return err
+	}
+
+	// Создаем таблицу счетчиков неудачных входов, если ее нет. key - "user:<имя>" или "ip:<адрес>".
+	createLoginFailuresTable := `
+    CREATE TABLE IF NOT EXISTS login_failures (
+        key TEXT PRIMARY KEY,
+        failures INTEGER NOT NULL DEFAULT 0,
+        last_failure_at DATETIME NOT NULL,
+        locked_until DATETIME
+    );
+    `
+	_, err = DB.Exec(createLoginFailuresTable)
+	if err != nil {
+		return err
+	}
+
+	// Создаем таблицу списков доступа, если ее нет. Права на папку действуют на все
+	// вложенное; resource_type 'folder' с resource_id 0 - корень хранилища.
+	var aclExists int
+	err = DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'acl_entries'").Scan(&aclExists)
+	if err != nil {
+		return err
+	}
+	createACLTable := `
+    CREATE TABLE IF NOT EXISTS acl_entries (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        resource_type TEXT NOT NULL,
+        resource_id INTEGER NOT NULL,
+        principal_type TEXT NOT NULL,
+        principal_id INTEGER NOT NULL,
+        permission TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE (resource_type, resource_id, principal_type, principal_id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_acl_principal ON acl_entries (principal_type, principal_id);
+    `
+	_, err = DB.Exec(createACLTable)
+	if err != nil {
+		return err
+	}
+
+	// До появления ACL любой пользователь видел все файлы. Чтобы обновление ничего
+	// не отобрало молча, существующие пользователи получают права на корень по своим
+	// флагам; администратор может убрать их на странице доступа к корню.
+	if aclExists == 0 {
+		_, err = DB.Exec(grantRootAccess)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Создаем администратора по умолчанию, если пользователей нет
 	var count int
 	err = DB.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
@@ -60,17 +372,92 @@ func InitDB() error {
 
 	if count == 0 {
 		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("admin"), bcrypt.DefaultCost)
-		_, err = DB.Exec(`INSERT INTO users (username, password_hash, can_upload, can_download, is_admin) 
-                         VALUES (?, ?, ?, ?, ?)`,
-			"admin", string(hashedPassword), true, true, true)
+		_, err = DB.Exec(`INSERT INTO users (username, password_hash, can_upload, can_download, is_admin, must_change_password) 
+                         VALUES (?, ?, ?, ?, ?, ?)`,
+			"admin", string(hashedPassword), true, true, true, true)
 		if err != nil {
 			return err
 		}
 		log.Println("Создан пользователь admin по умолчанию. СРОЧНО СМЕНИТЕ ПАРОЛЬ!")
+	} else if err := flagDefaultAdminPassword(); err != nil {
+		return err
 	}
 
-	// Инициализируем UserStore
-	UserStoreInstance = NewUserStore(DB)
+	// Пользователям без роли, чьи флаги в точности совпадают со встроенной ролью,
+	// назначаем эту роль. Остальные сохраняют собственные флаги.
+	_, err = DB.Exec(`
+    UPDATE users SET role_id = (
+        SELECT id FROM roles WHERE builtin AND can_upload = users.can_upload
+            AND can_download = users.can_download AND is_admin = users.is_admin
+    ) WHERE role_id IS NULL
+    `)
+	if err != nil {
+		return err
+	}
+
+	// Инициализируем хранилища
+	UserStoreInstance = NewUserStore(DB, passwordPolicy(cfg.Password))
+	LogStoreInstance = NewLogStore(DB)
+	TokenStoreInstance = NewTokenStore(DB)
+	FileStoreInstance = NewFileStore(DB, cfg.Uploads.MaxVersions)
+	FolderStoreInstance = NewFolderStore(DB)
+	ShareStoreInstance = NewShareStore(DB)
+	GroupStoreInstance = NewGroupStore(DB)
+	RoleStoreInstance = NewRoleStore(DB)
+	TwoFactorStoreInstance = NewTwoFactorStore(DB)
+	SettingsStoreInstance = NewSettingsStore(DB)
+	ACLStoreInstance = NewACLStore(DB)
+	SessionStoreInstance = NewSessionStore(DB, cfg.Sessions.IdleTimeout, cfg.Sessions.AbsoluteTimeout)
+	LoginThrottleInstance = NewLoginThrottle(DB, loginThrottleConfig(cfg.Login))
 
 	return nil
 }
+
+// flagDefaultAdminPassword требует смены пароля у admin, если в базе, созданной до появления
+// флага must_change_password, у него все еще пароль по умолчанию
+func flagDefaultAdminPassword() error {
+	var id int
+	var hash string
+	err := DB.QueryRow("SELECT id, password_hash FROM users WHERE username = 'admin' AND NOT must_change_password").Scan(&id, &hash)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte("admin")) != nil {
+		return nil
+	}
+	log.Println("У пользователя admin пароль по умолчанию, потребуем сменить его при входе")
+	_, err = DB.Exec("UPDATE users SET must_change_password = TRUE WHERE id = ?", id)
+	return err
+}
+
+// addColumnIfMissing добавляет столбец в существующую таблицу. CREATE TABLE IF NOT EXISTS
+// не меняет уже созданные таблицы, поэтому новые столбцы добавляем отдельно.
+func addColumnIfMissing(table, column, definition string) error {
+	rows, err := DB.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = DB.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
@@ -0,0 +1,481 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"file-exchange-app/models"
+	"fmt"
+	"time"
+)
+
+// Ошибки, которые хендлеры различают при работе с файлами
+var (
+	ErrFileNotFound    = errors.New("file not found")
+	ErrVersionNotFound = errors.New("version not found")
+)
+
+// FileStore представляет интерфейс для работы с метаданными файлов и их версиями.
+// Методы, меняющие содержимое, возвращают ключи объектов хранилища, на которые
+// больше не ссылается ни одна версия, - их должен удалить вызывающий код.
+type FileStore interface {
+	// SaveFile создает файл или, если файл с таким именем уже есть, добавляет ему новую версию
+	SaveFile(file *models.File) ([]string, error)
+	// CreateFile создает файл; если имя уже занято файлом, возвращает ErrFileExists
+	CreateFile(file *models.File) error
+	GetFileByName(name string) (*models.File, error)
+	ListFiles() ([]models.File, error)
+	IsKeyKnown(storedKey string) (bool, error)
+	DeleteFile(fileID int) ([]string, error)
+	// MoveFile переименовывает файл или переносит его в другую папку
+	MoveFile(fileID int, newName string, userID int) error
+
+	ListVersions(fileID int) ([]models.FileVersion, error)
+	GetVersion(fileID, version int) (*models.FileVersion, error)
+	// RestoreVersion делает копию старой версии новой текущей версией
+	RestoreVersion(fileID, version, userID int) (*models.File, []string, error)
+}
+
+// SQLiteFileStore реализация FileStore для SQLite
+type SQLiteFileStore struct {
+	db *sql.DB
+	// maxVersions сколько последних версий хранить для каждого файла, 0 - без ограничения
+	maxVersions int
+}
+
+// NewFileStore создает новый экземпляр FileStore
+func NewFileStore(db *sql.DB, maxVersions int) FileStore {
+	return &SQLiteFileStore{db: db, maxVersions: maxVersions}
+}
+
+// fileColumns столбцы, которые читают все запросы к files
+const fileColumns = `f.id, f.owner_id, COALESCE(u.username, ''), f.original_name, f.stored_key,
+	f.size, f.sha256, f.mime_type, f.current_version, f.created_at, f.updated_at`
+
+// scanFile читает строку, выбранную с fileColumns
+func scanFile(row interface{ Scan(...interface{}) error }) (*models.File, error) {
+	var file models.File
+	var ownerID sql.NullInt64
+	err := row.Scan(&file.ID, &ownerID, &file.OwnerName, &file.Name, &file.StoredKey,
+		&file.Size, &file.SHA256, &file.MimeType, &file.Version, &file.CreatedAt, &file.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	file.OwnerID = int(ownerID.Int64)
+	return &file, nil
+}
+
+// nullableID переводит 0 в NULL для необязательных ссылок на пользователя
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// SaveFile создает или обновляет запись о файле и добавляет версию в одной транзакции
+func (s *SQLiteFileStore) SaveFile(file *models.File) ([]string, error) {
+	return s.saveFile(file, true)
+}
+
+// CreateFile создает запись о новом файле, не трогая существующий файл с тем же именем.
+// Проверка и вставка идут в одной транзакции, поэтому параллельная загрузка не перезапишет файл.
+func (s *SQLiteFileStore) CreateFile(file *models.File) error {
+	_, err := s.saveFile(file, false)
+	return err
+}
+
+// saveFile записывает файл; existing разрешает добавить версию уже существующему файлу
+func (s *SQLiteFileStore) saveFile(file *models.File, existing bool) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	file.UpdatedAt = now
+
+	var createdAt time.Time
+	var ownerID sql.NullInt64
+	err = tx.QueryRow("SELECT id, owner_id, created_at, current_version FROM files WHERE original_name = ?", file.Name).
+		Scan(&file.ID, &ownerID, &createdAt, &file.Version)
+
+	uploaderID := file.OwnerID
+	switch {
+	case err == sql.ErrNoRows:
+		// Новый файл: путь не должен быть занят папкой, родительские папки создаем
+		if _, isFolder, err := pathExists(tx, file.Name); err != nil {
+			return nil, err
+		} else if isFolder {
+			return nil, ErrPathConflict
+		}
+		if err := ensureFolders(tx, ParentPath(file.Name), file.OwnerID); err != nil {
+			return nil, err
+		}
+
+		file.CreatedAt = now
+		file.Version = 1
+		result, err := tx.Exec(
+			`INSERT INTO files (owner_id, original_name, stored_key, size, sha256, mime_type, current_version, created_at, updated_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			nullableID(file.OwnerID), file.Name, file.StoredKey, file.Size, file.SHA256, file.MimeType, file.Version, file.CreatedAt, file.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		file.ID = int(id)
+	case err != nil:
+		return nil, fmt.Errorf("database error: %w", err)
+	case !existing:
+		return nil, ErrFileExists
+	default:
+		// Владельцем остается тот, кто загрузил первую версию
+		file.CreatedAt = createdAt
+		file.OwnerID = int(ownerID.Int64)
+		file.Version++
+		if err := updateCurrentContent(tx, file); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := insertVersion(tx, file, uploaderID, now); err != nil {
+		return nil, err
+	}
+
+	orphans, err := s.pruneVersions(tx, file)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return orphans, nil
+}
+
+// updateCurrentContent копирует данные текущей версии в строку files
+func updateCurrentContent(tx *sql.Tx, file *models.File) error {
+	_, err := tx.Exec(
+		`UPDATE files SET stored_key = ?, size = ?, sha256 = ?, mime_type = ?, current_version = ?, updated_at = ?
+		 WHERE id = ?`,
+		file.StoredKey, file.Size, file.SHA256, file.MimeType, file.Version, file.UpdatedAt, file.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// insertVersion добавляет строку в историю версий
+func insertVersion(tx *sql.Tx, file *models.File, uploaderID int, createdAt time.Time) error {
+	_, err := tx.Exec(
+		`INSERT INTO file_versions (file_id, version, stored_key, size, sha256, mime_type, uploader_id, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		file.ID, file.Version, file.StoredKey, file.Size, file.SHA256, file.MimeType, nullableID(uploaderID), createdAt,
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// pruneVersions удаляет версии сверх лимита maxVersions и возвращает ключи,
+// на которые больше никто не ссылается. Текущая версия всегда самая новая, поэтому не удаляется.
+func (s *SQLiteFileStore) pruneVersions(tx *sql.Tx, file *models.File) ([]string, error) {
+	if s.maxVersions <= 0 {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(
+		"SELECT DISTINCT stored_key FROM file_versions WHERE file_id = ? AND version <= ?",
+		file.ID, file.Version-s.maxVersions,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	var candidates []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		candidates = append(candidates, key)
+	}
+	rows.Close()
+
+	_, err = tx.Exec("DELETE FROM file_versions WHERE file_id = ? AND version <= ?", file.ID, file.Version-s.maxVersions)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	return unreferencedKeys(tx, candidates)
+}
+
+// unreferencedKeys оставляет из keys только те, на которые не ссылается ни одна версия
+func unreferencedKeys(tx *sql.Tx, keys []string) ([]string, error) {
+	var orphans []string
+	for _, key := range keys {
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM file_versions WHERE stored_key = ?", key).Scan(&count); err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		if count == 0 {
+			orphans = append(orphans, key)
+		}
+	}
+	return orphans, nil
+}
+
+// GetFileByName возвращает файл по имени, под которым его видят пользователи
+func (s *SQLiteFileStore) GetFileByName(name string) (*models.File, error) {
+	file, err := scanFile(s.db.QueryRow(
+		"SELECT "+fileColumns+" FROM files f LEFT JOIN users u ON u.id = f.owner_id WHERE f.original_name = ?",
+		name,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrFileNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return file, nil
+}
+
+// ListFiles возвращает все файлы, отсортированные по имени
+func (s *SQLiteFileStore) ListFiles() ([]models.File, error) {
+	rows, err := s.db.Query("SELECT " + fileColumns + " FROM files f LEFT JOIN users u ON u.id = f.owner_id ORDER BY f.original_name")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var files []models.File
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file: %w", err)
+		}
+		files = append(files, *file)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return files, nil
+}
+
+// IsKeyKnown проверяет, ссылается ли какая-нибудь версия на объект хранилища
+func (s *SQLiteFileStore) IsKeyKnown(storedKey string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM file_versions WHERE stored_key = ?", storedKey).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("database error: %w", err)
+	}
+	return count > 0, nil
+}
+
+// DeleteFile удаляет файл вместе со всеми версиями, публичными ссылками и правами доступа
+func (s *SQLiteFileStore) DeleteFile(fileID int) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM files WHERE id = ?", fileID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if err := checkAffected(result, ErrFileNotFound); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT DISTINCT stored_key FROM file_versions WHERE file_id = ?", fileID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+
+	if _, err := tx.Exec("DELETE FROM file_versions WHERE file_id = ?", fileID); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	// Публичные ссылки на удаленный файл больше ничего не открывают
+	if _, err := tx.Exec("DELETE FROM shares WHERE file_id = ?", fileID); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM acl_entries WHERE resource_type = 'file' AND resource_id = ?", fileID); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return keys, nil
+}
+
+// MoveFile меняет путь файла. Недостающие папки назначения создаются.
+func (s *SQLiteFileStore) MoveFile(fileID int, newName string, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	isFile, isFolder, err := pathExists(tx, newName)
+	if err != nil {
+		return err
+	}
+	if isFile {
+		return ErrFileExists
+	}
+	if isFolder {
+		return ErrPathConflict
+	}
+	if err := ensureFolders(tx, ParentPath(newName), userID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("UPDATE files SET original_name = ? WHERE id = ?", newName, fileID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if err := checkAffected(result, ErrFileNotFound); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// versionColumns столбцы, которые читают запросы к file_versions
+const versionColumns = `v.id, v.file_id, v.version, v.stored_key, v.size, v.sha256, v.mime_type,
+	v.uploader_id, COALESCE(u.username, ''), v.created_at, v.version = f.current_version`
+
+// scanVersion читает строку, выбранную с versionColumns
+func scanVersion(row interface{ Scan(...interface{}) error }) (*models.FileVersion, error) {
+	var version models.FileVersion
+	var uploaderID sql.NullInt64
+	err := row.Scan(&version.ID, &version.FileID, &version.Version, &version.StoredKey, &version.Size,
+		&version.SHA256, &version.MimeType, &uploaderID, &version.UploaderName, &version.CreatedAt, &version.Current)
+	if err != nil {
+		return nil, err
+	}
+	version.UploaderID = int(uploaderID.Int64)
+	return &version, nil
+}
+
+// ListVersions возвращает историю версий файла, начиная с самой новой
+func (s *SQLiteFileStore) ListVersions(fileID int) ([]models.FileVersion, error) {
+	rows, err := s.db.Query(
+		"SELECT "+versionColumns+` FROM file_versions v
+		 JOIN files f ON f.id = v.file_id
+		 LEFT JOIN users u ON u.id = v.uploader_id
+		 WHERE v.file_id = ? ORDER BY v.version DESC`,
+		fileID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []models.FileVersion
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		versions = append(versions, *version)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return versions, nil
+}
+
+// GetVersion возвращает конкретную версию файла
+func (s *SQLiteFileStore) GetVersion(fileID, version int) (*models.FileVersion, error) {
+	v, err := scanVersion(s.db.QueryRow(
+		"SELECT "+versionColumns+` FROM file_versions v
+		 JOIN files f ON f.id = v.file_id
+		 LEFT JOIN users u ON u.id = v.uploader_id
+		 WHERE v.file_id = ? AND v.version = ?`,
+		fileID, version,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrVersionNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return v, nil
+}
+
+// RestoreVersion создает новую версию с содержимым старой. Объект в хранилище
+// не копируется: обе версии ссылаются на один ключ.
+func (s *SQLiteFileStore) RestoreVersion(fileID, version, userID int) (*models.File, []string, error) {
+	old, err := s.GetVersion(fileID, version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	file, err := scanFile(tx.QueryRow(
+		"SELECT "+fileColumns+" FROM files f LEFT JOIN users u ON u.id = f.owner_id WHERE f.id = ?",
+		fileID,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrFileNotFound
+		}
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+
+	now := time.Now().UTC()
+	file.StoredKey = old.StoredKey
+	file.Size = old.Size
+	file.SHA256 = old.SHA256
+	file.MimeType = old.MimeType
+	file.Version++
+	file.UpdatedAt = now
+
+	if err := updateCurrentContent(tx, file); err != nil {
+		return nil, nil, err
+	}
+	if err := insertVersion(tx, file, userID, now); err != nil {
+		return nil, nil, err
+	}
+
+	orphans, err := s.pruneVersions(tx, file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+	return file, orphans, nil
+}
@@ -0,0 +1,350 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"file-exchange-app/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Ошибки, которые хендлеры различают при работе с папками
+var (
+	ErrFolderNotFound = errors.New("folder not found")
+	ErrFolderExists   = errors.New("folder already exists")
+	ErrFolderNotEmpty = errors.New("folder is not empty")
+	ErrFileExists     = errors.New("file already exists")
+	// ErrPathConflict - путь уже занят объектом другого типа (файл вместо папки или наоборот)
+	ErrPathConflict = errors.New("path is used by another file or folder")
+)
+
+// FolderStore представляет интерфейс для работы с папками
+type FolderStore interface {
+	CreateFolder(path string, ownerID int) (*models.Folder, error)
+	GetFolder(path string) (*models.Folder, error)
+	// ListFolder возвращает непосредственные подпапки и файлы папки
+	ListFolder(path string) ([]models.Folder, []models.File, error)
+	// MoveFolder переименовывает или переносит папку вместе со всем содержимым
+	MoveFolder(oldPath, newPath string, ownerID int) error
+	// DeleteFolder удаляет папку; непустую - только при recursive. Возвращает
+	// ключи объектов хранилища, которые нужно удалить.
+	DeleteFolder(path string, recursive bool) ([]string, error)
+}
+
+// SQLiteFolderStore реализация FolderStore для SQLite
+type SQLiteFolderStore struct {
+	db *sql.DB
+}
+
+// NewFolderStore создает новый экземпляр FolderStore
+func NewFolderStore(db *sql.DB) FolderStore {
+	return &SQLiteFolderStore{db: db}
+}
+
+// queryer общий интерфейс *sql.DB и *sql.Tx для вспомогательных функций
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// prefixMatch условие SQL "столбец начинается с prefix" без спецсимволов LIKE
+func prefixMatch(column string) string {
+	return "substr(" + column + ", 1, length(?)) = ?"
+}
+
+// pathExists проверяет, занят ли путь файлом или папкой
+func pathExists(q queryer, p string) (file bool, folder bool, err error) {
+	var count int
+	if err = q.QueryRow("SELECT COUNT(*) FROM files WHERE original_name = ?", p).Scan(&count); err != nil {
+		return false, false, fmt.Errorf("database error: %w", err)
+	}
+	file = count > 0
+	if err = q.QueryRow("SELECT COUNT(*) FROM folders WHERE path = ?", p).Scan(&count); err != nil {
+		return false, false, fmt.Errorf("database error: %w", err)
+	}
+	return file, count > 0, nil
+}
+
+// ensureFolders создает папку p и всех ее предков, которых еще нет. Параллельные загрузки
+// в одно новое дерево создают одни и те же папки, поэтому уже существующая папка не
+// ошибка (INSERT OR IGNORE), а занятость пути файлом проверяется после вставки.
+func ensureFolders(q queryer, p string, ownerID int) error {
+	if p == "" {
+		return nil
+	}
+	segments := strings.Split(p, "/")
+	for i := range segments {
+		current := strings.Join(segments[:i+1], "/")
+		_, err := q.Exec("INSERT OR IGNORE INTO folders (path, owner_id, created_at) VALUES (?, ?, ?)",
+			current, nullableID(ownerID), time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("database error: %w", err)
+		}
+		isFile, _, err := pathExists(q, current)
+		if err != nil {
+			return err
+		}
+		if isFile {
+			return ErrPathConflict
+		}
+	}
+	return nil
+}
+
+// scanFolder читает строку id, path, owner_id, created_at
+func scanFolder(row interface{ Scan(...interface{}) error }) (*models.Folder, error) {
+	var folder models.Folder
+	var ownerID sql.NullInt64
+	if err := row.Scan(&folder.ID, &folder.Path, &ownerID, &folder.CreatedAt); err != nil {
+		return nil, err
+	}
+	folder.OwnerID = int(ownerID.Int64)
+	folder.Name = BaseName(folder.Path)
+	return &folder, nil
+}
+
+// CreateFolder создает папку вместе с недостающими родительскими папками
+func (s *SQLiteFolderStore) CreateFolder(path string, ownerID int) (*models.Folder, error) {
+	if path == "" {
+		return nil, ErrInvalidPath
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	isFile, isFolder, err := pathExists(tx, path)
+	if err != nil {
+		return nil, err
+	}
+	if isFolder {
+		return nil, ErrFolderExists
+	}
+	if isFile {
+		return nil, ErrPathConflict
+	}
+	if err := ensureFolders(tx, path, ownerID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return s.GetFolder(path)
+}
+
+// GetFolder возвращает папку по пути; для корня возвращает пустую папку
+func (s *SQLiteFolderStore) GetFolder(path string) (*models.Folder, error) {
+	if path == "" {
+		return &models.Folder{}, nil
+	}
+	folder, err := scanFolder(s.db.QueryRow("SELECT id, path, owner_id, created_at FROM folders WHERE path = ?", path))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrFolderNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return folder, nil
+}
+
+// ListFolder возвращает содержимое одного уровня папки
+func (s *SQLiteFolderStore) ListFolder(path string) ([]models.Folder, []models.File, error) {
+	if _, err := s.GetFolder(path); err != nil {
+		return nil, nil, err
+	}
+
+	prefix := ""
+	if path != "" {
+		prefix = path + "/"
+	}
+
+	// Непосредственные потомки: после префикса в пути больше нет "/"
+	rows, err := s.db.Query(
+		"SELECT id, path, owner_id, created_at FROM folders WHERE "+prefixMatch("path")+
+			" AND instr(substr(path, length(?) + 1), '/') = 0 ORDER BY path",
+		prefix, prefix, prefix,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+	var folders []models.Folder
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan folder: %w", err)
+		}
+		folders = append(folders, *folder)
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(
+		"SELECT "+fileColumns+" FROM files f LEFT JOIN users u ON u.id = f.owner_id WHERE "+prefixMatch("f.original_name")+
+			" AND instr(substr(f.original_name, length(?) + 1), '/') = 0 ORDER BY f.original_name",
+		prefix, prefix, prefix,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var files []models.File
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to scan file: %w", err)
+		}
+		files = append(files, *file)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return folders, files, nil
+}
+
+// MoveFolder меняет префикс пути у папки, всех вложенных папок и файлов
+func (s *SQLiteFolderStore) MoveFolder(oldPath, newPath string, ownerID int) error {
+	if oldPath == "" || newPath == "" {
+		return ErrInvalidPath
+	}
+	if oldPath == newPath {
+		return nil
+	}
+	// Нельзя перенести папку внутрь самой себя
+	if strings.HasPrefix(newPath, oldPath+"/") {
+		return ErrInvalidPath
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, isFolder, err := pathExists(tx, oldPath)
+	if err != nil {
+		return err
+	}
+	if !isFolder {
+		return ErrFolderNotFound
+	}
+
+	isFile, isFolder, err := pathExists(tx, newPath)
+	if err != nil {
+		return err
+	}
+	if isFolder {
+		return ErrFolderExists
+	}
+	if isFile {
+		return ErrPathConflict
+	}
+	if err := ensureFolders(tx, ParentPath(newPath), ownerID); err != nil {
+		return err
+	}
+
+	oldPrefix, newPrefix := oldPath+"/", newPath+"/"
+	if _, err := tx.Exec("UPDATE folders SET path = ? WHERE path = ?", newPath, oldPath); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	_, err = tx.Exec("UPDATE folders SET path = ? || substr(path, length(?) + 1) WHERE "+prefixMatch("path"),
+		newPrefix, oldPrefix, oldPrefix, oldPrefix)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	_, err = tx.Exec("UPDATE files SET original_name = ? || substr(original_name, length(?) + 1) WHERE "+prefixMatch("original_name"),
+		newPrefix, oldPrefix, oldPrefix, oldPrefix)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// DeleteFolder удаляет папку, вложенные папки, файлы, их версии, публичные ссылки и права доступа
+func (s *SQLiteFolderStore) DeleteFolder(path string, recursive bool) ([]string, error) {
+	if path == "" {
+		return nil, ErrInvalidPath
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	var folderID int
+	err = tx.QueryRow("SELECT id FROM folders WHERE path = ?", path).Scan(&folderID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrFolderNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM folders WHERE id = ?", folderID); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM acl_entries WHERE resource_type = 'folder' AND resource_id = ?", folderID); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	prefix := path + "/"
+	var children int
+	err = tx.QueryRow(
+		"SELECT (SELECT COUNT(*) FROM folders WHERE "+prefixMatch("path")+") + (SELECT COUNT(*) FROM files WHERE "+prefixMatch("original_name")+")",
+		prefix, prefix, prefix, prefix,
+	).Scan(&children)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if children > 0 && !recursive {
+		return nil, ErrFolderNotEmpty
+	}
+
+	// Собираем ключи содержимого всех версий удаляемых файлов
+	rows, err := tx.Query(
+		"SELECT DISTINCT v.stored_key FROM file_versions v JOIN files f ON f.id = v.file_id WHERE "+prefixMatch("f.original_name"),
+		prefix, prefix,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+
+	statements := []string{
+		"DELETE FROM file_versions WHERE file_id IN (SELECT id FROM files WHERE " + prefixMatch("original_name") + ")",
+		"DELETE FROM shares WHERE file_id IN (SELECT id FROM files WHERE " + prefixMatch("original_name") + ")",
+		"DELETE FROM acl_entries WHERE resource_type = 'file' AND resource_id IN (SELECT id FROM files WHERE " + prefixMatch("original_name") + ")",
+		"DELETE FROM acl_entries WHERE resource_type = 'folder' AND resource_id IN (SELECT id FROM folders WHERE " + prefixMatch("path") + ")",
+		"DELETE FROM files WHERE " + prefixMatch("original_name"),
+		"DELETE FROM folders WHERE " + prefixMatch("path"),
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, prefix, prefix); err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"file-exchange-app/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Ошибки, которые хендлеры различают при работе с группами
+var (
+	ErrGroupNotFound = errors.New("group not found")
+	ErrGroupExists   = errors.New("group already exists")
+)
+
+// GroupStore представляет интерфейс для работы с группами пользователей
+type GroupStore interface {
+	CreateGroup(name string) (*models.Group, error)
+	GetGroupByID(groupID int) (*models.Group, error)
+	GetGroupByName(name string) (*models.Group, error)
+	ListGroups() ([]models.Group, error)
+	// SetGroupRole назначает группе роль; roleID 0 снимает роль
+	SetGroupRole(groupID, roleID int) error
+	// DeleteGroup удаляет группу вместе с членством и выданными ей правами
+	DeleteGroup(groupID int) error
+	AddMember(groupID, userID int) error
+	RemoveMember(groupID, userID int) error
+	ListMembers(groupID int) ([]models.User, error)
+}
+
+// SQLiteGroupStore реализация GroupStore для SQLite
+type SQLiteGroupStore struct {
+	db *sql.DB
+}
+
+// NewGroupStore создает новый экземпляр GroupStore
+func NewGroupStore(db *sql.DB) GroupStore {
+	return &SQLiteGroupStore{db: db}
+}
+
+// CreateGroup создает группу
+func (s *SQLiteGroupStore) CreateGroup(name string) (*models.Group, error) {
+	group := &models.Group{Name: name, CreatedAt: time.Now().UTC()}
+	result, err := s.db.Exec("INSERT INTO groups (name, created_at) VALUES (?, ?)", name, group.CreatedAt)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "UNIQUE constraint failed") {
+			return nil, ErrGroupExists
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	group.ID = int(id)
+	return group, nil
+}
+
+// groupColumns столбцы для scanGroup
+const groupColumns = "g.id, g.name, COALESCE(g.role_id, 0), COALESCE(r.name, ''), g.created_at"
+
+// groupJoins соединения для groupColumns
+const groupJoins = " FROM groups g LEFT JOIN roles r ON r.id = g.role_id"
+
+// scanGroup читает строку со столбцами groupColumns
+func scanGroup(row interface{ Scan(...interface{}) error }) (*models.Group, error) {
+	var group models.Group
+	if err := row.Scan(&group.ID, &group.Name, &group.RoleID, &group.RoleName, &group.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// getGroup возвращает группу по условию where с одним параметром
+func (s *SQLiteGroupStore) getGroup(where string, arg interface{}) (*models.Group, error) {
+	group, err := scanGroup(s.db.QueryRow("SELECT "+groupColumns+groupJoins+" WHERE "+where, arg))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrGroupNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return group, nil
+}
+
+// GetGroupByID возвращает группу по ID
+func (s *SQLiteGroupStore) GetGroupByID(groupID int) (*models.Group, error) {
+	return s.getGroup("g.id = ?", groupID)
+}
+
+// GetGroupByName возвращает группу по имени
+func (s *SQLiteGroupStore) GetGroupByName(name string) (*models.Group, error) {
+	return s.getGroup("g.name = ?", name)
+}
+
+// ListGroups возвращает все группы по алфавиту
+func (s *SQLiteGroupStore) ListGroups() ([]models.Group, error) {
+	rows, err := s.db.Query("SELECT " + groupColumns + groupJoins + " ORDER BY g.name")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []models.Group
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan group: %w", err)
+		}
+		groups = append(groups, *group)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return groups, nil
+}
+
+// SetGroupRole назначает или снимает роль группы
+func (s *SQLiteGroupStore) SetGroupRole(groupID, roleID int) error {
+	var role interface{}
+	if roleID != 0 {
+		var exists int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM roles WHERE id = ?", roleID).Scan(&exists); err != nil {
+			return fmt.Errorf("database error: %w", err)
+		}
+		if exists == 0 {
+			return ErrRoleNotFound
+		}
+		role = roleID
+	}
+
+	result, err := s.db.Exec("UPDATE groups SET role_id = ? WHERE id = ?", role, groupID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrGroupNotFound)
+}
+
+// DeleteGroup удаляет группу
+func (s *SQLiteGroupStore) DeleteGroup(groupID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM groups WHERE id = ?", groupID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if err := checkAffected(result, ErrGroupNotFound); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM group_members WHERE group_id = ?", groupID); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM acl_entries WHERE principal_type = ? AND principal_id = ?", models.PrincipalGroup, groupID); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// AddMember добавляет пользователя в группу; повторное добавление не считается ошибкой
+func (s *SQLiteGroupStore) AddMember(groupID, userID int) error {
+	if _, err := s.GetGroupByID(groupID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("INSERT OR IGNORE INTO group_members (group_id, user_id) VALUES (?, ?)", groupID, userID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// RemoveMember исключает пользователя из группы
+func (s *SQLiteGroupStore) RemoveMember(groupID, userID int) error {
+	result, err := s.db.Exec("DELETE FROM group_members WHERE group_id = ? AND user_id = ?", groupID, userID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrUserNotFound)
+}
+
+// ListMembers возвращает участников группы
+func (s *SQLiteGroupStore) ListMembers(groupID int) ([]models.User, error) {
+	return queryUsers(s.db,
+		"SELECT "+userColumns+userJoins+" JOIN group_members m ON m.user_id = u.id WHERE m.group_id = ? ORDER BY u.username",
+		groupID,
+	)
+}
@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"file-exchange-app/config"
+	"file-exchange-app/ldap"
+	"file-exchange-app/models"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// LDAPRoleMapping сопоставляет группу каталога роли приложения. Group - DN группы
+// или только ее cn.
+type LDAPRoleMapping struct {
+	Group string
+	Role  string
+}
+
+// LDAPConfig параметры входа через LDAP/Active Directory
+type LDAPConfig struct {
+	URL      string // ldap://host:389 или ldaps://host:636
+	StartTLS bool
+	TLS      *tls.Config
+
+	// BindDN и BindPassword служебной учетной записи для поиска; пустой BindDN - анонимный поиск
+	BindDN       string
+	BindPassword string
+
+	BaseDN string
+	// UserFilter фильтр поиска пользователя, {username} заменяется введенным именем
+	UserFilter string
+	// UsernameAttribute атрибут, значение которого становится именем пользователя в приложении
+	UsernameAttribute string
+
+	// GroupAttribute атрибут записи пользователя со списком его групп (memberOf)
+	GroupAttribute string
+	// GroupFilter, если задан, ищет группы отдельно; {dn} заменяется DN пользователя, {username} - именем
+	GroupFilter string
+	GroupBaseDN string
+
+	// RoleMapping проверяется по порядку, побеждает первое совпадение
+	RoleMapping []LDAPRoleMapping
+	// DefaultRole роль пользователя без подходящей группы; пустая строка запрещает ему вход
+	DefaultRole string
+
+	Timeout time.Duration
+}
+
+// ldapAuthProvider проверяет пароль в каталоге и создает пользователя при первом входе
+type ldapAuthProvider struct {
+	cfg   LDAPConfig
+	users UserStore
+	roles RoleStore
+}
+
+// NewLDAPAuthProvider создает провайдер входа через LDAP. Роль пользователя определяется
+// по его группам при каждом входе, так что изменения в каталоге применяются со следующего входа.
+func NewLDAPAuthProvider(cfg LDAPConfig, users UserStore, roles RoleStore) AuthProvider {
+	return &ldapAuthProvider{cfg: cfg, users: users, roles: roles}
+}
+
+func (p *ldapAuthProvider) Name() string {
+	return models.AuthSourceLDAP
+}
+
+// Authenticate ищет пользователя служебной учетной записью, проверяет пароль bind'ом
+// от его имени и назначает роль по группам
+func (p *ldapAuthProvider) Authenticate(username, password string) (*models.User, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := p.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if errors.Is(err, ldap.ErrInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap bind as %s: %w", entry.DN, err)
+	}
+
+	groups, err := p.userGroups(conn, entry)
+	if err != nil {
+		return nil, err
+	}
+	roleName := p.cfg.roleFor(groups)
+	if roleName == "" {
+		return nil, ErrNotPermitted
+	}
+	role, err := p.roles.GetRoleByName(roleName)
+	if err != nil {
+		return nil, fmt.Errorf("ldap role %q: %w", roleName, err)
+	}
+
+	name := username
+	if values := entry.Values(p.cfg.UsernameAttribute); len(values) > 0 && values[0] != "" {
+		name = values[0]
+	}
+	user, err := p.users.ProvisionUser(ExternalAccount{Username: name, Source: models.AuthSourceLDAP, RoleID: role.ID})
+	if errors.Is(err, ErrUserExists) {
+		log.Printf("LDAP user %s conflicts with an account from another source, sign-in refused", name)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+	return user, nil
+}
+
+// connect подключается к серверу и входит служебной учетной записью
+func (p *ldapAuthProvider) connect() (*ldap.Conn, error) {
+	conn, err := ldap.Dial(p.cfg.URL, p.cfg.TLS, p.cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if p.cfg.StartTLS {
+		if err := conn.StartTLS(p.cfg.TLS); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if err := p.serviceBind(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// serviceBind входит служебной учетной записью, если она задана
+func (p *ldapAuthProvider) serviceBind(conn *ldap.Conn) error {
+	if p.cfg.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+		return fmt.Errorf("ldap service bind: %w", err)
+	}
+	return nil
+}
+
+// findUser находит единственную запись пользователя
+func (p *ldapAuthProvider) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(p.cfg.UserFilter, "{username}", ldap.EscapeFilter(username))
+	entries, err := conn.Search(ldap.SearchRequest{
+		BaseDN:     p.cfg.BaseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     filter,
+		Attributes: []string{p.cfg.UsernameAttribute, p.cfg.GroupAttribute},
+		SizeLimit:  2,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ldap user search: %w", err)
+	}
+	switch len(entries) {
+	case 0:
+		return nil, ErrInvalidCredentials
+	case 1:
+		return &entries[0], nil
+	default:
+		return nil, fmt.Errorf("ldap user filter %q matches more than one entry", filter)
+	}
+}
+
+// userGroups возвращает DN групп пользователя: из атрибута записи и, если задан
+// GroupFilter, из отдельного поиска
+func (p *ldapAuthProvider) userGroups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	groups := entry.Values(p.cfg.GroupAttribute)
+	if p.cfg.GroupFilter == "" {
+		return groups, nil
+	}
+
+	// После bind'а пользователя соединение работает от его имени, а ему поиск групп
+	// может быть запрещен
+	if err := p.serviceBind(conn); err != nil {
+		return nil, err
+	}
+
+	baseDN := p.cfg.GroupBaseDN
+	if baseDN == "" {
+		baseDN = p.cfg.BaseDN
+	}
+	filter := strings.NewReplacer(
+		"{dn}", ldap.EscapeFilter(entry.DN),
+		"{username}", ldap.EscapeFilter(firstValue(entry.Values(p.cfg.UsernameAttribute))),
+	).Replace(p.cfg.GroupFilter)
+	entries, err := conn.Search(ldap.SearchRequest{
+		BaseDN:     baseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     filter,
+		Attributes: []string{"cn"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ldap group search: %w", err)
+	}
+	for _, group := range entries {
+		groups = append(groups, group.DN)
+	}
+	return groups, nil
+}
+
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// roleFor выбирает роль по группам пользователя
+func (cfg LDAPConfig) roleFor(groups []string) string {
+	for _, mapping := range cfg.RoleMapping {
+		for _, group := range groups {
+			if groupMatches(mapping.Group, group) {
+				return mapping.Role
+			}
+		}
+	}
+	return cfg.DefaultRole
+}
+
+// checkRoles проверяет при старте, что роли из настроек существуют
+func (cfg LDAPConfig) checkRoles(roles RoleStore) error {
+	names := []string{cfg.DefaultRole}
+	for _, mapping := range cfg.RoleMapping {
+		names = append(names, mapping.Role)
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if _, err := roles.GetRoleByName(name); err != nil {
+			return fmt.Errorf("LDAP role %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// groupMatches сравнивает группу из настроек (DN или cn) с DN группы из каталога
+func groupMatches(configured, groupDN string) bool {
+	if strings.Contains(configured, "=") {
+		return normalizeDN(configured) == normalizeDN(groupDN)
+	}
+	first := strings.SplitN(groupDN, ",", 2)[0]
+	attr, value, ok := strings.Cut(first, "=")
+	return ok && strings.EqualFold(strings.TrimSpace(attr), "cn") && strings.EqualFold(strings.TrimSpace(value), configured)
+}
+
+// normalizeDN приводит DN к виду для сравнения: без регистра и пробелов вокруг разделителей
+func normalizeDN(dn string) string {
+	parts := strings.Split(strings.ToLower(dn), ",")
+	for i, part := range parts {
+		attr, value, _ := strings.Cut(part, "=")
+		parts[i] = strings.TrimSpace(attr) + "=" + strings.TrimSpace(value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// newLDAPConfig переводит параметры из конфигурации и загружает сертификаты CA
+func newLDAPConfig(cfg config.LDAPConfig) (LDAPConfig, error) {
+	ldapConfig := LDAPConfig{
+		URL:               cfg.URL,
+		StartTLS:          cfg.StartTLS,
+		TLS:               &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify},
+		BindDN:            cfg.BindDN,
+		BindPassword:      cfg.BindPassword,
+		BaseDN:            cfg.BaseDN,
+		UserFilter:        cfg.UserFilter,
+		UsernameAttribute: cfg.UsernameAttribute,
+		GroupAttribute:    cfg.GroupAttribute,
+		GroupFilter:       cfg.GroupFilter,
+		GroupBaseDN:       cfg.GroupBaseDN,
+		DefaultRole:       cfg.DefaultRole,
+		Timeout:           cfg.Timeout,
+	}
+	for _, m := range cfg.RoleMapping {
+		ldapConfig.RoleMapping = append(ldapConfig.RoleMapping, LDAPRoleMapping{Group: m.Group, Role: m.Role})
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return ldapConfig, fmt.Errorf("ldap.ca_file: %w", err)
+		}
+		ldapConfig.TLS.RootCAs = x509.NewCertPool()
+		if !ldapConfig.TLS.RootCAs.AppendCertsFromPEM(pem) {
+			return ldapConfig, fmt.Errorf("ldap.ca_file: no certificates found in %s", cfg.CAFile)
+		}
+	}
+	return ldapConfig, nil
+}
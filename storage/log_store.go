@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"database/sql"
+	"file-exchange-app/models"
+	"fmt"
+	"strings"
+)
+
+// LogFilter задает условия выборки записей из журнала действий
+type LogFilter struct {
+	Username string
+	Action   string
+	Limit    int
+	Offset   int
+}
+
+// LogStore представляет интерфейс для работы с журналом действий
+type LogStore interface {
+	AddLog(username, action, filename string) error
+	GetLogs(filter LogFilter) ([]models.LogEntry, error)
+}
+
+// SQLiteLogStore реализация LogStore для SQLite
+type SQLiteLogStore struct {
+	db *sql.DB
+}
+
+// NewLogStore создает новый экземпляр LogStore
+func NewLogStore(db *sql.DB) LogStore {
+	return &SQLiteLogStore{db: db}
+}
+
+// AddLog добавляет запись в журнал действий
+func (s *SQLiteLogStore) AddLog(username, action, filename string) error {
+	_, err := s.db.Exec(
+		"INSERT INTO logs (username, action, filename) VALUES (?, ?, ?)",
+		username, action, filename,
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// GetLogs возвращает записи журнала, начиная с самых новых
+func (s *SQLiteLogStore) GetLogs(filter LogFilter) ([]models.LogEntry, error) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.Username != "" {
+		conditions = append(conditions, "username = ?")
+		args = append(args, filter.Username)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+
+	query := "SELECT id, username, action, COALESCE(filename, ''), timestamp FROM logs"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// По умолчанию отдаем последние 100 записей, как в админке
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	query += " ORDER BY timestamp DESC, id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, filter.Offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []models.LogEntry
+	for rows.Next() {
+		var entry models.LogEntry
+		err := rows.Scan(&entry.ID, &entry.Username, &entry.Action, &entry.Filename, &entry.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan log entry: %w", err)
+		}
+		logs = append(logs, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return logs, nil
+}
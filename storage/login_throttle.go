@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"file-exchange-app/config"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ошибки, с которыми вход отклоняется без проверки пароля
+var (
+	ErrLoginThrottled = errors.New("too many failed login attempts")
+	ErrAccountLocked  = errors.New("account is temporarily locked")
+)
+
+// LoginThrottleConfig параметры защиты от подбора паролей
+type LoginThrottleConfig struct {
+	// AccountFreeAttempts и IPFreeAttempts - сколько неудачных попыток подряд проходит без задержки
+	// для одной учетной записи и одного адреса; дальше задержка растет вдвое с каждой попыткой
+	AccountFreeAttempts int
+	IPFreeAttempts      int
+	BaseDelay           time.Duration
+	MaxDelay            time.Duration
+	// LockoutThreshold неудачных попыток подряд блокируют учетную запись на LockoutDuration;
+	// 0 отключает блокировку, остается только задержка
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// Window - через сколько после последней неудачи счетчик начинается заново
+	Window time.Duration
+}
+
+// LoginThrottle ограничивает частоту попыток входа по имени пользователя и по адресу клиента.
+// Имя учитывается и для несуществующих пользователей, чтобы по ответам нельзя было понять,
+// есть ли такая учетная запись.
+type LoginThrottle interface {
+	// Attempt проверяет, можно ли сейчас пробовать войти, и сразу засчитывает попытку
+	// как неудачную, чтобы параллельные запросы не обходили ограничение. Если нельзя,
+	// возвращает ErrLoginThrottled или ErrAccountLocked и время до следующей попытки.
+	Attempt(username, ip string, now time.Time) (time.Duration, error)
+	// Succeeded сбрасывает счетчик учетной записи и возвращает адресу засчитанную попытку
+	Succeeded(username, ip string) error
+	// AttemptShare и ShareSucceeded делают то же для пароля публичной ссылки: вместо
+	// учетной записи счетчик ведется по ссылке, адрес клиента учитывается вместе со входом
+	AttemptShare(shareID int, ip string, now time.Time) (time.Duration, error)
+	ShareSucceeded(shareID int, ip string) error
+	// Cancel отменяет засчитанную попытку, если проверить пароль не удалось по вине сервера
+	Cancel(username, ip string) error
+	// Unlock снимает блокировку и задержку с учетной записи
+	Unlock(username string) error
+	// LockedAccounts возвращает заблокированные учетные записи (имена в нижнем регистре) и срок блокировки
+	LockedAccounts(now time.Time) (map[string]time.Time, error)
+	// DeleteStale удаляет счетчики, которые уже ни на что не влияют
+	DeleteStale(now time.Time) error
+}
+
+// SQLiteLoginThrottle реализация LoginThrottle для SQLite. Счетчики хранятся в БД и
+// переживают перезапуск приложения.
+type SQLiteLoginThrottle struct {
+	db  *sql.DB
+	cfg LoginThrottleConfig
+	// mu делает проверку и учет попытки атомарными
+	mu sync.Mutex
+}
+
+// NewLoginThrottle создает новый экземпляр LoginThrottle
+func NewLoginThrottle(db *sql.DB, cfg LoginThrottleConfig) LoginThrottle {
+	return &SQLiteLoginThrottle{db: db, cfg: cfg}
+}
+
+// loginCounter состояние счетчика неудачных попыток
+type loginCounter struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Ключи счетчиков: имя пользователя без учета регистра (LDAP и AD его не различают) и адрес
+func accountKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func shareKey(shareID int) string {
+	return "share:" + strconv.Itoa(shareID)
+}
+
+// Attempt проверяет ограничения и засчитывает попытку
+func (t *SQLiteLoginThrottle) Attempt(username, ip string, now time.Time) (time.Duration, error) {
+	return t.attempt(accountKey(username), ip, now)
+}
+
+// AttemptShare проверяет ограничения и засчитывает попытку ввода пароля ссылки
+func (t *SQLiteLoginThrottle) AttemptShare(shareID int, ip string, now time.Time) (time.Duration, error) {
+	return t.attempt(shareKey(shareID), ip, now)
+}
+
+// attempt проверяет и засчитывает попытку для счетчика key (учетной записи или ссылки) и адреса
+func (t *SQLiteLoginThrottle) attempt(key, ip string, now time.Time) (time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now = now.UTC()
+
+	account, err := t.load(key, now)
+	if err != nil {
+		return 0, err
+	}
+	address, err := t.load(ipKey(ip), now)
+	if err != nil {
+		return 0, err
+	}
+
+	if now.Before(account.lockedUntil) {
+		return account.lockedUntil.Sub(now), ErrAccountLocked
+	}
+	wait := t.backoff(account, t.cfg.AccountFreeAttempts, now)
+	if ipWait := t.backoff(address, t.cfg.IPFreeAttempts, now); ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		return wait, ErrLoginThrottled
+	}
+
+	account.failures++
+	account.lastFailure = now
+	if t.cfg.LockoutThreshold > 0 && account.failures >= t.cfg.LockoutThreshold {
+		// Эта попытка еще проверяется, а следующие ждут окончания блокировки.
+		// После нее счет начинается заново.
+		account.failures = 0
+		account.lockedUntil = now.Add(t.cfg.LockoutDuration)
+	}
+	if err := t.save(key, account); err != nil {
+		return 0, err
+	}
+	address.failures++
+	address.lastFailure = now
+	return 0, t.save(ipKey(ip), address)
+}
+
+// backoff возвращает, сколько еще ждать до следующей попытки
+func (t *SQLiteLoginThrottle) backoff(counter loginCounter, free int, now time.Time) time.Duration {
+	excess := counter.failures - free
+	if excess <= 0 {
+		return 0
+	}
+	delay := t.cfg.MaxDelay
+	if excess <= 30 && t.cfg.BaseDelay<<(excess-1) < t.cfg.MaxDelay {
+		delay = t.cfg.BaseDelay << (excess - 1)
+	}
+	return counter.lastFailure.Add(delay).Sub(now)
+}
+
+// load читает счетчик; счетчик, у которого истекло окно и нет блокировки, считается нулевым
+func (t *SQLiteLoginThrottle) load(key string, now time.Time) (loginCounter, error) {
+	var counter loginCounter
+	var lockedUntil sql.NullTime
+	err := t.db.QueryRow("SELECT failures, last_failure_at, locked_until FROM login_failures WHERE key = ?", key).
+		Scan(&counter.failures, &counter.lastFailure, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return loginCounter{}, nil
+	}
+	if err != nil {
+		return loginCounter{}, fmt.Errorf("database error: %w", err)
+	}
+	if lockedUntil.Valid {
+		counter.lockedUntil = lockedUntil.Time
+	}
+	if now.Sub(counter.lastFailure) > t.cfg.Window {
+		counter.failures = 0
+	}
+	return counter, nil
+}
+
+// save записывает счетчик
+func (t *SQLiteLoginThrottle) save(key string, counter loginCounter) error {
+	var lockedUntil *time.Time
+	if !counter.lockedUntil.IsZero() {
+		lockedUntil = &counter.lockedUntil
+	}
+	_, err := t.db.Exec(`
+		INSERT INTO login_failures (key, failures, last_failure_at, locked_until) VALUES (?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET failures = excluded.failures,
+			last_failure_at = excluded.last_failure_at, locked_until = excluded.locked_until`,
+		key, counter.failures, counter.lastFailure, lockedUntil,
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// Succeeded сбрасывает счетчик учетной записи после успешного входа
+func (t *SQLiteLoginThrottle) Succeeded(username, ip string) error {
+	return t.succeeded(accountKey(username), ip)
+}
+
+// ShareSucceeded сбрасывает счетчик ссылки после верного пароля
+func (t *SQLiteLoginThrottle) ShareSucceeded(shareID int, ip string) error {
+	return t.succeeded(shareKey(shareID), ip)
+}
+
+// succeeded удаляет счетчик key и возвращает адресу засчитанную попытку
+func (t *SQLiteLoginThrottle) succeeded(key, ip string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, err := t.db.Exec("DELETE FROM login_failures WHERE key = ?", key); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return t.release(ipKey(ip))
+}
+
+// Cancel возвращает засчитанную попытку учетной записи и адресу
+func (t *SQLiteLoginThrottle) Cancel(username, ip string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.release(accountKey(username)); err != nil {
+		return err
+	}
+	return t.release(ipKey(ip))
+}
+
+// release уменьшает счетчик на одну попытку
+func (t *SQLiteLoginThrottle) release(key string) error {
+	_, err := t.db.Exec("UPDATE login_failures SET failures = failures - 1 WHERE key = ? AND failures > 0", key)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// Unlock снимает блокировку с учетной записи
+func (t *SQLiteLoginThrottle) Unlock(username string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, err := t.db.Exec("DELETE FROM login_failures WHERE key = ?", accountKey(username)); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// LockedAccounts возвращает учетные записи с действующей блокировкой
+func (t *SQLiteLoginThrottle) LockedAccounts(now time.Time) (map[string]time.Time, error) {
+	rows, err := t.db.Query("SELECT key, locked_until FROM login_failures WHERE key LIKE 'user:%' AND locked_until > ?", now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	locked := make(map[string]time.Time)
+	for rows.Next() {
+		var key string
+		var until time.Time
+		if err := rows.Scan(&key, &until); err != nil {
+			return nil, fmt.Errorf("failed to scan login failures: %w", err)
+		}
+		locked[strings.TrimPrefix(key, "user:")] = until
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return locked, nil
+}
+
+// DeleteStale удаляет счетчики с истекшим окном и без действующей блокировки
+func (t *SQLiteLoginThrottle) DeleteStale(now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now = now.UTC()
+	_, err := t.db.Exec(
+		"DELETE FROM login_failures WHERE last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)",
+		now.Add(-t.cfg.Window), now,
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// loginThrottleConfig переводит настройки входа в параметры LoginThrottle.
+// Начальная задержка - 1s, дальше удваивается до MaxDelay.
+func loginThrottleConfig(cfg config.LoginConfig) LoginThrottleConfig {
+	return LoginThrottleConfig{
+		AccountFreeAttempts: cfg.FreeAttempts,
+		IPFreeAttempts:      cfg.IPFreeAttempts,
+		BaseDelay:           time.Second,
+		MaxDelay:            cfg.MaxDelay,
+		LockoutThreshold:    cfg.LockoutThreshold,
+		LockoutDuration:     cfg.LockoutDuration,
+		Window:              cfg.FailureWindow,
+	}
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"errors"
+	"file-exchange-app/config"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// initTestDB создает базу во временной папке и хранилища поверх нее
+func initTestDB(t *testing.T) {
+	t.Helper()
+	appConfig := config.Default()
+	appConfig.Database.Path = filepath.Join(t.TempDir(), "test.db")
+	if err := InitDB(appConfig); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { DB.Close() })
+}
+
+// newTestThrottle создает базу во временной папке и LoginThrottle поверх нее
+func newTestThrottle(t *testing.T, cfg LoginThrottleConfig) LoginThrottle {
+	t.Helper()
+	initTestDB(t)
+	return NewLoginThrottle(DB, cfg)
+}
+
+var testThrottleConfig = LoginThrottleConfig{
+	AccountFreeAttempts: 3,
+	IPFreeAttempts:      5,
+	BaseDelay:           time.Second,
+	MaxDelay:            8 * time.Second,
+	LockoutThreshold:    7,
+	LockoutDuration:     15 * time.Minute,
+	Window:              15 * time.Minute,
+}
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	throttle := &SQLiteLoginThrottle{cfg: testThrottleConfig}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 8 * time.Second},
+		{40, 8 * time.Second},
+		{1000, 8 * time.Second},
+	}
+	for _, tt := range tests {
+		counter := loginCounter{failures: tt.failures, lastFailure: now}
+		if got := throttle.backoff(counter, testThrottleConfig.AccountFreeAttempts, now); got != tt.want {
+			t.Errorf("backoff after %d failures = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+
+	// Задержка отсчитывается от последней неудачи
+	counter := loginCounter{failures: 6, lastFailure: now}
+	if got := throttle.backoff(counter, 3, now.Add(3*time.Second)); got != time.Second {
+		t.Errorf("backoff 3s after the failure = %s, want 1s", got)
+	}
+	if got := throttle.backoff(counter, 3, now.Add(time.Minute)); got > 0 {
+		t.Errorf("backoff after the delay passed = %s, want none", got)
+	}
+}
+
+func TestLoginThrottleLockout(t *testing.T) {
+	throttle := newTestThrottle(t, testThrottleConfig)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+
+	// Каждая попытка с разного адреса, чтобы сработало только ограничение учетной записи
+	steps := []struct {
+		advance  time.Duration
+		wantWait time.Duration
+		wantErr  error
+	}{
+		{0, 0, nil}, // 1
+		{0, 0, nil}, // 2
+		{0, 0, nil}, // 3
+		{0, 0, nil}, // 4: после трех бесплатных неудач попытка еще без задержки
+		{0, time.Second, ErrLoginThrottled},
+		{time.Second, 0, nil}, // 5
+		{time.Second, time.Second, ErrLoginThrottled},
+		{time.Second, 0, nil}, // 6
+		{0, 4 * time.Second, ErrLoginThrottled},
+		{4 * time.Second, 0, nil}, // 7: достигнут порог, учетная запись блокируется
+		{0, 15 * time.Minute, ErrAccountLocked},
+		{10 * time.Minute, 5 * time.Minute, ErrAccountLocked},
+		{5 * time.Minute, 0, nil}, // блокировка истекла, счет начат заново
+		{0, 0, nil},
+	}
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		wait, err := throttle.Attempt("Alice", fmt.Sprintf("10.0.0.%d", i), now)
+		if !errors.Is(err, step.wantErr) || wait != step.wantWait {
+			t.Fatalf("step %d at +%s: Attempt = (%s, %v), want (%s, %v)", i, now.Sub(start), wait, err, step.wantWait, step.wantErr)
+		}
+	}
+}
+
+func TestLoginThrottleLockedAccounts(t *testing.T) {
+	throttle := newTestThrottle(t, testThrottleConfig)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < testThrottleConfig.LockoutThreshold; i++ {
+		now = now.Add(testThrottleConfig.MaxDelay)
+		if _, err := throttle.Attempt("Alice", "10.0.0.1", now); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+
+	// Имя сравнивается без учета регистра
+	if _, err := throttle.Attempt("ALICE", "10.0.0.2", now); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("attempt for a locked account: %v, want ErrAccountLocked", err)
+	}
+	locked, err := throttle.LockedAccounts(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until, ok := locked["alice"]; !ok || !until.Equal(now.Add(testThrottleConfig.LockoutDuration)) {
+		t.Fatalf("LockedAccounts = %v, want alice until %s", locked, now.Add(testThrottleConfig.LockoutDuration))
+	}
+
+	if err := throttle.Unlock("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := throttle.Attempt("Alice", "10.0.0.3", now); err != nil {
+		t.Fatalf("attempt after Unlock: %v", err)
+	}
+}
+
+func TestLoginThrottleByAddress(t *testing.T) {
+	throttle := newTestThrottle(t, testThrottleConfig)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Перебор разных имен с одного адреса упирается в ограничение адреса
+	names := []string{"a", "b", "c", "d", "e", "f"}
+	for _, name := range names {
+		if _, err := throttle.Attempt(name, "10.0.0.1", now); err != nil {
+			t.Fatalf("attempt for %s: %v", name, err)
+		}
+	}
+	if wait, err := throttle.Attempt("g", "10.0.0.1", now); !errors.Is(err, ErrLoginThrottled) || wait != time.Second {
+		t.Fatalf("seventh name from one address: (%s, %v), want (1s, ErrLoginThrottled)", wait, err)
+	}
+	if _, err := throttle.Attempt("g", "10.0.0.2", now); err != nil {
+		t.Fatalf("same name from another address: %v", err)
+	}
+
+	// Успешный вход возвращает адресу одну попытку
+	if err := throttle.Succeeded("g", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := throttle.Attempt("h", "10.0.0.1", now); err != nil {
+		t.Fatalf("attempt after a successful login: %v", err)
+	}
+}
+
+func TestLoginThrottleWindowAndCancel(t *testing.T) {
+	throttle := newTestThrottle(t, testThrottleConfig)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		if _, err := throttle.Attempt("alice", "10.0.0.1", now); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+	if _, err := throttle.Attempt("alice", "10.0.0.1", now); !errors.Is(err, ErrLoginThrottled) {
+		t.Fatalf("fifth attempt: %v, want ErrLoginThrottled", err)
+	}
+
+	// Отмененная попытка (ошибка сервера при проверке пароля) не засчитывается
+	if err := throttle.Cancel("alice", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := throttle.Attempt("alice", "10.0.0.1", now); err != nil {
+		t.Fatalf("attempt after Cancel: %v", err)
+	}
+
+	// После окна без неудач счетчик начинается заново
+	now = now.Add(testThrottleConfig.Window + time.Second)
+	for i := 0; i < 4; i++ {
+		if _, err := throttle.Attempt("alice", "10.0.0.1", now); err != nil {
+			t.Fatalf("attempt %d after the window: %v", i+1, err)
+		}
+	}
+}
+
+func TestLoginThrottleShare(t *testing.T) {
+	throttle := newTestThrottle(t, testThrottleConfig)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		if _, err := throttle.AttemptShare(1, fmt.Sprintf("10.0.0.%d", i), now); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+	// Пароль ссылки подбирают с разных адресов, но счетчик у ссылки один
+	if wait, err := throttle.AttemptShare(1, "10.0.1.1", now); !errors.Is(err, ErrLoginThrottled) || wait != time.Second {
+		t.Fatalf("fifth attempt: (%s, %v), want (1s, ErrLoginThrottled)", wait, err)
+	}
+	// Счетчики других ссылок и учетных записей не затронуты
+	if _, err := throttle.AttemptShare(2, "10.0.1.1", now); err != nil {
+		t.Fatalf("another share: %v", err)
+	}
+	if _, err := throttle.Attempt("1", "10.0.1.1", now); err != nil {
+		t.Fatalf("user with the same name as the share ID: %v", err)
+	}
+	locked, err := throttle.LockedAccounts(now)
+	if err != nil || len(locked) != 0 {
+		t.Fatalf("LockedAccounts() = %v, %v, want none", locked, err)
+	}
+
+	if err := throttle.ShareSucceeded(1, "10.0.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := throttle.AttemptShare(1, "10.0.1.1", now); err != nil {
+		t.Fatalf("attempt after the right password: %v", err)
+	}
+}
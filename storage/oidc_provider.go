@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"file-exchange-app/config"
+	"file-exchange-app/models"
+	"file-exchange-app/oidc"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// OIDCConfig параметры единого входа через OpenID Connect
+type OIDCConfig struct {
+	oidc.Config
+	// DisplayName название провайдера на кнопке входа
+	DisplayName string
+	// UsernameClaim утверждение с именем пользователя в приложении
+	UsernameClaim string
+	// GroupsClaim утверждение со списком групп или ролей; можно указать путь через точку
+	GroupsClaim string
+	// Значения GroupsClaim, дающие права. Администратор может и загружать, и скачивать,
+	// загружающий - и скачивать. Пустой DownloadValues дает право скачивания всем,
+	// иначе пользователь без подходящих значений не может войти.
+	AdminValues    []string
+	UploadValues   []string
+	DownloadValues []string
+}
+
+// OIDCSignIn единый вход через OpenID Connect
+type OIDCSignIn struct {
+	cfg      OIDCConfig
+	provider *oidc.Provider
+	users    UserStore
+}
+
+// OIDCSignInInstance единый вход; nil, если он не настроен
+var OIDCSignInInstance *OIDCSignIn
+
+// NewOIDCSignIn создает единый вход. Права пользователя определяются по утверждениям
+// ID-токена при каждом входе.
+func NewOIDCSignIn(cfg OIDCConfig, users UserStore) *OIDCSignIn {
+	return &OIDCSignIn{cfg: cfg, provider: oidc.NewProvider(cfg.Config, nil), users: users}
+}
+
+// DisplayName возвращает название провайдера для страницы входа
+func (s *OIDCSignIn) DisplayName() string {
+	return s.cfg.DisplayName
+}
+
+// AuthCodeURL возвращает адрес, на который нужно отправить пользователя для входа
+func (s *OIDCSignIn) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	return s.provider.AuthCodeURL(ctx, state, nonce, verifier)
+}
+
+// Finish завершает вход: обменивает код на ID-токен, проверяет его и создает
+// или обновляет пользователя
+func (s *OIDCSignIn) Finish(ctx context.Context, code, verifier, nonce string) (*models.User, error) {
+	claims, err := s.provider.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		return nil, err
+	}
+	account, err := s.cfg.account(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.ProvisionUser(account)
+	if errors.Is(err, ErrUserExists) {
+		log.Printf("OIDC user %s (sub %s) conflicts with an existing account, sign-in refused", account.Username, account.ExternalID)
+		return nil, ErrNotPermitted
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+	return user, nil
+}
+
+// account переводит утверждения ID-токена в пользователя приложения
+func (cfg OIDCConfig) account(claims oidc.Claims) (ExternalAccount, error) {
+	username := claims.String(cfg.UsernameClaim)
+	if username == "" {
+		return ExternalAccount{}, fmt.Errorf("oidc: ID token has no %s claim", cfg.UsernameClaim)
+	}
+
+	values := claims.Strings(cfg.GroupsClaim)
+	account := ExternalAccount{
+		Username:   username,
+		Source:     models.AuthSourceOIDC,
+		ExternalID: claims.String("sub"),
+		IsAdmin:    matchesAny(values, cfg.AdminValues),
+	}
+	account.CanUpload = account.IsAdmin || matchesAny(values, cfg.UploadValues)
+	account.CanDownload = account.CanUpload || len(cfg.DownloadValues) == 0 || matchesAny(values, cfg.DownloadValues)
+	if !account.CanDownload {
+		return ExternalAccount{}, ErrNotPermitted
+	}
+	return account, nil
+}
+
+// matchesAny сообщает, есть ли среди values хотя бы одно из wanted
+func matchesAny(values, wanted []string) bool {
+	for _, value := range values {
+		for _, w := range wanted {
+			if value == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newOIDCConfig переводит параметры единого входа из конфигурации
+func newOIDCConfig(cfg config.OIDCConfig) OIDCConfig {
+	oidcConfig := OIDCConfig{
+		Config: oidc.Config{
+			Issuer:       cfg.Issuer,
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       strings.Fields(cfg.Scopes),
+		},
+		DisplayName:    cfg.DisplayName,
+		UsernameClaim:  cfg.UsernameClaim,
+		GroupsClaim:    cfg.GroupsClaim,
+		AdminValues:    cfg.AdminValues,
+		UploadValues:   cfg.UploadValues,
+		DownloadValues: cfg.DownloadValues,
+	}
+	if !matchesAny(oidcConfig.Scopes, []string{"openid"}) {
+		oidcConfig.Scopes = append([]string{"openid"}, oidcConfig.Scopes...)
+	}
+	return oidcConfig
+}
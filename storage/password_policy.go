@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"errors"
+	"file-exchange-app/config"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrWeakPassword возвращается, если пароль не соответствует правилам сложности
+var ErrWeakPassword = errors.New("password is too weak")
+
+// PasswordPolicy правила сложности паролей, которые UserStore проверяет при
+// создании пользователя и смене пароля
+type PasswordPolicy struct {
+	MinLength      int
+	RequireMixed   bool // строчные и заглавные буквы
+	RequireDigit   bool
+	RequireSpecial bool // символ, не являющийся буквой или цифрой
+}
+
+// Validate проверяет пароль; ошибка перечисляет нарушенные правила и оборачивает ErrWeakPassword
+func (p PasswordPolicy) Validate(password string) error {
+	var lower, upper, digit, special bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			special = true
+		}
+	}
+
+	var problems []string
+	if len([]rune(password)) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("at least %d characters", p.MinLength))
+	}
+	if p.RequireMixed && !(lower && upper) {
+		problems = append(problems, "both lowercase and uppercase letters")
+	}
+	if p.RequireDigit && !digit {
+		problems = append(problems, "a digit")
+	}
+	if p.RequireSpecial && !special {
+		problems = append(problems, "a special character")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: must contain %s", ErrWeakPassword, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// passwordPolicy переводит настройки паролей в PasswordPolicy
+func passwordPolicy(cfg config.PasswordConfig) PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:      cfg.MinLength,
+		RequireMixed:   cfg.RequireMixedCase,
+		RequireDigit:   cfg.RequireDigit,
+		RequireSpecial: cfg.RequireSpecial,
+	}
+}
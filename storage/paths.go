@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrInvalidPath возвращается для путей с пустыми сегментами, "." и ".."
+var ErrInvalidPath = errors.New("invalid path")
+
+// CleanPath проверяет логический путь к файлу или папке и приводит его к виду "a/b/c".
+// Пути живут только в БД (содержимое лежит под случайными ключами), но все равно не
+// допускаем сегменты, которые могли бы означать выход за пределы корня хранилища.
+// Пустая строка означает корень.
+func CleanPath(p string) (string, error) {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return "", nil
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, "\\\x00") {
+			return "", ErrInvalidPath
+		}
+	}
+	return p, nil
+}
+
+// JoinPath соединяет папку и имя в логический путь
+func JoinPath(folder, name string) string {
+	if folder == "" {
+		return name
+	}
+	return folder + "/" + name
+}
+
+// ParentPath возвращает папку, в которой лежит путь ("" для корня)
+func ParentPath(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// BaseName возвращает последний сегмент пути
+func BaseName(p string) string {
+	return path.Base(p)
+}
+
+// Ограничения имен новых файлов и папок
+const (
+	// MaxNameLength предельная длина одного сегмента в байтах UTF-8, как в большинстве файловых систем
+	MaxNameLength = 255
+	// MaxPathLength предельная длина всего пути в байтах
+	MaxPathLength = 1024
+)
+
+// reservedNames имена устройств Windows: файл с таким именем (и любым расширением)
+// нельзя сохранить на Windows после скачивания
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// NormalizeName проверяет имя нового файла или папки (один сегмент пути) и приводит его
+// к форме Unicode NFC, чтобы одно и то же имя, набранное на macOS и на Windows, не
+// давало двух разных файлов. Ошибка оборачивает ErrInvalidPath и объясняет причину.
+func NormalizeName(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("%w: name is not valid UTF-8", ErrInvalidPath)
+	}
+	name = norm.NFC.String(name)
+
+	switch {
+	case name == "" || name == "." || name == "..":
+		return "", fmt.Errorf("%w: name is empty", ErrInvalidPath)
+	case strings.ContainsAny(name, "/\\"):
+		return "", fmt.Errorf("%w: name must not contain slashes", ErrInvalidPath)
+	case len(name) > MaxNameLength:
+		return "", fmt.Errorf("%w: name is longer than %d bytes", ErrInvalidPath, MaxNameLength)
+	case strings.HasSuffix(name, ".") || strings.HasSuffix(name, " "):
+		return "", fmt.Errorf("%w: name must not end with a dot or a space", ErrInvalidPath)
+	}
+	for _, c := range name {
+		if unicode.IsControl(c) {
+			return "", fmt.Errorf("%w: name must not contain control characters", ErrInvalidPath)
+		}
+	}
+	stem, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		return "", fmt.Errorf("%w: %q is a reserved name", ErrInvalidPath, stem)
+	}
+	return name, nil
+}
+
+// NormalizePath проверяет путь нового файла или папки: каждый сегмент через NormalizeName
+// и общую длину. Пустая строка означает корень.
+func NormalizePath(p string) (string, error) {
+	clean, err := CleanPath(p)
+	if err != nil || clean == "" {
+		return clean, err
+	}
+	segments := strings.Split(clean, "/")
+	for i, segment := range segments {
+		if segments[i], err = NormalizeName(segment); err != nil {
+			return "", err
+		}
+	}
+	clean = strings.Join(segments, "/")
+	if len(clean) > MaxPathLength {
+		return "", fmt.Errorf("%w: path is longer than %d bytes", ErrInvalidPath, MaxPathLength)
+	}
+	return clean, nil
+}
+
+// NumberedName добавляет к имени файла номер перед расширением: "report.pdf" -> "report (2).pdf"
+func NumberedName(p string, n int) string {
+	dir, base := ParentPath(p), BaseName(p)
+	ext := path.Ext(base)
+	if ext == base {
+		// Имя вроде ".bashrc" целиком считается расширением
+		ext = ""
+	}
+	return JoinPath(dir, fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(base, ext), n, ext))
+}
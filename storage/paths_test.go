@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // пустая строка - имя отклоняется
+	}{
+		{"report.pdf", "report.pdf"},
+		{"отчет 2024.docx", "отчет 2024.docx"},
+		{".bashrc", ".bashrc"},
+		{"a b", "a b"},
+		// NFD (как набирает macOS) приводится к NFC
+		{"Cafe\u0301.txt", "Caf\u00e9.txt"},
+		{"\u0438\u0306", "\u0439"},
+		{strings.Repeat("a", MaxNameLength), strings.Repeat("a", MaxNameLength)},
+		// Похожие на зарезервированные, но другие имена
+		{"CONFIG.sys", "CONFIG.sys"},
+		{"COM10", "COM10"},
+		{"console", "console"},
+
+		// Пустые и служебные
+		{"", ""},
+		{".", ""},
+		{"..", ""},
+		// Разделители пути
+		{"a/b", ""},
+		{`a\b`, ""},
+		// Слишком длинные: 255 байт считаются в UTF-8, а не в символах
+		{strings.Repeat("a", MaxNameLength+1), ""},
+		{strings.Repeat("я", MaxNameLength/2+1), ""},
+		// Точка или пробел в конце Windows молча отрезает
+		{"name.", ""},
+		{"name ", ""},
+		// Управляющие символы
+		{"a\x00b", ""},
+		{"a\nb", ""},
+		{"a\tb", ""},
+		{"a\x7fb", ""},
+		{"a\u0085b", ""},
+		// Имена устройств Windows с любым регистром и расширением
+		{"CON", ""},
+		{"con", ""},
+		{"Nul.txt", ""},
+		{"aux.tar.gz", ""},
+		{"COM1", ""},
+		{"lpt9.log", ""},
+		{"PRN .txt", ""},
+		// Не UTF-8
+		{"\xff\xfe.txt", ""},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeName(tt.name)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("NormalizeName(%q) = %q, want error", tt.name, got)
+			} else if !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("NormalizeName(%q) error %v does not wrap ErrInvalidPath", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeName(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	longSegment := strings.Repeat("a", MaxNameLength)
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"/", "", false},
+		{"docs/report.pdf", "docs/report.pdf", false},
+		{"/docs/report.pdf/", "docs/report.pdf", false},
+		{"Cafe\u0301/menu.txt", "Caf\u00e9/menu.txt", false},
+		{"docs//report.pdf", "", true},
+		{"docs/../etc/passwd", "", true},
+		{"./report.pdf", "", true},
+		{"docs/CON/report.pdf", "", true},
+		{"docs/bad\x01name", "", true},
+		{strings.Repeat(longSegment+"/", 4) + "tail", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizePath(tt.path)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("NormalizePath(%q) = %q, %v, want ErrInvalidPath", tt.path, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizePath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestNumberedName(t *testing.T) {
+	tests := []struct {
+		path string
+		n    int
+		want string
+	}{
+		{"report.pdf", 2, "report (2).pdf"},
+		{"docs/report.pdf", 3, "docs/report (3).pdf"},
+		{"archive.tar.gz", 2, "archive.tar (2).gz"},
+		{"README", 2, "README (2)"},
+		{".bashrc", 2, ".bashrc (2)"},
+	}
+	for _, tt := range tests {
+		if got := NumberedName(tt.path, tt.n); got != tt.want {
+			t.Errorf("NumberedName(%q, %d) = %q, want %q", tt.path, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"file-exchange-app/models"
+	"fmt"
+	"io"
+	"log"
+)
+
+// ReconcileFiles импортирует в таблицу files объекты хранилища, о которых нет записей,
+// например файлы, загруженные в ./uploads до появления таблицы. Имя файла берется из ключа,
+// владелец остается неизвестным. Возвращает количество импортированных файлов.
+func ReconcileFiles(ctx context.Context, blobs BlobStore, files FileStore) (int, error) {
+	objects, err := blobs.List(ctx, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list storage: %w", err)
+	}
+
+	imported := 0
+	for _, object := range objects {
+		known, err := files.IsKeyKnown(object.Key)
+		if err != nil {
+			return imported, err
+		}
+		if known {
+			continue
+		}
+
+		// Имя уже занято другим файлом - не трогаем, пусть разбирается администратор
+		if _, err := files.GetFileByName(object.Key); err == nil {
+			log.Printf("Reconcile: skipping %s, name is already used by another file", object.Key)
+			continue
+		} else if !errors.Is(err, ErrFileNotFound) {
+			return imported, err
+		}
+
+		file, err := inspectBlob(ctx, blobs, object)
+		if err != nil {
+			return imported, err
+		}
+		if _, err := files.SaveFile(file); err != nil {
+			if errors.Is(err, ErrPathConflict) {
+				log.Printf("Reconcile: skipping %s, path is used by a folder", object.Key)
+				continue
+			}
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// inspectBlob читает объект целиком, чтобы посчитать SHA-256 и определить MIME-тип
+func inspectBlob(ctx context.Context, blobs BlobStore, object BlobInfo) (*models.File, error) {
+	body, err := blobs.Get(ctx, object.Key, 0, -1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", object.Key, err)
+	}
+	defer body.Close()
+
+	inspector := NewContentInspector(body)
+	if _, err := io.Copy(io.Discard, inspector); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", object.Key, err)
+	}
+
+	return &models.File{
+		Name:      object.Key,
+		StoredKey: object.Key,
+		Size:      inspector.Size(),
+		SHA256:    inspector.SHA256(),
+		MimeType:  inspector.MimeType(object.Key),
+	}, nil
+}
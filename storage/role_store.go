@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"file-exchange-app/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Ошибки, которые хендлеры различают при работе с ролями
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrRoleExists   = errors.New("role already exists")
+	ErrRoleBuiltin  = errors.New("built-in roles cannot be changed")
+	ErrRoleInUse    = errors.New("role is assigned to users or groups")
+)
+
+// RoleStore представляет интерфейс для работы с ролями
+type RoleStore interface {
+	CreateRole(name string, canUpload, canDownload, isAdmin bool) (*models.Role, error)
+	GetRoleByID(roleID int) (*models.Role, error)
+	GetRoleByName(name string) (*models.Role, error)
+	ListRoles() ([]models.Role, error)
+	// UpdateRole меняет набор прав пользовательской роли; встроенные роли не меняются
+	UpdateRole(roleID int, canUpload, canDownload, isAdmin bool) error
+	// DeleteRole удаляет пользовательскую роль, если она никому не назначена
+	DeleteRole(roleID int) error
+}
+
+// SQLiteRoleStore реализация RoleStore для SQLite
+type SQLiteRoleStore struct {
+	db *sql.DB
+}
+
+// NewRoleStore создает новый экземпляр RoleStore
+func NewRoleStore(db *sql.DB) RoleStore {
+	return &SQLiteRoleStore{db: db}
+}
+
+// roleColumns столбцы для scanRole
+const roleColumns = "id, name, can_upload, can_download, is_admin, builtin, created_at"
+
+// scanRole читает строку со столбцами roleColumns
+func scanRole(row interface{ Scan(...interface{}) error }) (*models.Role, error) {
+	var role models.Role
+	err := row.Scan(&role.ID, &role.Name, &role.CanUpload, &role.CanDownload, &role.IsAdmin, &role.Builtin, &role.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// CreateRole создает пользовательскую роль
+func (s *SQLiteRoleStore) CreateRole(name string, canUpload, canDownload, isAdmin bool) (*models.Role, error) {
+	role := &models.Role{
+		Name:        name,
+		CanUpload:   canUpload,
+		CanDownload: canDownload,
+		IsAdmin:     isAdmin,
+		CreatedAt:   time.Now().UTC(),
+	}
+	result, err := s.db.Exec(
+		"INSERT INTO roles (name, can_upload, can_download, is_admin, builtin, created_at) VALUES (?, ?, ?, ?, FALSE, ?)",
+		name, canUpload, canDownload, isAdmin, role.CreatedAt,
+	)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "UNIQUE constraint failed") {
+			return nil, ErrRoleExists
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	role.ID = int(id)
+	return role, nil
+}
+
+// getRole возвращает роль по условию where с одним параметром
+func (s *SQLiteRoleStore) getRole(where string, arg interface{}) (*models.Role, error) {
+	role, err := scanRole(s.db.QueryRow("SELECT "+roleColumns+" FROM roles WHERE "+where, arg))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return role, nil
+}
+
+// GetRoleByID возвращает роль по ID
+func (s *SQLiteRoleStore) GetRoleByID(roleID int) (*models.Role, error) {
+	return s.getRole("id = ?", roleID)
+}
+
+// GetRoleByName возвращает роль по имени
+func (s *SQLiteRoleStore) GetRoleByName(name string) (*models.Role, error) {
+	return s.getRole("name = ?", name)
+}
+
+// ListRoles возвращает все роли: сначала встроенные, затем остальные по алфавиту
+func (s *SQLiteRoleStore) ListRoles() ([]models.Role, error) {
+	rows, err := s.db.Query("SELECT " + roleColumns + " FROM roles ORDER BY builtin DESC, name")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		roles = append(roles, *role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return roles, nil
+}
+
+// UpdateRole меняет права роли
+func (s *SQLiteRoleStore) UpdateRole(roleID int, canUpload, canDownload, isAdmin bool) error {
+	role, err := s.GetRoleByID(roleID)
+	if err != nil {
+		return err
+	}
+	if role.Builtin {
+		return ErrRoleBuiltin
+	}
+
+	_, err = s.db.Exec(
+		"UPDATE roles SET can_upload = ?, can_download = ?, is_admin = ? WHERE id = ?",
+		canUpload, canDownload, isAdmin, roleID,
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// DeleteRole удаляет роль
+func (s *SQLiteRoleStore) DeleteRole(roleID int) error {
+	role, err := s.GetRoleByID(roleID)
+	if err != nil {
+		return err
+	}
+	if role.Builtin {
+		return ErrRoleBuiltin
+	}
+
+	var assigned int
+	err = s.db.QueryRow(
+		"SELECT (SELECT COUNT(*) FROM users WHERE role_id = ?) + (SELECT COUNT(*) FROM groups WHERE role_id = ?)",
+		roleID, roleID,
+	).Scan(&assigned)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if assigned > 0 {
+		return ErrRoleInUse
+	}
+
+	result, err := s.db.Exec("DELETE FROM roles WHERE id = ?", roleID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrRoleNotFound)
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config параметры подключения к S3-совместимому хранилищу (AWS S3, MinIO и т.п.)
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	Prefix    string // Необязательный префикс ключей внутри бакета
+}
+
+// S3BlobStore реализация BlobStore для S3-совместимых хранилищ
+type S3BlobStore struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3BlobStore подключается к хранилищу и создает бакет, если его еще нет
+func NewS3BlobStore(cfg S3Config) (BlobStore, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket: %w", err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, fmt.Errorf("failed to create bucket: %w", err)
+		}
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3BlobStore{client: client, bucket: cfg.Bucket, prefix: prefix}, nil
+}
+
+// objectName добавляет к ключу префикс бакета
+func (s *S3BlobStore) objectName(key string) string {
+	return s.prefix + strings.TrimPrefix(key, "/")
+}
+
+// isNotFound проверяет, что S3 ответил "нет такого объекта"
+func isNotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+// s3StreamPartSize размер части при загрузке потока неизвестной длины. Без него minio-go
+// рассчитывает части на объект в 5 ТБ (около 528 МБ) и выделяет такой буфер на каждую
+// загрузку. S3 допускает не больше 10000 частей, так что поток ограничен 156 ГБ.
+const s3StreamPartSize = 16 << 20
+
+// putOptions параметры PutObject. Поток неизвестной длины читается в один буфер
+// s3StreamPartSize и отправляется по частям последовательно.
+func putOptions(size int64) minio.PutObjectOptions {
+	opts := minio.PutObjectOptions{ContentType: "application/octet-stream"}
+	if size < 0 {
+		opts.PartSize = s3StreamPartSize
+		opts.NumThreads = 1
+	}
+	return opts
+}
+
+// Put загружает объект; поток неизвестного размера уходит частями по s3StreamPartSize
+func (s *S3BlobStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.objectName(key), r, size, putOptions(size))
+	if err != nil {
+		return fmt.Errorf("s3 put: %w", err)
+	}
+	return nil
+}
+
+// Get открывает объект, используя Range-запрос S3 для частичного чтения
+func (s *S3BlobStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	opts := minio.GetObjectOptions{}
+	if offset > 0 || length > 0 {
+		end := int64(0) // 0 означает "до конца объекта"
+		if length > 0 {
+			end = offset + length - 1
+		}
+		if err := opts.SetRange(offset, end); err != nil {
+			return nil, err
+		}
+	}
+
+	// GetObject ленивый: ошибку "нет объекта" узнаем только при первом обращении
+	object, err := s.client.GetObject(ctx, s.bucket, s.objectName(key), opts)
+	if err != nil {
+		return nil, fmt.Errorf("s3 get: %w", err)
+	}
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		if isNotFound(err) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, fmt.Errorf("s3 get: %w", err)
+	}
+	return object, nil
+}
+
+// Stat возвращает размер и время изменения объекта
+func (s *S3BlobStore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.objectName(key), minio.StatObjectOptions{})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, fmt.Errorf("s3 stat: %w", err)
+	}
+	return &BlobInfo{Key: key, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+// List перечисляет объекты рекурсивно
+func (s *S3BlobStore) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	var blobs []BlobInfo
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.objectName(prefix),
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("s3 list: %w", object.Err)
+		}
+		blobs = append(blobs, BlobInfo{
+			Key:     strings.TrimPrefix(object.Key, s.prefix),
+			Size:    object.Size,
+			ModTime: object.LastModified,
+		})
+	}
+	return blobs, nil
+}
+
+// Delete удаляет объект. S3 не сообщает об удалении несуществующего объекта,
+// поэтому сначала проверяем его наличие.
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	if _, err := s.Stat(ctx, key); err != nil {
+		return err
+	}
+	if err := s.client.RemoveObject(ctx, s.bucket, s.objectName(key), minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("s3 delete: %w", err)
+	}
+	return nil
+}
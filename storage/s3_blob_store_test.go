@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestPutOptionsPartSize(t *testing.T) {
+	tests := []struct {
+		size         int64
+		wantPartSize uint64
+	}{
+		{-1, s3StreamPartSize},
+		{0, 0},
+		{10 << 20, 0},
+		{10 << 30, 0},
+	}
+	for _, tt := range tests {
+		opts := putOptions(tt.size)
+		if opts.PartSize != tt.wantPartSize {
+			t.Errorf("putOptions(%d).PartSize = %d, want %d", tt.size, opts.PartSize, tt.wantPartSize)
+		}
+		if tt.size < 0 && opts.NumThreads != 1 {
+			t.Errorf("putOptions(%d).NumThreads = %d, want 1", tt.size, opts.NumThreads)
+		}
+	}
+
+	// minio-go должен принять размер части для потока без длины и не увеличивать его:
+	// буфер на одну загрузку - ровно одна часть
+	_, partSize, _, err := minio.OptimalPartInfo(-1, putOptions(-1).PartSize)
+	if err != nil {
+		t.Fatalf("OptimalPartInfo: %v", err)
+	}
+	if partSize != s3StreamPartSize {
+		t.Errorf("part size for unknown length = %d, want %d", partSize, s3StreamPartSize)
+	}
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"file-exchange-app/models"
+	"fmt"
+	"time"
+)
+
+// sessionTouchInterval как часто обновляется время последнего обращения к сессии.
+// Писать в БД на каждый запрос незачем, точность idle-таймаута от этого почти не страдает.
+const sessionTouchInterval = time.Minute
+
+// ErrSessionNotFound сессии нет: она истекла, отозвана или никогда не существовала
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore представляет интерфейс для работы с сессиями входа
+type SessionStore interface {
+	CreateSession(userID int, data []byte, userAgent, ipAddress string) (string, error)
+	GetSession(token string) (*models.Session, error)
+	UpdateSession(token string, userID int, data []byte) error
+	DeleteSession(token string) error
+	ListUserSessions(userID int, currentToken string) ([]models.Session, error)
+	RevokeSession(userID, sessionID int) error
+	RevokeUserSessions(userID int, exceptToken string) (int, error)
+	DeleteExpiredSessions() (int, error)
+	AbsoluteTimeout() time.Duration
+}
+
+// SQLiteSessionStore реализация SessionStore для SQLite
+type SQLiteSessionStore struct {
+	db *sql.DB
+	// idleTimeout сессия истекает, если ею не пользовались столько времени
+	idleTimeout time.Duration
+	// absoluteTimeout сессия истекает через столько времени после входа в любом случае
+	absoluteTimeout time.Duration
+}
+
+// NewSessionStore создает новый экземпляр SessionStore
+func NewSessionStore(db *sql.DB, idleTimeout, absoluteTimeout time.Duration) SessionStore {
+	return &SQLiteSessionStore{db: db, idleTimeout: idleTimeout, absoluteTimeout: absoluteTimeout}
+}
+
+// AbsoluteTimeout возвращает максимальное время жизни сессии
+func (s *SQLiteSessionStore) AbsoluteTimeout() time.Duration {
+	return s.absoluteTimeout
+}
+
+// CreateSession создает сессию и возвращает идентификатор для cookie.
+// userID 0 означает еще не авторизованную сессию.
+func (s *SQLiteSessionStore) CreateSession(userID int, data []byte, userAgent, ipAddress string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+	token := hex.EncodeToString(raw)
+
+	now := time.Now().UTC()
+	_, err := s.db.Exec(
+		"INSERT INTO sessions (token_hash, user_id, data, user_agent, ip_address, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		hashToken(token), nullUserID(userID), data, userAgent, ipAddress, now, now,
+	)
+	if err != nil {
+		return "", fmt.Errorf("database error: %w", err)
+	}
+	return token, nil
+}
+
+// GetSession возвращает действующую сессию и отмечает обращение к ней.
+// Истекшая сессия удаляется.
+func (s *SQLiteSessionStore) GetSession(token string) (*models.Session, error) {
+	var session models.Session
+	var userID sql.NullInt64
+	err := s.db.QueryRow(
+		"SELECT id, user_id, data, user_agent, ip_address, created_at, last_seen_at FROM sessions WHERE token_hash = ?",
+		hashToken(token),
+	).Scan(&session.ID, &userID, &session.Data, &session.UserAgent, &session.IPAddress, &session.CreatedAt, &session.LastSeenAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	session.UserID = int(userID.Int64)
+
+	now := time.Now().UTC()
+	if s.expired(&session, now) {
+		if _, err := s.db.Exec("DELETE FROM sessions WHERE id = ?", session.ID); err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		return nil, ErrSessionNotFound
+	}
+
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+		// Отметка обращения нужна для idle-таймаута, но ошибку не считаем фатальной
+		s.db.Exec("UPDATE sessions SET last_seen_at = ? WHERE id = ?", now, session.ID)
+		session.LastSeenAt = now
+	}
+	return &session, nil
+}
+
+// expired сообщает, истекла ли сессия по любому из таймаутов
+func (s *SQLiteSessionStore) expired(session *models.Session, now time.Time) bool {
+	return !now.Before(session.LastSeenAt.Add(s.idleTimeout)) || !now.Before(session.CreatedAt.Add(s.absoluteTimeout))
+}
+
+// UpdateSession сохраняет значения сессии. Отозванная за время запроса сессия не воскресает.
+func (s *SQLiteSessionStore) UpdateSession(token string, userID int, data []byte) error {
+	result, err := s.db.Exec(
+		"UPDATE sessions SET user_id = ?, data = ?, last_seen_at = ? WHERE token_hash = ?",
+		nullUserID(userID), data, time.Now().UTC(), hashToken(token),
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrSessionNotFound)
+}
+
+// DeleteSession удаляет сессию по идентификатору из cookie (выход из системы)
+func (s *SQLiteSessionStore) DeleteSession(token string) error {
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(token)); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// ListUserSessions возвращает действующие сессии пользователя, начиная с последних.
+// Сессия с идентификатором currentToken помечается как текущая.
+func (s *SQLiteSessionStore) ListUserSessions(userID int, currentToken string) ([]models.Session, error) {
+	idleSince, createdSince := s.cutoffs(time.Now().UTC())
+	rows, err := s.db.Query(`
+		SELECT id, user_id, user_agent, ip_address, created_at, last_seen_at, token_hash = ?
+		FROM sessions WHERE user_id = ? AND last_seen_at > ? AND created_at > ?
+		ORDER BY last_seen_at DESC, id DESC`,
+		hashToken(currentToken), userID, idleSince, createdSince,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IPAddress,
+			&session.CreatedAt, &session.LastSeenAt, &session.Current)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession завершает одну сессию пользователя
+func (s *SQLiteSessionStore) RevokeSession(userID, sessionID int) error {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrSessionNotFound)
+}
+
+// RevokeUserSessions завершает все сессии пользователя, кроме сессии exceptToken
+// (пустая строка - завершить все), и возвращает их число
+func (s *SQLiteSessionStore) RevokeUserSessions(userID int, exceptToken string) (int, error) {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE user_id = ? AND token_hash <> ?", userID, hashToken(exceptToken))
+	if err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	return int(affected), nil
+}
+
+// DeleteExpiredSessions удаляет истекшие сессии, в том числе брошенные до входа
+func (s *SQLiteSessionStore) DeleteExpiredSessions() (int, error) {
+	idleSince, createdSince := s.cutoffs(time.Now().UTC())
+	result, err := s.db.Exec("DELETE FROM sessions WHERE last_seen_at <= ? OR created_at <= ?", idleSince, createdSince)
+	if err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	return int(affected), nil
+}
+
+// cutoffs возвращает границы idle- и абсолютного таймаутов на момент now
+func (s *SQLiteSessionStore) cutoffs(now time.Time) (time.Time, time.Time) {
+	return now.Add(-s.idleTimeout), now.Add(-s.absoluteTimeout)
+}
+
+// nullUserID хранит неавторизованную сессию с user_id NULL
+func nullUserID(userID int) interface{} {
+	if userID == 0 {
+		return nil
+	}
+	return userID
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// Ключи настроек, которые администратор меняет в админке
+const (
+	// SettingRequireAdmin2FA требует от администраторов включить двухфакторную аутентификацию
+	SettingRequireAdmin2FA = "require_admin_2fa"
+)
+
+// SettingsStore представляет интерфейс для работы с настройками приложения
+type SettingsStore interface {
+	// GetBool возвращает логическую настройку; отсутствующая настройка равна false
+	GetBool(key string) (bool, error)
+	SetBool(key string, value bool) error
+}
+
+// SQLiteSettingsStore реализация SettingsStore для SQLite
+type SQLiteSettingsStore struct {
+	db *sql.DB
+}
+
+// NewSettingsStore создает новый экземпляр SettingsStore
+func NewSettingsStore(db *sql.DB) SettingsStore {
+	return &SQLiteSettingsStore{db: db}
+}
+
+// GetBool читает настройку
+func (s *SQLiteSettingsStore) GetBool(key string) (bool, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("database error: %w", err)
+	}
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid setting %s: %w", key, err)
+	}
+	return flag, nil
+}
+
+// SetBool сохраняет настройку
+func (s *SQLiteSettingsStore) SetBool(key string, value bool) error {
+	_, err := s.db.Exec(
+		"INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value",
+		key, strconv.FormatBool(value),
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
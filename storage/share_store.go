@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"file-exchange-app/models"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Ошибки, которые хендлеры различают при работе с публичными ссылками
+var (
+	ErrShareNotFound = errors.New("share not found")
+	// ErrShareInactive - ссылка отозвана, истекла или исчерпала лимит скачиваний
+	ErrShareInactive = errors.New("share is no longer available")
+)
+
+// ShareStore представляет интерфейс для работы с публичными ссылками
+type ShareStore interface {
+	// CreateShare создает ссылку и возвращает открытое значение ее токена.
+	// Пустой password - ссылка без пароля, maxDownloads 0 - без лимита.
+	CreateShare(fileID, ownerID int, password string, expiresAt *time.Time, maxDownloads int) (string, *models.Share, error)
+	// ListShares возвращает ссылки пользователя; ownerID 0 - ссылки всех пользователей
+	ListShares(ownerID int) ([]models.Share, error)
+	GetShareByToken(plain string) (*models.Share, error)
+	// RevokeShare отзывает ссылку пользователя; ownerID 0 - любую ссылку
+	RevokeShare(shareID, ownerID int) error
+	// RecordDownload учитывает скачивание, если лимит еще не исчерпан
+	RecordDownload(shareID int) error
+	// ReleaseDownload возвращает скачивание, учтенное заранее, если файл так и не был отдан
+	ReleaseDownload(shareID int) error
+}
+
+// SQLiteShareStore реализация ShareStore для SQLite
+type SQLiteShareStore struct {
+	db *sql.DB
+}
+
+// NewShareStore создает новый экземпляр ShareStore
+func NewShareStore(db *sql.DB) ShareStore {
+	return &SQLiteShareStore{db: db}
+}
+
+// shareColumns столбцы для scanShare; запросы соединяют shares s, files f и users u.
+// Владелец, которого нет в users, считается заблокированным.
+const shareColumns = `s.id, s.file_id, f.original_name, s.owner_id, COALESCE(u.username, ''), COALESCE(u.disabled, TRUE),
+	COALESCE(s.password_hash, ''), s.expires_at, s.max_downloads, s.download_count, s.created_at, s.revoked_at`
+
+// shareJoins соединения для shareColumns. Ссылки на удаленные файлы не показываются.
+const shareJoins = " FROM shares s JOIN files f ON f.id = s.file_id LEFT JOIN users u ON u.id = s.owner_id"
+
+// scanShare читает строку со столбцами shareColumns
+func scanShare(row interface{ Scan(...interface{}) error }) (*models.Share, error) {
+	var share models.Share
+	var expiresAt, revokedAt sql.NullTime
+	err := row.Scan(&share.ID, &share.FileID, &share.FileName, &share.OwnerID, &share.OwnerName, &share.OwnerDisabled,
+		&share.PasswordHash, &expiresAt, &share.MaxDownloads, &share.DownloadCount, &share.CreatedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	share.HasPassword = share.PasswordHash != ""
+	share.ExpiresAt = nullTimePtr(expiresAt)
+	share.RevokedAt = nullTimePtr(revokedAt)
+	return &share, nil
+}
+
+// CreateShare создает новую ссылку на файл
+func (s *SQLiteShareStore) CreateShare(fileID, ownerID int, password string, expiresAt *time.Time, maxDownloads int) (string, *models.Share, error) {
+	if maxDownloads < 0 {
+		return "", nil, fmt.Errorf("invalid download limit: %d", maxDownloads)
+	}
+
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("failed to generate share token: %w", err)
+	}
+	plain := base64.RawURLEncoding.EncodeToString(raw)
+
+	var passwordHash interface{}
+	if password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		passwordHash = string(hashed)
+	}
+
+	var expires *time.Time
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		expires = &utc
+	}
+
+	result, err := s.db.Exec(
+		"INSERT INTO shares (file_id, owner_id, token_hash, password_hash, expires_at, max_downloads, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		fileID, ownerID, hashToken(plain), passwordHash, expires, maxDownloads, time.Now().UTC(),
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("database error: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", nil, fmt.Errorf("database error: %w", err)
+	}
+
+	share, err := scanShare(s.db.QueryRow("SELECT "+shareColumns+shareJoins+" WHERE s.id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil, ErrFileNotFound
+		}
+		return "", nil, fmt.Errorf("database error: %w", err)
+	}
+	return plain, share, nil
+}
+
+// ListShares возвращает ссылки без значений токенов, новые первыми
+func (s *SQLiteShareStore) ListShares(ownerID int) ([]models.Share, error) {
+	query := "SELECT " + shareColumns + shareJoins
+	var args []interface{}
+	if ownerID != 0 {
+		query += " WHERE s.owner_id = ?"
+		args = append(args, ownerID)
+	}
+	query += " ORDER BY s.id DESC"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var shares []models.Share
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan share: %w", err)
+		}
+		shares = append(shares, *share)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return shares, nil
+}
+
+// GetShareByToken находит ссылку по открытому значению токена. Неактивные ссылки
+// тоже возвращаются, чтобы страница могла объяснить, почему файл недоступен.
+func (s *SQLiteShareStore) GetShareByToken(plain string) (*models.Share, error) {
+	share, err := scanShare(s.db.QueryRow("SELECT "+shareColumns+shareJoins+" WHERE s.token_hash = ?", hashToken(plain)))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrShareNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return share, nil
+}
+
+// RevokeShare помечает ссылку отозванной. Запись остается, чтобы журнал
+// обращений по ней можно было сопоставить со ссылкой.
+func (s *SQLiteShareStore) RevokeShare(shareID, ownerID int) error {
+	query := "UPDATE shares SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"
+	args := []interface{}{time.Now().UTC(), shareID}
+	if ownerID != 0 {
+		query += " AND owner_id = ?"
+		args = append(args, ownerID)
+	}
+
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrShareNotFound)
+}
+
+// RecordDownload увеличивает счетчик скачиваний одним запросом, чтобы
+// параллельные скачивания не превысили лимит. Ссылка заблокированного владельца не работает.
+func (s *SQLiteShareStore) RecordDownload(shareID int) error {
+	now := time.Now().UTC()
+	result, err := s.db.Exec(`
+		UPDATE shares SET download_count = download_count + 1
+		WHERE id = ? AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > ?)
+		  AND (max_downloads = 0 OR download_count < max_downloads)
+		  AND EXISTS (SELECT 1 FROM users WHERE users.id = shares.owner_id AND NOT users.disabled)`,
+		shareID, now,
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrShareInactive)
+}
+
+// ReleaseDownload уменьшает счетчик скачиваний на одно
+func (s *SQLiteShareStore) ReleaseDownload(shareID int) error {
+	_, err := s.db.Exec("UPDATE shares SET download_count = download_count - 1 WHERE id = ? AND download_count > 0", shareID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// CheckSharePassword проверяет пароль ссылки; ссылка без пароля принимает любой
+func CheckSharePassword(share *models.Share, password string) bool {
+	if !share.HasPassword {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) == nil
+}
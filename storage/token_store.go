@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"file-exchange-app/models"
+	"fmt"
+	"time"
+)
+
+// tokenPrefix помогает узнать токен приложения в логах CI и сканерах секретов
+const tokenPrefix = "fx_"
+
+// Ошибки, которые хендлеры различают при работе с токенами
+var (
+	ErrTokenNotFound = errors.New("token not found")
+	ErrTokenInvalid  = errors.New("invalid or expired token")
+)
+
+// TokenStore представляет интерфейс для работы с API-токенами
+type TokenStore interface {
+	CreateToken(userID int, name, scope string, expiresAt *time.Time) (string, *models.APIToken, error)
+	ListTokens(userID int) ([]models.APIToken, error)
+	RevokeToken(userID, tokenID int) error
+	ResolveToken(plain string) (*models.User, *models.APIToken, error)
+}
+
+// SQLiteTokenStore реализация TokenStore для SQLite
+type SQLiteTokenStore struct {
+	db *sql.DB
+}
+
+// NewTokenStore создает новый экземпляр TokenStore
+func NewTokenStore(db *sql.DB) TokenStore {
+	return &SQLiteTokenStore{db: db}
+}
+
+// hashToken возвращает хэш, под которым токен хранится в БД.
+// Токены случайные и длинные, поэтому медленный bcrypt здесь не нужен.
+func hashToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken выпускает новый токен и возвращает его открытое значение.
+// Открытое значение показывается пользователю один раз и нигде не сохраняется.
+func (s *SQLiteTokenStore) CreateToken(userID int, name, scope string, expiresAt *time.Time) (string, *models.APIToken, error) {
+	if !models.ValidTokenScope(scope) {
+		return "", nil, fmt.Errorf("invalid token scope: %s", scope)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	plain := tokenPrefix + hex.EncodeToString(raw)
+
+	token := &models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Scope:     scope,
+		CreatedAt: time.Now().UTC(),
+	}
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		token.ExpiresAt = &utc
+	}
+
+	result, err := s.db.Exec(
+		"INSERT INTO api_tokens (user_id, name, token_hash, scope, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, name, hashToken(plain), scope, token.ExpiresAt, token.CreatedAt,
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("database error: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", nil, fmt.Errorf("database error: %w", err)
+	}
+	token.ID = int(id)
+
+	return plain, token, nil
+}
+
+// ListTokens возвращает все токены пользователя без их значений
+func (s *SQLiteTokenStore) ListTokens(userID int) ([]models.APIToken, error) {
+	rows, err := s.db.Query(
+		"SELECT id, user_id, name, scope, expires_at, created_at, last_used_at FROM api_tokens WHERE user_id = ? ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		var token models.APIToken
+		var expiresAt, lastUsedAt sql.NullTime
+		err := rows.Scan(&token.ID, &token.UserID, &token.Name, &token.Scope, &expiresAt, &token.CreatedAt, &lastUsedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan token: %w", err)
+		}
+		token.ExpiresAt = nullTimePtr(expiresAt)
+		token.LastUsedAt = nullTimePtr(lastUsedAt)
+		tokens = append(tokens, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// RevokeToken удаляет токен пользователя
+func (s *SQLiteTokenStore) RevokeToken(userID, tokenID int) error {
+	result, err := s.db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrTokenNotFound)
+}
+
+// ResolveToken находит владельца токена и возвращает его с правами,
+// урезанными до области действия токена
+func (s *SQLiteTokenStore) ResolveToken(plain string) (*models.User, *models.APIToken, error) {
+	var user models.User
+	var token models.APIToken
+	var expiresAt, lastUsedAt sql.NullTime
+
+	err := s.db.QueryRow(`
+		SELECT t.id, t.user_id, t.name, t.scope, t.expires_at, t.created_at, t.last_used_at, `+userColumns+`
+		FROM api_tokens t JOIN users u ON u.id = t.user_id LEFT JOIN roles r ON r.id = u.role_id
+		WHERE t.token_hash = ?`,
+		hashToken(plain),
+	).Scan(&token.ID, &token.UserID, &token.Name, &token.Scope, &expiresAt, &token.CreatedAt, &lastUsedAt,
+		&user.ID, &user.Username, &user.PasswordHash, &user.CanUpload, &user.CanDownload, &user.IsAdmin, &user.RoleID, &user.Role, &user.Disabled, &user.MustChangePassword, &user.TOTPEnabled, &user.AuthSource)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrTokenInvalid
+		}
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+
+	token.ExpiresAt = nullTimePtr(expiresAt)
+	token.LastUsedAt = nullTimePtr(lastUsedAt)
+
+	now := time.Now().UTC()
+	if token.Expired(now) || user.Disabled {
+		return nil, nil, ErrTokenInvalid
+	}
+
+	// Отметка последнего использования нужна только для информации, ошибку не считаем фатальной
+	s.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, token.ID)
+	token.LastUsedAt = &now
+
+	models.RestrictToScope(&user, token.Scope)
+	return &user, &token, nil
+}
+
+// nullTimePtr переводит sql.NullTime в указатель, nil для NULL
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"file-exchange-app/totp"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrTwoFactorNotEnabled возвращается, если у пользователя не включена двухфакторная аутентификация
+var ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+
+// recoveryCodeCount сколько кодов восстановления выдается за раз
+const recoveryCodeCount = 10
+
+// TwoFactorStore представляет интерфейс для работы с TOTP-секретами и кодами восстановления
+type TwoFactorStore interface {
+	// Enable сохраняет секрет и выпускает новые коды восстановления; открытые коды
+	// возвращаются один раз и нигде не сохраняются
+	Enable(userID int, secret string) ([]string, error)
+	Disable(userID int) error
+	// VerifyCode проверяет TOTP-код; каждый код принимается только один раз
+	VerifyCode(userID int, code string, now time.Time) (bool, error)
+	// UseRecoveryCode погашает код восстановления
+	UseRecoveryCode(userID int, code string) (bool, error)
+	RegenerateRecoveryCodes(userID int) ([]string, error)
+	RemainingRecoveryCodes(userID int) (int, error)
+}
+
+// SQLiteTwoFactorStore реализация TwoFactorStore для SQLite
+type SQLiteTwoFactorStore struct {
+	db *sql.DB
+}
+
+// NewTwoFactorStore создает новый экземпляр TwoFactorStore
+func NewTwoFactorStore(db *sql.DB) TwoFactorStore {
+	return &SQLiteTwoFactorStore{db: db}
+}
+
+// normalizeRecoveryCode приводит введенный код к виду, в котором он хэшировался
+func normalizeRecoveryCode(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+// Enable включает двухфакторную аутентификацию
+func (s *SQLiteTwoFactorStore) Enable(userID int, secret string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?", secret, userID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if err := checkAffected(result, ErrUserNotFound); err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return codes, nil
+}
+
+// replaceRecoveryCodes удаляет старые коды восстановления и выпускает новые
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		plain := hex.EncodeToString(raw)
+		_, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashToken(plain))
+		if err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		// Для удобства ввода показываем код двумя группами
+		codes = append(codes, plain[:5]+"-"+plain[5:])
+	}
+	return codes, nil
+}
+
+// Disable выключает двухфакторную аутентификацию и удаляет коды восстановления
+func (s *SQLiteTwoFactorStore) Disable(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET totp_secret = NULL, totp_last_step = 0 WHERE id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if err := checkAffected(result, ErrUserNotFound); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// VerifyCode проверяет код и запоминает его шаг
+func (s *SQLiteTwoFactorStore) VerifyCode(userID int, code string, now time.Time) (bool, error) {
+	var secret sql.NullString
+	var lastStep int64
+	err := s.db.QueryRow("SELECT totp_secret, totp_last_step FROM users WHERE id = ?", userID).Scan(&secret, &lastStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrUserNotFound
+		}
+		return false, fmt.Errorf("database error: %w", err)
+	}
+	if !secret.Valid {
+		return false, ErrTwoFactorNotEnabled
+	}
+
+	step, ok := totp.Match(secret.String, code, now, lastStep)
+	if !ok {
+		return false, nil
+	}
+
+	// Условие на старый шаг не дает двум параллельным запросам принять один код
+	result, err := s.db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("database error: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("database error: %w", err)
+	}
+	return affected == 1, nil
+}
+
+// UseRecoveryCode помечает код восстановления использованным
+func (s *SQLiteTwoFactorStore) UseRecoveryCode(userID int, code string) (bool, error) {
+	result, err := s.db.Exec(
+		"UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now().UTC(), userID, hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return false, fmt.Errorf("database error: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("database error: %w", err)
+	}
+	return affected == 1, nil
+}
+
+// RegenerateRecoveryCodes заменяет коды восстановления новыми
+func (s *SQLiteTwoFactorStore) RegenerateRecoveryCodes(userID int) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	var enabled int
+	err = tx.QueryRow("SELECT COUNT(*) FROM users WHERE id = ? AND totp_secret IS NOT NULL", userID).Scan(&enabled)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if enabled == 0 {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return codes, nil
+}
+
+// RemainingRecoveryCodes возвращает число неиспользованных кодов восстановления
+func (s *SQLiteTwoFactorStore) RemainingRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	return count, nil
+}
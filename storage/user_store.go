@@ -2,76 +2,186 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
 	"file-exchange-app/models"
 	"fmt"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-// UserStore представляет интерфейс для работы с пользователями
+// Ошибки, которые хендлеры различают при работе с пользователями
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists   = errors.New("username already exists")
+	ErrUserDisabled = errors.New("user account is disabled")
+	// ErrInvalidCredentials неверное имя пользователя или пароль
+	ErrInvalidCredentials = errors.New("invalid username or password")
+)
+
+// UserStore представляет интерфейс для работы с пользователями.
+// Возвращаемые пользователи содержат итоговые права с учетом роли и групп.
 type UserStore interface {
-	CreateUser(username, password string, canUpload, canDownload, isAdmin bool) error
+	CreateUser(username, password string, roleID int) error
 	GetUserByUsername(username string) (*models.User, error)
+	GetUserByID(userID int) (*models.User, error)
 	GetAllUsers() ([]models.User, error)
+	// SetUserRole назначает пользователю роль
+	SetUserRole(userID, roleID int) error
+	// UpdateUserPermissions задает пользователю собственные флаги прав и снимает роль
+	UpdateUserPermissions(userID int, canUpload, canDownload, isAdmin bool) error
+	// SetPassword задает новый пароль; mustChange требует сменить его при следующем входе
+	SetPassword(userID int, password string, mustChange bool) error
+	// SetDisabled блокирует или разблокирует вход пользователя
+	SetDisabled(userID int, disabled bool) error
+	// VerifyUserCredentials проверяет пароль; заблокированный пользователь получает ErrUserDisabled
 	VerifyUserCredentials(username, password string) (*models.User, error)
-	DeleteUser(userID int) error
+	// ProvisionUser создает пользователя внешнего провайдера при первом входе или обновляет
+	// права уже созданного. Чужой пользователь с тем же именем дает ErrUserExists.
+	ProvisionUser(account ExternalAccount) (*models.User, error)
+	// DeleteUser удаляет пользователя. Его файлы и папки переходят к reassignTo,
+	// а при reassignTo == 0 файлы удаляются; возвращаются ключи содержимого для удаления.
+	DeleteUser(userID, reassignTo int) ([]string, error)
 }
 
 // SQLiteUserStore реализация UserStore для SQLite
 type SQLiteUserStore struct {
-	db *sql.DB
+	db     *sql.DB
+	policy PasswordPolicy
 }
 
-// NewUserStore создает новый экземпляр UserStore
-func NewUserStore(db *sql.DB) UserStore {
-	return &SQLiteUserStore{db: db}
+// NewUserStore создает новый экземпляр UserStore. Пароли новых пользователей
+// и новые пароли проверяются по policy.
+func NewUserStore(db *sql.DB, policy PasswordPolicy) UserStore {
+	return &SQLiteUserStore{db: db, policy: policy}
 }
 
-// CreateUser создает нового пользователя в базе данных
-func (s *SQLiteUserStore) CreateUser(username, password string, canUpload, canDownload, isAdmin bool) error {
+// effectivePermission выражение итогового флага flag пользователя u: флаг его роли r
+// (или собственный, если роль не назначена), объединенный с флагами ролей его групп
+func effectivePermission(flag string) string {
+	return `(COALESCE(r.` + flag + `, u.` + flag + `) OR EXISTS (
+		SELECT 1 FROM group_members gm
+		JOIN groups g ON g.id = gm.group_id JOIN roles gr ON gr.id = g.role_id
+		WHERE gm.user_id = u.id AND gr.` + flag + `))`
+}
+
+// userColumns столбцы для scanUser; таблицы users u и roles r соединяет userJoins
+var userColumns = "u.id, u.username, u.password_hash, " +
+	effectivePermission("can_upload") + ", " +
+	effectivePermission("can_download") + ", " +
+	effectivePermission("is_admin") + ", " +
+	"COALESCE(u.role_id, 0), COALESCE(r.name, ''), u.disabled, u.must_change_password, u.totp_secret IS NOT NULL, u.auth_source"
+
+// userJoins соединения для userColumns
+const userJoins = " FROM users u LEFT JOIN roles r ON r.id = u.role_id"
+
+// scanUser читает строку со столбцами userColumns
+func scanUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
+	var user models.User
+	err := row.Scan(&user.ID, &user.Username, &user.PasswordHash,
+		&user.CanUpload, &user.CanDownload, &user.IsAdmin, &user.RoleID, &user.Role, &user.Disabled, &user.MustChangePassword, &user.TOTPEnabled, &user.AuthSource)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// grantRootAccess дает пользователям права на корень хранилища по их флагам: запись, если
+// можно загружать, иначе чтение. Так миграция сохранила доступ пользователям, которые были
+// до появления ACL, и так же его получает каждый новый пользователь, иначе с правом загрузки
+// или скачивания по роли он не увидел бы ни одного файла. Администраторам запись не нужна.
+// Администратор может изменить или убрать ее на странице доступа к корню.
+const grantRootAccess = `
+	INSERT OR IGNORE INTO acl_entries (resource_type, resource_id, principal_type, principal_id, permission)
+	SELECT 'folder', 0, 'user', id, CASE WHEN can_upload THEN 'write' ELSE 'read' END
+	FROM users WHERE NOT is_admin AND (can_upload OR can_download)`
+
+// grantNewUserRootAccess дает права на корень только что созданному пользователю
+func grantNewUserRootAccess(tx *sql.Tx, userID int64) error {
+	if _, err := tx.Exec(grantRootAccess+" AND id = ?", userID); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return nil
+}
+
+// CreateUser создает нового пользователя с ролью roleID и правами на корень по этой роли
+func (s *SQLiteUserStore) CreateUser(username, password string, roleID int) error {
+	if err := s.policy.Validate(password); err != nil {
+		return err
+	}
+
 	// Хэшируем пароль
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	// Вставляем пользователя в БД
-	_, err = s.db.Exec(
-		"INSERT INTO users (username, password_hash, can_upload, can_download, is_admin) VALUES (?, ?, ?, ?, ?)",
-		username, string(hashedPassword), canUpload, canDownload, isAdmin,
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Вставляем пользователя в БД. Флаги копируем из роли, чтобы они совпадали с ней,
+	// если роль когда-нибудь снимут.
+	result, err := tx.Exec(`
+		INSERT INTO users (username, password_hash, can_upload, can_download, is_admin, role_id)
+		SELECT ?, ?, can_upload, can_download, is_admin, id FROM roles WHERE id = ?`,
+		username, string(hashedPassword), roleID,
 	)
 
 	if err != nil {
 		if err.Error() == "UNIQUE constraint failed: users.username" {
-			return fmt.Errorf("username already exists")
+			return ErrUserExists
 		}
 		return fmt.Errorf("database error: %w", err)
 	}
+	if err := checkAffected(result, ErrRoleNotFound); err != nil {
+		return err
+	}
 
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if err := grantNewUserRootAccess(tx, userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
 	return nil
 }
 
-// GetUserByUsername возвращает пользователя по имени
-func (s *SQLiteUserStore) GetUserByUsername(username string) (*models.User, error) {
-	var user models.User
-	err := s.db.QueryRow(
-		"SELECT id, username, password_hash, can_upload, can_download, is_admin FROM users WHERE username = ?",
-		username,
-	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.CanUpload, &user.CanDownload, &user.IsAdmin)
-
+// getUser возвращает пользователя по условию where с одним параметром
+func (s *SQLiteUserStore) getUser(where string, arg interface{}) (*models.User, error) {
+	user, err := scanUser(s.db.QueryRow("SELECT "+userColumns+userJoins+" WHERE "+where, arg))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("database error: %w", err)
 	}
+	return user, nil
+}
 
-	return &user, nil
+// GetUserByUsername возвращает пользователя по имени
+func (s *SQLiteUserStore) GetUserByUsername(username string) (*models.User, error) {
+	return s.getUser("u.username = ?", username)
+}
+
+// GetUserByID возвращает пользователя по ID
+func (s *SQLiteUserStore) GetUserByID(userID int) (*models.User, error) {
+	return s.getUser("u.id = ?", userID)
 }
 
 // GetAllUsers возвращает всех пользователей
 func (s *SQLiteUserStore) GetAllUsers() ([]models.User, error) {
-	rows, err := s.db.Query("SELECT id, username, can_upload, can_download, is_admin FROM users ORDER BY id")
+	return queryUsers(s.db, "SELECT "+userColumns+userJoins+" ORDER BY u.id")
+}
+
+// queryUsers выполняет запрос со столбцами userColumns и читает всех пользователей
+func queryUsers(db *sql.DB, query string, args ...interface{}) ([]models.User, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("database error: %w", err)
 	}
@@ -79,12 +189,11 @@ func (s *SQLiteUserStore) GetAllUsers() ([]models.User, error) {
 
 	var users []models.User
 	for rows.Next() {
-		var user models.User
-		err := rows.Scan(&user.ID, &user.Username, &user.CanUpload, &user.CanDownload, &user.IsAdmin)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
-		users = append(users, user)
+		users = append(users, *user)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -94,27 +203,275 @@ func (s *SQLiteUserStore) GetAllUsers() ([]models.User, error) {
 	return users, nil
 }
 
+// SetUserRole назначает пользователю роль
+func (s *SQLiteUserStore) SetUserRole(userID, roleID int) error {
+	var exists int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM roles WHERE id = ?", roleID).Scan(&exists); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if exists == 0 {
+		return ErrRoleNotFound
+	}
+
+	result, err := s.db.Exec("UPDATE users SET role_id = ? WHERE id = ?", roleID, userID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrUserNotFound)
+}
+
 // VerifyUserCredentials проверяет логин и пароль пользователя
 func (s *SQLiteUserStore) VerifyUserCredentials(username, password string) (*models.User, error) {
 	user, err := s.GetUserByUsername(username)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrInvalidCredentials
+	}
 	if err != nil {
-		return nil, err // Пользователь не найден
+		return nil, err
 	}
 
-	// Сравниваем пароль с хэшем
+	// Сравниваем пароль с хэшем. У пользователей внешних провайдеров хэша нет,
+	// и сравнение всегда завершается ошибкой.
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
-		return nil, fmt.Errorf("invalid password")
+		return nil, ErrInvalidCredentials
+	}
+
+	// О блокировке сообщаем только тому, кто знает пароль
+	if user.Disabled {
+		return nil, ErrUserDisabled
 	}
 
 	return user, nil
 }
 
-// DeleteUser удаляет пользователя по ID
-func (s *SQLiteUserStore) DeleteUser(userID int) error {
-	_, err := s.db.Exec("DELETE FROM users WHERE id = ?", userID)
+// externalPasswordHash значение password_hash пользователей внешних провайдеров: не является
+// bcrypt-хэшем, поэтому локальный вход для них невозможен
+const externalPasswordHash = "!"
+
+// ExternalAccount пользователь внешнего провайдера для ProvisionUser
+type ExternalAccount struct {
+	Username string
+	Source   string
+	// ExternalID постоянный идентификатор у провайдера (например, sub в OpenID Connect).
+	// Если он задан, запись с тем же именем, но другим идентификатором не обновляется.
+	ExternalID string
+	// RoleID роль пользователя; 0 - вместо роли действуют собственные флаги ниже
+	RoleID      int
+	CanUpload   bool
+	CanDownload bool
+	IsAdmin     bool
+}
+
+// ProvisionUser создает или обновляет пользователя внешнего провайдера. Права на корень
+// новый пользователь получает, как в CreateUser; при обновлении ACL не меняется.
+func (s *SQLiteUserStore) ProvisionUser(account ExternalAccount) (*models.User, error) {
+	// Флаги роли копируем в пользователя, как в CreateUser
+	if account.RoleID != 0 {
+		err := s.db.QueryRow("SELECT can_upload, can_download, is_admin FROM roles WHERE id = ?", account.RoleID).
+			Scan(&account.CanUpload, &account.CanDownload, &account.IsAdmin)
+		if err == sql.ErrNoRows {
+			return nil, ErrRoleNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", account.Username).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	// Запись с тем же именем обновляется, только если она пришла из того же провайдера
+	// и принадлежит тому же внешнему пользователю
+	_, err = tx.Exec(`
+		INSERT INTO users (username, password_hash, can_upload, can_download, is_admin, role_id, auth_source, external_id)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, 0), ?, NULLIF(?, ''))
+		ON CONFLICT (username) DO UPDATE SET role_id = excluded.role_id,
+			can_upload = excluded.can_upload, can_download = excluded.can_download, is_admin = excluded.is_admin,
+			external_id = COALESCE(users.external_id, excluded.external_id)
+		WHERE users.auth_source = excluded.auth_source
+			AND (users.external_id IS NULL OR excluded.external_id IS NULL OR users.external_id = excluded.external_id)`,
+		account.Username, externalPasswordHash, account.CanUpload, account.CanDownload, account.IsAdmin,
+		account.RoleID, account.Source, account.ExternalID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	var userID int64
+	var source, externalID string
+	err = tx.QueryRow("SELECT id, auth_source, COALESCE(external_id, '') FROM users WHERE username = ?", account.Username).
+		Scan(&userID, &source, &externalID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if source != account.Source || (account.ExternalID != "" && externalID != account.ExternalID) {
+		return nil, ErrUserExists
+	}
+	if exists == 0 {
+		if err := grantNewUserRootAccess(tx, userID); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return s.GetUserByUsername(account.Username)
+}
+
+// UpdateUserPermissions изменяет собственные права пользователя. Роль снимается,
+// иначе флаги роли перекрыли бы новые значения.
+func (s *SQLiteUserStore) UpdateUserPermissions(userID int, canUpload, canDownload, isAdmin bool) error {
+	result, err := s.db.Exec(
+		"UPDATE users SET can_upload = ?, can_download = ?, is_admin = ?, role_id = NULL WHERE id = ?",
+		canUpload, canDownload, isAdmin, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrUserNotFound)
+}
+
+// SetPassword задает пользователю новый пароль
+func (s *SQLiteUserStore) SetPassword(userID int, password string, mustChange bool) error {
+	if err := s.policy.Validate(password); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE users SET password_hash = ?, must_change_password = ? WHERE id = ?",
+		string(hashedPassword), mustChange, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrUserNotFound)
+}
+
+// SetDisabled блокирует или разблокирует пользователя
+func (s *SQLiteUserStore) SetDisabled(userID int, disabled bool) error {
+	result, err := s.db.Exec("UPDATE users SET disabled = ? WHERE id = ?", disabled, userID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	return checkAffected(result, ErrUserNotFound)
+}
+
+// DeleteUser удаляет пользователя по ID вместе с его API-токенами, членством в группах и правами доступа
+func (s *SQLiteUserStore) DeleteUser(userID, reassignTo int) ([]string, error) {
+	if reassignTo == userID {
+		return nil, fmt.Errorf("cannot reassign files to the deleted user")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+	if reassignTo != 0 {
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", reassignTo).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		if exists == 0 {
+			return nil, ErrUserNotFound
+		}
+	}
+
+	var username string
+	err = tx.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", userID); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	// Счетчик неудачных входов ведется по имени: новый пользователь с тем же именем
+	// не должен унаследовать задержку или блокировку
+	if _, err := tx.Exec("DELETE FROM login_failures WHERE key = ?", accountKey(username)); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	var keys []string
+	var cleanup []string
+	if reassignTo != 0 {
+		// Файлы, папки и публичные ссылки продолжают жить у нового владельца
+		for _, table := range []string{"files", "folders", "shares"} {
+			if _, err := tx.Exec("UPDATE "+table+" SET owner_id = ? WHERE owner_id = ?", reassignTo, userID); err != nil {
+				return nil, fmt.Errorf("database error: %w", err)
+			}
+		}
+	} else {
+		rows, err := tx.Query(`
+			SELECT DISTINCT v.stored_key FROM file_versions v JOIN files f ON f.id = v.file_id
+			WHERE f.owner_id = ?`, userID)
+		if err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan version: %w", err)
+			}
+			keys = append(keys, key)
+		}
+		rows.Close()
+
+		// Папки не удаляем: в них могут лежать файлы других пользователей
+		cleanup = append(cleanup,
+			"DELETE FROM file_versions WHERE file_id IN (SELECT id FROM files WHERE owner_id = ?)",
+			"DELETE FROM shares WHERE file_id IN (SELECT id FROM files WHERE owner_id = ?)",
+			"DELETE FROM acl_entries WHERE resource_type = 'file' AND resource_id IN (SELECT id FROM files WHERE owner_id = ?)",
+			"DELETE FROM files WHERE owner_id = ?",
+			"UPDATE folders SET owner_id = NULL WHERE owner_id = ?",
+			"DELETE FROM shares WHERE owner_id = ?",
+		)
+	}
+
+	cleanup = append(cleanup,
+		"DELETE FROM api_tokens WHERE user_id = ?",
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM recovery_codes WHERE user_id = ?",
+		"DELETE FROM group_members WHERE user_id = ?",
+		"DELETE FROM acl_entries WHERE principal_type = 'user' AND principal_id = ?",
+	)
+	for _, statement := range cleanup {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return keys, nil
+}
+
+// checkAffected возвращает notFound, если запрос не затронул ни одной строки
+func checkAffected(result sql.Result, notFound error) error {
+	affected, err := result.RowsAffected()
 	if err != nil {
 		return fmt.Errorf("database error: %w", err)
 	}
+	if affected == 0 {
+		return notFound
+	}
 	return nil
 }
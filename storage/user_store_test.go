@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"file-exchange-app/models"
+	"testing"
+	"time"
+)
+
+// rootPermission возвращает право пользователя на корень из ACL; "" - записи нет
+func rootPermission(t *testing.T, userID int) string {
+	t.Helper()
+	entries, err := ACLStoreInstance.ListEntries(models.ResourceFolder, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.PrincipalType == models.PrincipalUser && entry.PrincipalID == userID {
+			return entry.Permission
+		}
+	}
+	return ""
+}
+
+func TestNewUsersGetRootAccess(t *testing.T) {
+	initTestDB(t)
+	noAccess, err := RoleStoreInstance.CreateRole("guest", false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		username string
+		role     string
+		want     string
+	}{
+		{"uploader1", "uploader", models.PermissionWrite},
+		{"downloader1", "downloader", models.PermissionRead},
+		{"admin1", "admin", ""},
+		{"guest1", noAccess.Name, ""},
+	}
+	for _, tt := range tests {
+		role, err := RoleStoreInstance.GetRoleByName(tt.role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := UserStoreInstance.CreateUser(tt.username, "Password-123", role.ID); err != nil {
+			t.Fatalf("CreateUser(%s): %v", tt.username, err)
+		}
+		user, err := UserStoreInstance.GetUserByUsername(tt.username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rootPermission(t, user.ID); got != tt.want {
+			t.Errorf("root access of a new %s = %q, want %q", tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestProvisionedUsersGetRootAccess(t *testing.T) {
+	initTestDB(t)
+	role, err := RoleStoreInstance.GetRoleByName("downloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := ExternalAccount{Username: "carol", Source: models.AuthSourceLDAP, RoleID: role.ID}
+
+	user, err := UserStoreInstance.ProvisionUser(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rootPermission(t, user.ID); got != models.PermissionRead {
+		t.Fatalf("root access after the first sign-in = %q, want read", got)
+	}
+
+	// Повторный вход не возвращает право, которое администратор убрал
+	entries, err := ACLStoreInstance.ListEntries(models.ResourceFolder, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := ACLStoreInstance.Revoke(entry.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := UserStoreInstance.ProvisionUser(account); err != nil {
+		t.Fatal(err)
+	}
+	if got := rootPermission(t, user.ID); got != "" {
+		t.Errorf("root access after the next sign-in = %q, want none", got)
+	}
+}
+
+func TestDeleteUserForgetsLoginFailures(t *testing.T) {
+	initTestDB(t)
+	role, err := RoleStoreInstance.GetRoleByName("downloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UserStoreInstance.CreateUser("Dave", "Password-123", role.ID); err != nil {
+		t.Fatal(err)
+	}
+	user, err := UserStoreInstance.GetUserByUsername("Dave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Dave", "erin"} {
+		if _, err := LoginThrottleInstance.Attempt(name, "10.0.0.1", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := UserStoreInstance.DeleteUser(user.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	rows, err := DB.Query("SELECT key FROM login_failures WHERE key LIKE 'user:%' ORDER BY key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) != 1 || keys[0] != "user:erin" {
+		t.Errorf("login failures after deleting Dave = %v, want only user:erin", keys)
+	}
+}